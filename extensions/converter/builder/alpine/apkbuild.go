@@ -0,0 +1,170 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+// Package alpine converts the APKBUILD files of an aports tree.
+package alpine
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	. "github.com/mudler/luet/pkg/logger"
+
+	pkg "github.com/mudler/luet/pkg/package"
+	"github.com/mudler/luet/pkg/tree/builder/common"
+	"github.com/mudler/luet/pkg/tree/builder/gentoo"
+	"github.com/mudler/luet/pkg/tree/builder/shell"
+)
+
+const (
+	// RecipeFile is the name of the Alpine build recipes.
+	RecipeFile = "APKBUILD"
+	// DefaultArch is the CARCH used to source the APKBUILD files.
+	DefaultArch = "x86_64"
+)
+
+// postReleaseRegex matches the post-release suffixes of apk, that are
+// sorted as _p.
+var postReleaseRegex = regexp.MustCompile(`_(cvs|svn|git|hg)`)
+
+// dependRegex matches a dependency: !name<op>version
+var dependRegex = regexp.MustCompile(`^(!?)([^<>=~]+)(<=|>=|<|>|=|~)?(.*)$`)
+
+// APKBUILDParser parses the APKBUILD files of an aports tree:
+// <aports>/<repository>/<package>/APKBUILD. The repository
+// (main, community, ...) is the category of the packages.
+type APKBUILDParser struct {
+	Arch string
+}
+
+// NewAlpineBuilder returns the builder of an aports tree.
+func NewAlpineBuilder() *shell.Builder {
+	return shell.NewBuilder(RecipeFile, &APKBUILDParser{Arch: DefaultArch})
+}
+
+func (ap *APKBUILDParser) ScanRecipe(ctx context.Context, path string) (pkg.Packages, error) {
+	vars, err := shell.SourceFile(ctx, path, "CARCH="+ap.Arch)
+	if err != nil {
+		return nil, err
+	}
+
+	name := shell.String(vars, "pkgname")
+	pkgver := shell.String(vars, "pkgver")
+	if name == "" || pkgver == "" {
+		return nil, fmt.Errorf("pkgname or pkgver not set")
+	}
+
+	pack := &pkg.DefaultPackage{
+		Name:        name,
+		Category:    filepath.Base(filepath.Dir(filepath.Dir(path))),
+		Version:     Version(pkgver, shell.String(vars, "pkgrel")),
+		Description: shell.String(vars, "pkgdesc"),
+		License:     shell.String(vars, "license"),
+		Uri:         shell.SourceURIs(shell.List(vars, "source")),
+	}
+	if url := shell.String(vars, "url"); url != "" {
+		pack.AddLabel(shell.HomepageLabel, url)
+	}
+
+	pack.PackageRequires, pack.PackageConflicts = ParseDepends(shell.List(vars, "depends"))
+	buildRequires, _ := ParseDepends(shell.List(vars, "makedepends"))
+	common.SetBuildRequires(pack, buildRequires)
+
+	return pkg.Packages{pack}, nil
+}
+
+// Version returns the luet version of an Alpine package. The apk versions
+// are sorted as the Gentoo ones, with the VCS suffixes as post-releases.
+func Version(pkgver, pkgrel string) string {
+	v := pkgver
+	if pkgrel != "" {
+		v += "-r" + pkgrel
+	}
+	ans, err := gentoo.TranslateVersion(postReleaseRegex.ReplaceAllString(v, "_p"))
+	if err != nil {
+		Debug("Keeping version", v, err.Error())
+		return v
+	}
+	return ans
+}
+
+// ParseDepends returns the requires and the conflicts (!name) of a list
+// of apk dependencies. The virtual dependencies (so:, cmd:, pc:) and
+// the files are skipped. The category of the dependencies is not set.
+func ParseDepends(depends []string) ([]*pkg.DefaultPackage, []*pkg.DefaultPackage) {
+	requires := []*pkg.DefaultPackage{}
+	conflicts := []*pkg.DefaultPackage{}
+
+	for _, d := range depends {
+		m := dependRegex.FindStringSubmatch(d)
+		if m == nil || strings.Contains(m[2], ":") || strings.HasPrefix(m[2], "/") {
+			Debug("Skip dependency", d)
+			continue
+		}
+
+		if m[1] == "!" {
+			conflicts = append(conflicts, dependency(m[2], m[3], m[4], true)...)
+		} else {
+			requires = append(requires, dependency(m[2], m[3], m[4], false)...)
+		}
+	}
+
+	return requires, conflicts
+}
+
+// dependency returns the packages of a dependency with the given operator
+// and version. The fuzzy match (~) selects the versions with the given
+// prefix, as the Gentoo =<version>*: a range of versions is required with
+// a package for every selector, while a conflict can use only one.
+func dependency(name, op, v string, conflict bool) []*pkg.DefaultPackage {
+	dep := &pkg.DefaultPackage{Name: name}
+	if v == "" {
+		return []*pkg.DefaultPackage{dep}
+	}
+	if op != "~" {
+		version := Version(v, "")
+		switch op {
+		case "=":
+			dep.Version = version
+		default:
+			dep.Version = op + version
+		}
+		return []*pkg.DefaultPackage{dep}
+	}
+
+	v = postReleaseRegex.ReplaceAllString(v, "_p")
+	if conflict {
+		selector, err := gentoo.MatchVersionSelector(v)
+		if err != nil {
+			Debug("Skip version of dependency", name, err.Error())
+		}
+		dep.Version = selector
+		return []*pkg.DefaultPackage{dep}
+	}
+
+	selectors, err := gentoo.MatchVersionSelectors(v)
+	if err != nil {
+		Debug("Skip version of dependency", name, err.Error())
+		return []*pkg.DefaultPackage{dep}
+	}
+	ans := []*pkg.DefaultPackage{}
+	for _, s := range selectors {
+		ans = append(ans, &pkg.DefaultPackage{Name: name, Version: s})
+	}
+	return ans
+}
@@ -0,0 +1,201 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package alpine_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/mudler/luet/pkg/tree/builder/alpine"
+	"github.com/mudler/luet/pkg/tree/builder/common"
+	"github.com/mudler/luet/pkg/tree/builder/shell"
+	version "github.com/mudler/luet/pkg/versioner"
+)
+
+var _ = Describe("APKBUILD parser", func() {
+	var tmpdir string
+
+	write := func(file, content string) {
+		Expect(os.MkdirAll(filepath.Dir(file), os.ModePerm)).Should(BeNil())
+		Expect(ioutil.WriteFile(file, []byte(content), 0644)).Should(BeNil())
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = ioutil.TempDir("", "alpine")
+		Expect(err).Should(BeNil())
+
+		write(filepath.Join(tmpdir, "main", "foo", RecipeFile), `# Maintainer: Foo <foo@example.com>
+pkgname=foo
+pkgver=1.2.3
+pkgrel=2
+pkgdesc="The foo tool"
+url="https://example.com/foo"
+arch="all"
+license="MIT"
+depends="libbar>=2.0 so:libc.musl-x86_64.so.1 !foo-legacy /bin/sh"
+makedepends="$depends_dev autoconf"
+depends_dev="libbar-dev"
+subpackages="$pkgname-doc"
+source="https://example.com/$pkgname-$pkgver.tar.gz
+	fix-build.patch
+	$pkgname-extra.tar.gz::https://example.com/extra/$pkgver.tar.gz
+	"
+
+build() {
+	make
+}
+`)
+		write(filepath.Join(tmpdir, "main", "libbar", RecipeFile), `pkgname=libbar
+pkgver=2.1_git20200101
+pkgrel=0
+license="GPL-2.0-or-later"
+source="https://example.com/libbar-$pkgver.tar.gz"
+`)
+		write(filepath.Join(tmpdir, "community", "autoconf", RecipeFile), `pkgname=autoconf
+pkgver=2.69
+pkgrel=0
+`)
+		write(filepath.Join(tmpdir, "testing", "broken", RecipeFile), `pkgname="broken`)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpdir)
+	})
+
+	It("Parses an APKBUILD", func() {
+		parser := &APKBUILDParser{Arch: DefaultArch}
+		pkgs, err := parser.ScanRecipe(context.Background(), filepath.Join(tmpdir, "main", "foo", RecipeFile))
+		Expect(err).Should(BeNil())
+		Expect(len(pkgs)).Should(Equal(1))
+
+		p := pkgs[0].(*pkg.DefaultPackage)
+		Expect(p.GetCategory()).Should(Equal("main"))
+		Expect(p.GetName()).Should(Equal("foo"))
+		Expect(p.GetVersion()).Should(Equal("1.2.3.0.5000000005000000.2"))
+		Expect(p.GetDescription()).Should(Equal("The foo tool"))
+		Expect(p.GetLicense()).Should(Equal("MIT"))
+		Expect(p.GetLabels()[shell.HomepageLabel]).Should(Equal("https://example.com/foo"))
+		Expect(p.GetURI()).Should(Equal([]string{
+			"https://example.com/foo-1.2.3.tar.gz",
+			"https://example.com/extra/1.2.3.tar.gz",
+		}))
+		Expect(p.GetRequires()).Should(Equal([]*pkg.DefaultPackage{{Name: "libbar", Version: ">=2.0.0.0.5000000005000000"}}))
+		Expect(p.GetConflicts()).Should(Equal([]*pkg.DefaultPackage{{Name: "foo-legacy"}}))
+		Expect(common.GetBuildRequires(p)).Should(Equal([]*pkg.DefaultPackage{{Name: "autoconf"}}))
+	})
+
+	It("Translates the versions", func() {
+		for _, c := range []struct{ pkgver, pkgrel, version string }{
+			{"1.0", "0", "1.0.0.0.5000000005000000"},
+			{"1.0_rc1", "1", "1.0.0.0.4000000015000000.1"},
+			{"2.1_git20200101", "0", "2.1.0.0.6202001015000000"},
+			{"1.0.0.0.0.0.1", "0", "1.0.0.0.0.0.1-r0"},
+		} {
+			Expect(Version(c.pkgver, c.pkgrel)).Should(Equal(c.version), "%v", c)
+		}
+	})
+
+	It("Translates the fuzzy dependencies", func() {
+		requires, conflicts := ParseDepends([]string{"foo~1.2", "bar~1.2.3_git20200101", "!baz~2"})
+		Expect(requires).Should(Equal([]*pkg.DefaultPackage{
+			{Name: "foo", Version: ">=1.2"},
+			{Name: "foo", Version: "<1.3"},
+			{Name: "bar", Version: "=1.2.3.0.6202001015000000*"},
+		}))
+		Expect(conflicts).Should(Equal([]*pkg.DefaultPackage{{Name: "baz", Version: "=2.0.0*"}}))
+
+		v := version.DefaultVersioner()
+		for _, c := range []struct {
+			pkgver, pkgrel string
+			admit          bool
+		}{
+			{"1.2", "0", true},
+			{"1.2.3", "1", true},
+			{"1.2.3.4", "0", true},
+			{"1.2_rc1", "0", true},
+			{"1.20", "0", false},
+			{"1.3", "0", false},
+			{"1.1.9", "0", false},
+		} {
+			admit := true
+			for _, r := range requires[:2] {
+				admit = admit && v.ValidateSelector(Version(c.pkgver, c.pkgrel), r.GetVersion())
+			}
+			Expect(admit).Should(Equal(c.admit), "%v", c)
+		}
+	})
+
+	It("Generates the tree", func() {
+		db, err := NewAlpineBuilder().Generate(tmpdir)
+		Expect(err).Should(BeNil())
+		Expect(len(db.World())).Should(Equal(3))
+
+		p, err := db.FindPackage(&pkg.DefaultPackage{Category: "main", Name: "foo", Version: "1.2.3.0.5000000005000000.2"})
+		Expect(err).Should(BeNil())
+		Expect(p.GetRequires()).Should(Equal([]*pkg.DefaultPackage{{Category: "main", Name: "libbar", Version: ">=2.0.0.0.5000000005000000"}}))
+		Expect(p.GetConflicts()).Should(Equal([]*pkg.DefaultPackage{{Category: "main", Name: "foo-legacy"}}))
+		Expect(common.GetBuildRequires(p)).Should(Equal([]*pkg.DefaultPackage{{Category: "community", Name: "autoconf"}}))
+
+		_, err = db.FindPackage(&pkg.DefaultPackage{Category: "main", Name: "libbar", Version: "2.1.0.0.6202001015000000"})
+		Expect(err).Should(BeNil())
+	})
+
+	It("Reports the progress and stops when canceled", func() {
+		b := NewAlpineBuilder()
+		var last common.Progress
+		b.OnProgress = func(p common.Progress) {
+			last = p
+		}
+		_, err := b.Generate(tmpdir)
+		Expect(err).Should(BeNil())
+		Expect(last.Scanned).Should(Equal(4))
+		Expect(last.Total).Should(Equal(4))
+		Expect(last.Failed).Should(Equal(1))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = b.GenerateContext(ctx, tmpdir)
+		Expect(err).Should(Equal(context.Canceled))
+	})
+
+	It("Sources the recipes in a sandbox", func() {
+		secret := filepath.Join(tmpdir, "secret")
+		write(secret, "pkgdesc=leaked\n")
+		recipe := filepath.Join(tmpdir, "main", "foo", RecipeFile)
+
+		write(recipe, "pkgname=foo\n. "+secret+"\nlicense=$(< "+secret+")\necho foo > "+secret+"\necho foo > /dev/null\n")
+		vars, err := shell.SourceFile(context.Background(), recipe)
+		Expect(err).Should(BeNil())
+		Expect(shell.String(vars, "pkgname")).Should(Equal("foo"))
+		Expect(shell.String(vars, "pkgdesc")).Should(Equal(""))
+		Expect(shell.String(vars, "license")).Should(Equal(""))
+		data, err := ioutil.ReadFile(secret)
+		Expect(err).Should(BeNil())
+		Expect(string(data)).Should(Equal("pkgdesc=leaked\n"))
+
+		write(recipe, "pkgname=foo\nwhile true; do :; done\n")
+		_, err = shell.SourceFile(context.Background(), recipe)
+		Expect(err).Should(HaveOccurred())
+		Expect(err.Error()).Should(ContainSubstring("steps limit"))
+	})
+})
@@ -0,0 +1,132 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+// Package arch converts the PKGBUILD files of an Arch Linux tree.
+package arch
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	. "github.com/mudler/luet/pkg/logger"
+
+	pkg "github.com/mudler/luet/pkg/package"
+	"github.com/mudler/luet/pkg/tree/builder/common"
+	"github.com/mudler/luet/pkg/tree/builder/shell"
+)
+
+const (
+	// RecipeFile is the name of the Arch build recipes.
+	RecipeFile = "PKGBUILD"
+	// DefaultArch is the CARCH used to source the PKGBUILD files.
+	DefaultArch = "x86_64"
+	// EpochAnnotation is the package annotation with the epoch of the version.
+	EpochAnnotation = "arch_epoch"
+)
+
+// dependRegex matches a dependency: name<op>version
+var dependRegex = regexp.MustCompile(`^([^<>=]+)(<=|>=|<|>|=)?(.*)$`)
+
+// PKGBUILDParser parses the PKGBUILD files of a tree:
+// <tree>/<repository>/<package>/PKGBUILD. The repository
+// (core, extra, ...) is the category of the packages.
+type PKGBUILDParser struct {
+	Arch string
+}
+
+// NewArchBuilder returns the builder of a tree of PKGBUILD files.
+func NewArchBuilder() *shell.Builder {
+	return shell.NewBuilder(RecipeFile, &PKGBUILDParser{Arch: DefaultArch})
+}
+
+// ScanRecipe returns a package for every name of pkgname, the split
+// packages share the metadata of the PKGBUILD.
+func (ap *PKGBUILDParser) ScanRecipe(ctx context.Context, path string) (pkg.Packages, error) {
+	vars, err := shell.SourceFile(ctx, path, "CARCH="+ap.Arch)
+	if err != nil {
+		return nil, err
+	}
+
+	names := shell.List(vars, "pkgname")
+	pkgver := shell.String(vars, "pkgver")
+	if len(names) == 0 || pkgver == "" {
+		return nil, fmt.Errorf("pkgname or pkgver not set")
+	}
+
+	depends := append(shell.List(vars, "depends"), shell.List(vars, "depends_"+ap.Arch)...)
+	makedepends := append(shell.List(vars, "makedepends"), shell.List(vars, "makedepends_"+ap.Arch)...)
+	sources := append(shell.List(vars, "source"), shell.List(vars, "source_"+ap.Arch)...)
+
+	epoch := shell.String(vars, "epoch")
+	version, err := Version{Epoch: epoch, Ver: pkgver, Rel: shell.String(vars, "pkgrel")}.LuetVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	ans := pkg.Packages{}
+	for _, name := range names {
+		pack := &pkg.DefaultPackage{
+			Name:        name,
+			Category:    filepath.Base(filepath.Dir(filepath.Dir(path))),
+			Version:     version,
+			Description: shell.String(vars, "pkgdesc"),
+			License:     strings.Join(shell.List(vars, "license"), " "),
+			Uri:         shell.SourceURIs(sources),
+		}
+		if url := shell.String(vars, "url"); url != "" {
+			pack.AddLabel(shell.HomepageLabel, url)
+		}
+		if epoch != "" && epoch != "0" {
+			pack.AddAnnotation(EpochAnnotation, epoch)
+		}
+
+		pack.PackageRequires = ParseDepends(depends)
+		pack.PackageConflicts = ParseDepends(shell.List(vars, "conflicts"))
+		common.SetBuildRequires(pack, ParseDepends(makedepends))
+
+		ans = append(ans, pack)
+	}
+
+	return ans, nil
+}
+
+// ParseDepends returns the packages of a list of Arch dependencies.
+// The sonames (libfoo.so) are skipped and the category is not set.
+func ParseDepends(depends []string) []*pkg.DefaultPackage {
+	ans := []*pkg.DefaultPackage{}
+
+	for _, d := range depends {
+		m := dependRegex.FindStringSubmatch(d)
+		if m == nil || strings.HasSuffix(m[1], ".so") {
+			Debug("Skip dependency", d)
+			continue
+		}
+
+		dep := &pkg.DefaultPackage{Name: m[1]}
+		if m[3] != "" {
+			selector, err := ParseVersion(m[3]).LuetSelector(m[2])
+			if err != nil {
+				Debug("Skip version of dependency", m[1], err.Error())
+			}
+			dep.Version = selector
+		}
+		ans = append(ans, dep)
+	}
+
+	return ans
+}
@@ -0,0 +1,116 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package arch_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/mudler/luet/pkg/tree/builder/arch"
+	"github.com/mudler/luet/pkg/tree/builder/common"
+	"github.com/mudler/luet/pkg/tree/builder/shell"
+)
+
+var _ = Describe("PKGBUILD parser", func() {
+	var tmpdir string
+
+	write := func(file, content string) {
+		Expect(os.MkdirAll(filepath.Dir(file), os.ModePerm)).Should(BeNil())
+		Expect(ioutil.WriteFile(file, []byte(content), 0644)).Should(BeNil())
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = ioutil.TempDir("", "arch")
+		Expect(err).Should(BeNil())
+
+		write(filepath.Join(tmpdir, "extra", "foo", RecipeFile), `# Maintainer: Foo <foo@example.com>
+pkgbase=foo
+pkgname=('foo' 'foo-docs')
+pkgver=1.2.3
+pkgrel=2
+epoch=1
+pkgdesc="The foo tool"
+arch=('x86_64')
+url="https://example.com/foo"
+license=('GPL2' 'custom')
+depends=('glibc' 'libbar>=1:2.0-1' 'libfoo.so')
+depends_x86_64=('lib32-glibc')
+makedepends=('git' 'cmake')
+conflicts=('foo-git')
+source=("https://example.com/$pkgname-$pkgver.tar.gz"
+        "extra::git+https://example.com/extra.git#tag=v$pkgver"
+        'local.patch')
+
+package_foo() {
+	make install
+}
+`)
+		write(filepath.Join(tmpdir, "core", "glibc", RecipeFile), `pkgname=glibc
+pkgver=2.33
+pkgrel=5
+`)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpdir)
+	})
+
+	It("Parses a PKGBUILD", func() {
+		parser := &PKGBUILDParser{Arch: DefaultArch}
+		pkgs, err := parser.ScanRecipe(context.Background(), filepath.Join(tmpdir, "extra", "foo", RecipeFile))
+		Expect(err).Should(BeNil())
+		Expect(len(pkgs)).Should(Equal(2))
+		Expect(pkgs[1].GetName()).Should(Equal("foo-docs"))
+
+		p := pkgs[0].(*pkg.DefaultPackage)
+		Expect(p.GetCategory()).Should(Equal("extra"))
+		Expect(p.GetName()).Should(Equal("foo"))
+		Expect(p.GetVersion()).Should(Equal("1.1.2.3.100000000100000000.200002100000100000"))
+		Expect(p.GetAnnotations()[EpochAnnotation]).Should(Equal("1"))
+		Expect(p.GetDescription()).Should(Equal("The foo tool"))
+		Expect(p.GetLicense()).Should(Equal("GPL2 custom"))
+		Expect(p.GetLabels()[shell.HomepageLabel]).Should(Equal("https://example.com/foo"))
+		Expect(p.GetURI()).Should(Equal([]string{
+			"https://example.com/foo-1.2.3.tar.gz",
+			"git+https://example.com/extra.git#tag=v1.2.3",
+		}))
+		Expect(p.GetRequires()).Should(Equal([]*pkg.DefaultPackage{
+			{Name: "glibc"},
+			{Name: "libbar", Version: ">=1.2.0.0.100000000100000000.200001100000100000"},
+			{Name: "lib32-glibc"},
+		}))
+		Expect(p.GetConflicts()).Should(Equal([]*pkg.DefaultPackage{{Name: "foo-git"}}))
+		Expect(common.GetBuildRequires(p)).Should(Equal([]*pkg.DefaultPackage{{Name: "git"}, {Name: "cmake"}}))
+	})
+
+	It("Generates the tree", func() {
+		db, err := NewArchBuilder().Generate(tmpdir)
+		Expect(err).Should(BeNil())
+		Expect(len(db.World())).Should(Equal(3))
+
+		p, err := db.FindPackage(&pkg.DefaultPackage{Category: "extra", Name: "foo", Version: "1.1.2.3.100000000100000000.200002100000100000"})
+		Expect(err).Should(BeNil())
+		Expect(p.GetRequires()).Should(ContainElement(&pkg.DefaultPackage{Category: "core", Name: "glibc"}))
+		Expect(p.GetRequires()).Should(ContainElement(&pkg.DefaultPackage{Category: "extra", Name: "libbar", Version: ">=1.2.0.0.100000000100000000.200001100000100000"}))
+	})
+})
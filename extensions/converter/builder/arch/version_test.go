@@ -0,0 +1,175 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package arch_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	version "github.com/mudler/luet/pkg/versioner"
+
+	. "github.com/mudler/luet/pkg/tree/builder/arch"
+)
+
+var _ = Describe("Version", func() {
+
+	// Sorted as pacman does
+	sorted := []string{
+		"0.9-1",
+		"1.0a-1",
+		"1.0rc1-1",
+		"1.0rc2-1",
+		"1.0rc10-1",
+		"1.0-1",
+		"1.0-1.1",
+		"1.0-2",
+		"1.0-10",
+		"1.0.a-1",
+		"1.0.0-1",
+		"1.0.0.r5.gabc123-1",
+		"1.0.0.r12.gdef456-1",
+		"1.0.0.1-1",
+		"1.0.1-1",
+		"1.1-1",
+		"1.2-1",
+		"1.10-1",
+		"2.0rc1-1",
+		"2.0-1",
+		"1:0.9-1",
+	}
+
+	translate := func(v string) string {
+		l, err := ParseVersion(v).LuetVersion()
+		Expect(err).Should(BeNil(), v)
+		return l
+	}
+
+	It("Compares the versions", func() {
+		for i := range sorted {
+			for j := range sorted {
+				expected := 0
+				if i < j {
+					expected = -1
+				} else if i > j {
+					expected = 1
+				}
+				Expect(CompareVersions(ParseVersion(sorted[i]), ParseVersion(sorted[j]))).Should(Equal(expected),
+					"%s %s", sorted[i], sorted[j])
+			}
+		}
+
+		for _, c := range [][]string{
+			{"1.0-1", "0:1.0-1"},
+			{"1.01-1", "1.1-1"},
+			{"1.0_1-1", "1.0.1-1"},
+			{"1.0-1", "1.0"},
+		} {
+			Expect(CompareVersions(ParseVersion(c[0]), ParseVersion(c[1]))).Should(Equal(0), "%v", c)
+		}
+	})
+
+	It("Validates the versions", func() {
+		for _, v := range []string{"x:1.0-1", "1.0a123456789-1", "1.0-123456"} {
+			_, err := ParseVersion(v).LuetVersion()
+			Expect(err).ShouldNot(BeNil(), v)
+		}
+
+		_, err := ParseVersion("1.0").LuetSelector("~")
+		Expect(err).ShouldNot(BeNil())
+	})
+
+	It("Translates the versions", func() {
+		for _, c := range []struct{ arch, luet string }{
+			{"1.0-1", "0.1.0.0.100000000100000000.200001100000100000"},
+			{"1:1.2rc1-3", "1.1.2.0.44290000200000001.200003100000100000"},
+			{"1.2.3.r45.gabc123-1", "0.1.2.3.344000000200000045.200001100000100000"},
+		} {
+			Expect(translate(c.arch)).Should(Equal(c.luet), c.arch)
+		}
+	})
+
+	It("Is parsed by luet", func() {
+		for _, v := range sorted {
+			l := translate(v)
+
+			s, err := version.ParseVersion(l)
+			Expect(err).Should(BeNil())
+			Expect(s.Condition).Should(BeEquivalentTo(version.PkgCondEqual), l)
+			Expect(s.Version).Should(Equal(l))
+			Expect(version.DefaultVersioner().ValidateSelector(l, l)).Should(BeTrue(), l)
+		}
+	})
+
+	It("Is sorted by luet as pacman does", func() {
+		v := version.DefaultVersioner()
+
+		// luet ignores the trailing zero components
+		luetSorted := []string{}
+		for _, s := range sorted {
+			if s != "1.0.0-1" {
+				luetSorted = append(luetSorted, s)
+			}
+		}
+
+		translated := []string{}
+		for _, s := range luetSorted {
+			translated = append(translated, translate(s))
+		}
+		for i := range translated {
+			shuffled := append([]string{}, translated[i:]...)
+			shuffled = append(shuffled, translated[:i]...)
+			Expect(v.Sort(shuffled)).Should(Equal(translated))
+		}
+
+		for i := range luetSorted {
+			for j := range luetSorted {
+				a, b := translate(luetSorted[i]), translate(luetSorted[j])
+				Expect(v.ValidateSelector(a, "<"+b)).Should(Equal(i < j), "%s %s", a, b)
+				Expect(v.ValidateSelector(a, "<="+b)).Should(Equal(i <= j), "%s %s", a, b)
+				Expect(v.ValidateSelector(a, ">"+b)).Should(Equal(i > j), "%s %s", a, b)
+				Expect(v.ValidateSelector(a, ">="+b)).Should(Equal(i >= j), "%s %s", a, b)
+				Expect(v.ValidateSelector(a, b)).Should(Equal(i == j), "%s %s", a, b)
+			}
+		}
+
+		Expect(translate("1.0-1")).Should(Equal(translate("1.0.0-1")))
+	})
+
+	It("Selects the versions as pacman does", func() {
+		v := version.DefaultVersioner()
+		for _, op := range []string{"=", "<", "<=", ">", ">="} {
+			for _, a := range sorted {
+				for _, b := range sorted {
+					if a == "1.0.0-1" || b == "1.0.0-1" {
+						continue
+					}
+					// The dependencies without pkgrel match all the pkgrel
+					for _, r := range []Version{ParseVersion(b), {Epoch: ParseVersion(b).Epoch, Ver: ParseVersion(b).Ver}} {
+						s, err := r.LuetSelector(op)
+						Expect(err).Should(BeNil())
+
+						c := CompareVersions(ParseVersion(a), r)
+						expected := map[string]bool{
+							"=": c == 0, "<": c < 0, "<=": c <= 0, ">": c > 0, ">=": c >= 0,
+						}[op]
+						Expect(v.ValidateSelector(translate(a), s)).Should(Equal(expected),
+							"%s %s %s", a, op, r)
+					}
+				}
+			}
+		}
+	})
+})
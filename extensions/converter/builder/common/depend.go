@@ -0,0 +1,98 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package common
+
+import (
+	"strings"
+
+	pkg "github.com/mudler/luet/pkg/package"
+)
+
+const (
+	// BuildRequiresAnnotation is the package annotation that stores the
+	// build-time requirements of a converted package.
+	BuildRequiresAnnotation = "build_requires"
+	// BuildConflictsAnnotation is the package annotation that stores the
+	// build-time conflicts of a converted package.
+	BuildConflictsAnnotation = "build_conflicts"
+)
+
+// EncodeDeps serializes a list of packages as space separated
+// category/name@version entries, suitable for a package annotation.
+func EncodeDeps(deps []*pkg.DefaultPackage) string {
+	entries := make([]string, 0, len(deps))
+	for _, d := range deps {
+		entry := d.GetCategory() + "/" + d.GetName()
+		if d.GetVersion() != "" {
+			entry += "@" + d.GetVersion()
+		}
+		entries = append(entries, entry)
+	}
+	return strings.Join(entries, " ")
+}
+
+// DecodeDeps is the inverse of EncodeDeps.
+func DecodeDeps(s string) []*pkg.DefaultPackage {
+	ans := []*pkg.DefaultPackage{}
+	for _, entry := range strings.Fields(s) {
+		version := ""
+		if i := strings.Index(entry, "@"); i >= 0 {
+			version = entry[i+1:]
+			entry = entry[:i]
+		}
+		category := ""
+		if i := strings.Index(entry, "/"); i >= 0 {
+			category = entry[:i]
+			entry = entry[i+1:]
+		}
+		ans = append(ans, &pkg.DefaultPackage{
+			Name:     entry,
+			Category: category,
+			Version:  version,
+		})
+	}
+	return ans
+}
+
+// SetBuildRequires stores the build-time requirements of the package,
+// an empty list removes them.
+func SetBuildRequires(p *pkg.DefaultPackage, deps []*pkg.DefaultPackage) {
+	if len(deps) == 0 {
+		delete(p.Annotations, BuildRequiresAnnotation)
+		return
+	}
+	p.AddAnnotation(BuildRequiresAnnotation, EncodeDeps(deps))
+}
+
+// SetBuildConflicts stores the build-time conflicts of the package,
+// an empty list removes them.
+func SetBuildConflicts(p *pkg.DefaultPackage, deps []*pkg.DefaultPackage) {
+	if len(deps) == 0 {
+		delete(p.Annotations, BuildConflictsAnnotation)
+		return
+	}
+	p.AddAnnotation(BuildConflictsAnnotation, EncodeDeps(deps))
+}
+
+// GetBuildRequires returns the build-time requirements of a converted package.
+func GetBuildRequires(p pkg.Package) []*pkg.DefaultPackage {
+	return DecodeDeps(p.GetAnnotations()[BuildRequiresAnnotation])
+}
+
+// GetBuildConflicts returns the build-time conflicts of a converted package.
+func GetBuildConflicts(p pkg.Package) []*pkg.DefaultPackage {
+	return DecodeDeps(p.GetAnnotations()[BuildConflictsAnnotation])
+}
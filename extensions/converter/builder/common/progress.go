@@ -0,0 +1,95 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+// Package common contains the helpers shared by the builders of the
+// different distributions: the progress of a conversion and the
+// build-time dependencies of the converted packages.
+package common
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Progress is the state of a running conversion.
+type Progress struct {
+	// Scanned is the number of files scanned, Failed the ones
+	// not converted because of an error.
+	Scanned int
+	Failed  int
+	// Total is the number of files to scan.
+	Total   int
+	Elapsed time.Duration
+}
+
+// ETA returns the estimated time left, from the average time spent
+// on the files already scanned.
+func (p Progress) ETA() time.Duration {
+	if p.Scanned == 0 || p.Scanned >= p.Total {
+		return 0
+	}
+	return p.Elapsed / time.Duration(p.Scanned) * time.Duration(p.Total-p.Scanned)
+}
+
+func (p Progress) String() string {
+	return fmt.Sprintf("%d/%d scanned, %d failed, ETA %s",
+		p.Scanned, p.Total, p.Failed, p.ETA().Round(time.Second))
+}
+
+// ProgressFunc receives the progress of a conversion. The calls are
+// serialized, also with concurrent workers.
+type ProgressFunc func(Progress)
+
+// ProgressTracker counts the scanned files and notifies a ProgressFunc.
+type ProgressTracker struct {
+	progress Progress
+	start    time.Time
+	callback ProgressFunc
+
+	mutex sync.Mutex
+}
+
+// NewProgressTracker returns a tracker of total files. The callback
+// can be nil.
+func NewProgressTracker(total int, callback ProgressFunc) *ProgressTracker {
+	return &ProgressTracker{
+		progress: Progress{Total: total},
+		start:    time.Now(),
+		callback: callback,
+	}
+}
+
+// Done records a scanned file.
+func (t *ProgressTracker) Done(failed bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.progress.Scanned++
+	if failed {
+		t.progress.Failed++
+	}
+	t.progress.Elapsed = time.Since(t.start)
+	if t.callback != nil {
+		t.callback(t.progress)
+	}
+}
+
+// Get returns the current progress.
+func (t *ProgressTracker) Get() Progress {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.progress
+}
@@ -0,0 +1,265 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+// Package debian imports the packages of a Debian Packages or Sources index.
+package debian
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	. "github.com/mudler/luet/pkg/logger"
+
+	pkg "github.com/mudler/luet/pkg/package"
+	"github.com/mudler/luet/pkg/tree/builder/common"
+	"github.com/mudler/luet/pkg/tree/builder/shell"
+)
+
+const (
+	// DefaultCategory is the category of the imported packages.
+	DefaultCategory = "debian"
+	// VersionAnnotation is the package annotation with the Debian version.
+	VersionAnnotation = "debian_version"
+	// SectionLabel is the package label with the section of the package.
+	SectionLabel = "section"
+)
+
+// relationRegex matches a relation: name[:arch] [(op version)]
+var relationRegex = regexp.MustCompile(`^([^\s:(]+)(?::\S+)?\s*(?:\(\s*(<<|<=|=|>=|>>)\s*([^)\s]+)\s*\))?$`)
+
+// restrictionRegex matches the arch and build profile restrictions
+// of the build dependencies: [amd64] <!nocheck>
+var restrictionRegex = regexp.MustCompile(`\[[^\]]*\]|<[^>]*>`)
+
+// DebianBuilder imports the packages of a Packages or Sources index file,
+// also compressed with gzip. The source packages are recognized
+// by the Binary field.
+type DebianBuilder struct {
+	// Category of the imported packages.
+	Category string
+	// Mirror is the base url of the archive, used to set the URIs
+	// of the packages.
+	Mirror string
+}
+
+func NewDebianBuilder() *DebianBuilder {
+	return &DebianBuilder{Category: DefaultCategory}
+}
+
+func (b *DebianBuilder) Generate(file string) (pkg.PackageDatabase, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	pkgs, err := b.ParseIndex(r)
+	if err != nil {
+		return nil, err
+	}
+
+	db := pkg.NewInMemoryDatabase(false)
+	for _, p := range pkgs {
+		if _, err := db.FindPackage(p); err == nil {
+			Debug("Skip duplicated package", p.HumanReadableString())
+			continue
+		}
+		if _, err := db.CreatePackage(p); err != nil {
+			return db, err
+		}
+	}
+
+	return db, nil
+}
+
+// ParseIndex returns the packages of an index.
+func (b *DebianBuilder) ParseIndex(r io.Reader) (pkg.Packages, error) {
+	stanzas, err := ParseControl(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ans := pkg.Packages{}
+	for _, s := range stanzas {
+		if s["Package"] == "" || s["Version"] == "" {
+			continue
+		}
+		p, err := b.newPackage(s)
+		if err != nil {
+			Warning("Skip package", s["Package"], err.Error())
+			continue
+		}
+		ans = append(ans, p)
+	}
+	return ans, nil
+}
+
+func (b *DebianBuilder) newPackage(s map[string]string) (*pkg.DefaultPackage, error) {
+	version, err := Version(s["Version"])
+	if err != nil {
+		return nil, err
+	}
+	p := &pkg.DefaultPackage{
+		Name:     s["Package"],
+		Category: b.Category,
+		Version:  version,
+		Uri:      []string{},
+	}
+	p.AddAnnotation(VersionAnnotation, s["Version"])
+
+	// The first line is the synopsis
+	description := s["Description"]
+	if i := strings.Index(description, "\n"); i >= 0 {
+		description = description[:i]
+	}
+	p.SetDescription(description)
+	if section := s["Section"]; section != "" {
+		p.AddLabel(SectionLabel, section)
+	}
+	if homepage := s["Homepage"]; homepage != "" {
+		p.AddLabel(shell.HomepageLabel, homepage)
+	}
+
+	mirror := strings.TrimSuffix(b.Mirror, "/")
+	if _, ok := s["Binary"]; ok {
+		// Source package
+		common.SetBuildRequires(p, ParseRelations(s["Build-Depends"]+", "+s["Build-Depends-Indep"], b.Category))
+		common.SetBuildConflicts(p, ParseRelations(s["Build-Conflicts"]+", "+s["Build-Conflicts-Indep"], b.Category))
+		if mirror != "" {
+			for _, line := range strings.Split(s["Files"], "\n") {
+				// md5sum size name
+				if fields := strings.Fields(line); len(fields) == 3 {
+					p.AddURI(mirror + "/" + s["Directory"] + "/" + fields[2])
+				}
+			}
+		}
+		return p, nil
+	}
+
+	p.PackageRequires = ParseRelations(s["Pre-Depends"]+", "+s["Depends"], b.Category)
+	p.PackageConflicts = ParseRelations(s["Conflicts"]+", "+s["Breaks"], b.Category)
+	p.Provides = ParseRelations(s["Provides"], b.Category)
+	if mirror != "" && s["Filename"] != "" {
+		p.AddURI(mirror + "/" + s["Filename"])
+	}
+
+	return p, nil
+}
+
+// ParseControl parses the stanzas of a control file. The lines of
+// the multiline fields are joined with a newline.
+func ParseControl(r io.Reader) ([]map[string]string, error) {
+	ans := []map[string]string{}
+	current := map[string]string{}
+	field := ""
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.TrimSpace(line) == "":
+			if len(current) > 0 {
+				ans = append(ans, current)
+			}
+			current = map[string]string{}
+			field = ""
+		case line[0] == ' ' || line[0] == '\t':
+			if field == "" {
+				continue
+			}
+			value := strings.TrimSpace(line)
+			if value == "." {
+				value = ""
+			}
+			if current[field] == "" {
+				current[field] = value
+			} else {
+				current[field] += "\n" + value
+			}
+		case strings.HasPrefix(line, "#"):
+		default:
+			i := strings.Index(line, ":")
+			if i < 0 {
+				continue
+			}
+			field = line[:i]
+			current[field] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	if len(current) > 0 {
+		ans = append(ans, current)
+	}
+
+	return ans, scanner.Err()
+}
+
+// ParseRelations returns the packages of the given category from a comma
+// separated list of relations. Only the first package of the
+// alternatives (a | b) is used.
+func ParseRelations(s, category string) []*pkg.DefaultPackage {
+	ans := []*pkg.DefaultPackage{}
+
+	for _, r := range strings.Split(s, ",") {
+		r = strings.TrimSpace(strings.Split(r, "|")[0])
+		r = strings.TrimSpace(restrictionRegex.ReplaceAllString(r, ""))
+		if r == "" {
+			continue
+		}
+
+		m := relationRegex.FindStringSubmatch(r)
+		if m == nil {
+			Debug("Skip relation", r)
+			continue
+		}
+
+		dep := &pkg.DefaultPackage{Name: m[1], Category: category}
+		if m[3] != "" {
+			version, err := Version(m[3])
+			if err != nil {
+				Debug("Skip version of relation", r, err.Error())
+				ans = append(ans, dep)
+				continue
+			}
+			switch m[2] {
+			case "=":
+				dep.Version = version
+			case "<<":
+				dep.Version = "<" + version
+			case ">>":
+				dep.Version = ">" + version
+			default:
+				dep.Version = m[2] + version
+			}
+		}
+		ans = append(ans, dep)
+	}
+
+	return ans
+}
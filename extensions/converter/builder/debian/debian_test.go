@@ -0,0 +1,173 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package debian_test
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	pkg "github.com/mudler/luet/pkg/package"
+	"github.com/mudler/luet/pkg/tree/builder/common"
+	. "github.com/mudler/luet/pkg/tree/builder/debian"
+	"github.com/mudler/luet/pkg/tree/builder/shell"
+)
+
+const packagesIndex = `Package: foo
+Version: 1:1.2+dfsg-3
+Section: utils
+Pre-Depends: dpkg (>= 1.17.14)
+Depends: libc6 (>= 2.14), libbar1 (<< 3) | libbar2, python3:any
+Conflicts: foo-legacy
+Breaks: baz (<= 0.9)
+Provides: foo-tool (= 1.2)
+Homepage: https://example.com/foo
+Description: The foo tool
+ A longer description
+ .
+ of foo.
+Filename: pool/main/f/foo/foo_1.2+dfsg-3_amd64.deb
+
+Package: libbar1
+Version: 2.0-1
+Description: The bar library
+Filename: pool/main/b/bar/libbar1_2.0-1_amd64.deb
+
+Package: broken
+Version: x1
+Description: A package with an invalid version
+`
+
+const sourcesIndex = `Package: foo
+Binary: foo, foo-doc
+Version: 1.2-3
+Build-Depends: debhelper-compat (= 12), libbar-dev [linux-any], check <!nocheck>
+Build-Conflicts: autoconf2.13
+Directory: pool/main/f/foo
+Files:
+ d41d8cd98f00b204e9800998ecf8427e 1024 foo_1.2-3.dsc
+ d41d8cd98f00b204e9800998ecf8427e 2048 foo_1.2.orig.tar.gz
+`
+
+var _ = Describe("Debian importer", func() {
+	Context("ParseControl", func() {
+		It("Parses the stanzas and the multiline fields", func() {
+			stanzas, err := ParseControl(strings.NewReader(packagesIndex))
+			Expect(err).Should(BeNil())
+			Expect(len(stanzas)).To(Equal(3))
+			Expect(stanzas[0]["Package"]).To(Equal("foo"))
+			Expect(stanzas[0]["Description"]).To(Equal("The foo tool\nA longer description\n\nof foo."))
+			Expect(stanzas[1]["Version"]).To(Equal("2.0-1"))
+		})
+	})
+
+	Context("ParseRelations", func() {
+		It("Translates the operators and skips the alternatives", func() {
+			deps := ParseRelations("a (>> 1.0), b (<< 2), c (= 1.0-1), d (>= 3), e | f, g:any, h [amd64] <!nocheck>, i (>= x1)", "debian")
+			Expect(deps).To(Equal([]*pkg.DefaultPackage{
+				{Name: "a", Category: "debian", Version: ">" + luetVersion("1.0")},
+				{Name: "b", Category: "debian", Version: "<" + luetVersion("2")},
+				{Name: "c", Category: "debian", Version: luetVersion("1.0-1")},
+				{Name: "d", Category: "debian", Version: ">=" + luetVersion("3")},
+				{Name: "e", Category: "debian"},
+				{Name: "g", Category: "debian"},
+				{Name: "h", Category: "debian"},
+				{Name: "i", Category: "debian"},
+			}))
+		})
+	})
+
+	Context("Packages index", func() {
+		var tmpdir string
+
+		BeforeEach(func() {
+			var err error
+			tmpdir, err = ioutil.TempDir("", "debian")
+			Expect(err).Should(BeNil())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tmpdir)
+		})
+
+		It("Imports the binary packages", func() {
+			file := filepath.Join(tmpdir, "Packages.gz")
+			f, err := os.Create(file)
+			Expect(err).Should(BeNil())
+			gz := gzip.NewWriter(f)
+			_, err = gz.Write([]byte(packagesIndex))
+			Expect(err).Should(BeNil())
+			Expect(gz.Close()).Should(BeNil())
+			Expect(f.Close()).Should(BeNil())
+
+			b := NewDebianBuilder()
+			b.Mirror = "http://deb.debian.org/debian/"
+			db, err := b.Generate(file)
+			Expect(err).Should(BeNil())
+			Expect(len(db.World())).To(Equal(2))
+
+			p, err := db.FindPackage(&pkg.DefaultPackage{Name: "foo", Category: "debian", Version: luetVersion("1:1.2+dfsg-3")})
+			Expect(err).Should(BeNil())
+			Expect(p.GetDescription()).To(Equal("The foo tool"))
+			Expect(p.GetLabels()[SectionLabel]).To(Equal("utils"))
+			Expect(p.GetLabels()[shell.HomepageLabel]).To(Equal("https://example.com/foo"))
+			Expect(p.GetAnnotations()[VersionAnnotation]).To(Equal("1:1.2+dfsg-3"))
+			Expect(p.GetURI()).To(Equal([]string{"http://deb.debian.org/debian/pool/main/f/foo/foo_1.2+dfsg-3_amd64.deb"}))
+			Expect(p.GetRequires()).To(Equal([]*pkg.DefaultPackage{
+				{Name: "dpkg", Category: "debian", Version: ">=" + luetVersion("1.17.14")},
+				{Name: "libc6", Category: "debian", Version: ">=" + luetVersion("2.14")},
+				{Name: "libbar1", Category: "debian", Version: "<" + luetVersion("3")},
+				{Name: "python3", Category: "debian"},
+			}))
+			Expect(p.GetConflicts()).To(Equal([]*pkg.DefaultPackage{
+				{Name: "foo-legacy", Category: "debian"},
+				{Name: "baz", Category: "debian", Version: "<=" + luetVersion("0.9")},
+			}))
+			Expect(p.(*pkg.DefaultPackage).Provides).To(Equal([]*pkg.DefaultPackage{
+				{Name: "foo-tool", Category: "debian", Version: luetVersion("1.2")},
+			}))
+		})
+
+		It("Imports the source packages", func() {
+			b := NewDebianBuilder()
+			b.Mirror = "http://deb.debian.org/debian"
+			pkgs, err := b.ParseIndex(strings.NewReader(sourcesIndex))
+			Expect(err).Should(BeNil())
+			Expect(len(pkgs)).To(Equal(1))
+
+			p := pkgs[0].(*pkg.DefaultPackage)
+			Expect(p.GetVersion()).To(Equal(luetVersion("1.2-3")))
+			Expect(p.GetRequires()).To(BeEmpty())
+			Expect(common.GetBuildRequires(p)).To(Equal([]*pkg.DefaultPackage{
+				{Name: "debhelper-compat", Category: "debian", Version: luetVersion("12")},
+				{Name: "libbar-dev", Category: "debian"},
+				{Name: "check", Category: "debian"},
+			}))
+			Expect(common.GetBuildConflicts(p)).To(Equal([]*pkg.DefaultPackage{
+				{Name: "autoconf2.13", Category: "debian"},
+			}))
+			Expect(p.GetURI()).To(Equal([]string{
+				"http://deb.debian.org/debian/pool/main/f/foo/foo_1.2-3.dsc",
+				"http://deb.debian.org/debian/pool/main/f/foo/foo_1.2.orig.tar.gz",
+			}))
+		})
+	})
+})
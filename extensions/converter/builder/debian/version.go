@@ -0,0 +1,321 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package debian
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxComponents is the number of components of the upstream
+	// version stored as numbers of the luet version.
+	maxComponents = 3
+	// maxCharacters is the number of characters of a non-digit part
+	// that are compared.
+	maxCharacters = 2
+)
+
+var (
+	// upstreamWidths are the digits of the numbers of the parts of
+	// the upstream version that follow the components.
+	upstreamWidths = []int{8, 2}
+	// revisionWidths are the digits of the numbers of the parts of
+	// the revision that follow the first number.
+	revisionWidths = []int{4, 3}
+)
+
+// revisionNumberWidth is the number of digits of the first number
+// of the revision.
+const revisionNumberWidth = 3
+
+// versionPart is a non-digit string followed by a number, the unit
+// of the comparison of dpkg.
+type versionPart struct {
+	Text   string
+	Number string
+}
+
+// splitParts splits a version in its non-digit and digit parts.
+func splitParts(s string) []versionPart {
+	ans := []versionPart{}
+	for s != "" {
+		i := strings.IndexAny(s, "0123456789")
+		if i < 0 {
+			i = len(s)
+		}
+		j := i
+		for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+			j++
+		}
+		ans = append(ans, versionPart{Text: s[:i], Number: s[i:j]})
+		s = s[j:]
+	}
+	return ans
+}
+
+// DebianVersion is a Debian version: [epoch:]upstream[-revision].
+type DebianVersion struct {
+	Epoch    string
+	Upstream string
+	Revision string
+}
+
+// ParseDebianVersion parses a Debian version.
+func ParseDebianVersion(v string) (*DebianVersion, error) {
+	ans := &DebianVersion{Upstream: v}
+	if i := strings.Index(ans.Upstream, ":"); i >= 0 {
+		ans.Epoch, ans.Upstream = ans.Upstream[:i], ans.Upstream[i+1:]
+		if _, err := strconv.ParseUint(ans.Epoch, 10, 32); err != nil {
+			return nil, fmt.Errorf("invalid epoch of version %s", v)
+		}
+	}
+	if i := strings.LastIndex(ans.Upstream, "-"); i >= 0 {
+		ans.Upstream, ans.Revision = ans.Upstream[:i], ans.Upstream[i+1:]
+	}
+	if ans.Upstream == "" || ans.Upstream[0] < '0' || ans.Upstream[0] > '9' {
+		return nil, fmt.Errorf("version %s doesn't start with a digit", v)
+	}
+	return ans, nil
+}
+
+func (v *DebianVersion) String() string {
+	ans := v.Upstream
+	if v.Epoch != "" {
+		ans = v.Epoch + ":" + ans
+	}
+	if v.Revision != "" {
+		ans += "-" + v.Revision
+	}
+	return ans
+}
+
+// charOrder returns the weight of a character of a non-digit part, or of
+// its end: ~ is lower than the end, the letters are lower than the
+// other characters.
+func charOrder(s string, i int) int {
+	switch {
+	case i >= len(s):
+		return 0
+	case s[i] == '~':
+		return -1
+	case s[i] >= 'A' && s[i] <= 'Z', s[i] >= 'a' && s[i] <= 'z':
+		return int(s[i])
+	}
+	return int(s[i]) + 256
+}
+
+// compareText compares two non-digit parts.
+func compareText(a, b string) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		oa, ob := charOrder(a, i), charOrder(b, i)
+		if oa != ob {
+			if oa < ob {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// compareNumbers compares two strings of digits, empty is 0.
+func compareNumbers(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// compareParts compares two upstream versions or revisions as dpkg does.
+func compareParts(a, b string) int {
+	pa, pb := splitParts(a), splitParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var ea, eb versionPart
+		if i < len(pa) {
+			ea = pa[i]
+		}
+		if i < len(pb) {
+			eb = pb[i]
+		}
+		if c := compareText(ea.Text, eb.Text); c != 0 {
+			return c
+		}
+		if c := compareNumbers(ea.Number, eb.Number); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// CompareVersions compares two Debian versions with the algorithm of
+// dpkg and returns -1, 0 or 1.
+func CompareVersions(a, b *DebianVersion) int {
+	if c := compareNumbers(a.Epoch, b.Epoch); c != 0 {
+		return c
+	}
+	if c := compareParts(a.Upstream, b.Upstream); c != 0 {
+		return c
+	}
+	return compareParts(a.Revision, b.Revision)
+}
+
+// textCode returns the code of the first characters of a non-digit
+// part, two digits for every character, in the order of charOrder.
+func textCode(s string) (int64, error) {
+	var ans int64
+	for i := 0; i < maxCharacters; i++ {
+		var code int64
+		switch o := charOrder(s, i); {
+		case o == -1:
+			code = 1
+		case o == 0:
+			code = 2
+		case s[i] >= 'A' && s[i] <= 'Z':
+			code = int64(s[i]-'A') + 3
+		case s[i] >= 'a' && s[i] <= 'z':
+			code = int64(s[i]-'a') + 29
+		default:
+			j := strings.IndexByte("+-.:", s[i])
+			if j < 0 {
+				return 0, fmt.Errorf("invalid character %q", s[i])
+			}
+			code = int64(j) + 55
+		}
+		ans = ans*100 + code
+	}
+	return ans, nil
+}
+
+// parseNumber returns the value of a string of digits, if it has at
+// most width digits.
+func parseNumber(s string, width int) (int64, error) {
+	s = strings.TrimLeft(s, "0")
+	if s == "" {
+		return 0, nil
+	}
+	if len(s) > width {
+		return 0, fmt.Errorf("number %s is too long", s)
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// packParts returns a number with the codes of the text and the number
+// of the parts, one part for every width. The missing parts are empty.
+func packParts(parts []versionPart, widths []int) (int64, error) {
+	var ans int64
+	for i, width := range widths {
+		var p versionPart
+		if i < len(parts) {
+			p = parts[i]
+		}
+		code, err := textCode(p.Text)
+		if err != nil {
+			return 0, err
+		}
+		number, err := parseNumber(p.Number, width)
+		if err != nil {
+			return 0, err
+		}
+		ans = (ans*pow10(2*maxCharacters)+code)*pow10(width) + number
+	}
+	return ans, nil
+}
+
+func pow10(n int) int64 {
+	ans := int64(1)
+	for i := 0; i < n; i++ {
+		ans *= 10
+	}
+	return ans
+}
+
+// LuetVersion returns the luet version of a Debian version.
+//
+// luet compares only the numeric components of a version, so the Debian
+// version is encoded as six numbers: the epoch, three components of the
+// upstream version, the rest of the upstream version and the revision.
+// The components are the numbers separated by dots at the start of the
+// upstream version. The rest of the upstream version is encoded as two
+// parts, the revision as its first number followed by two parts: a part
+// is the code of the first two characters of a non-digit string, in the
+// dpkg order, followed by the number after it. In this way luet sorts the
+// versions as dpkg does, with the exception of the characters and the
+// parts not encoded and of the missing components, that are equal to 0,
+// e.g. 1.2 and 1.2.0 have the same luet version.
+func (v *DebianVersion) LuetVersion() (string, error) {
+	epoch, err := parseNumber(v.Epoch, 9)
+	if err != nil {
+		return "", fmt.Errorf("invalid epoch of version %s", v)
+	}
+	ans := []string{strconv.FormatInt(epoch, 10)}
+
+	// the components are the numbers at the start separated by dots
+	parts := splitParts(v.Upstream)
+	i := 0
+	for ; i < len(parts) && i < maxComponents; i++ {
+		if i > 0 && (parts[i].Text != "." || parts[i].Number == "") {
+			break
+		}
+		n, err := parseNumber(parts[i].Number, 18)
+		if err != nil {
+			return "", fmt.Errorf("invalid component of version %s: %v", v, err)
+		}
+		ans = append(ans, strconv.FormatInt(n, 10))
+	}
+	for len(ans) <= maxComponents {
+		ans = append(ans, "0")
+	}
+
+	rest := parts[i:]
+	upstream, err := packParts(rest, upstreamWidths)
+	if err != nil {
+		return "", fmt.Errorf("invalid upstream version %s: %v", v, err)
+	}
+	ans = append(ans, strconv.FormatInt(upstream, 10))
+
+	parts = splitParts(v.Revision)
+	var number int64
+	if len(parts) > 0 && parts[0].Text == "" {
+		number, err = parseNumber(parts[0].Number, revisionNumberWidth)
+		if err != nil {
+			return "", fmt.Errorf("invalid revision of version %s: %v", v, err)
+		}
+		parts = parts[1:]
+	}
+	revision, err := packParts(parts, revisionWidths)
+	if err != nil {
+		return "", fmt.Errorf("invalid revision of version %s: %v", v, err)
+	}
+	ans = append(ans, strconv.FormatInt(number*pow10(18-revisionNumberWidth)+revision, 10))
+
+	return strings.Join(ans, "."), nil
+}
+
+// Version returns the luet version of a Debian version.
+func Version(v string) (string, error) {
+	dv, err := ParseDebianVersion(v)
+	if err != nil {
+		return "", err
+	}
+	return dv.LuetVersion()
+}
@@ -0,0 +1,160 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package debian_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	version "github.com/mudler/luet/pkg/versioner"
+
+	. "github.com/mudler/luet/pkg/tree/builder/debian"
+)
+
+// luetVersion returns the luet version of a Debian version.
+func luetVersion(v string) string {
+	l, err := Version(v)
+	Expect(err).Should(BeNil(), v)
+	return l
+}
+
+var _ = Describe("Version", func() {
+
+	// Sorted as dpkg does
+	sorted := []string{
+		"0.9",
+		"1.0~~",
+		"1.0~",
+		"1.0~beta1",
+		"1.0~rc1",
+		"1.0~rc2",
+		"1.0~rc10",
+		"1.0",
+		"1.0-1",
+		"1.0-1ubuntu1",
+		"1.0-1+deb10u1",
+		"1.0-2",
+		"1.0-10",
+		"1.0a",
+		"1.0+dfsg-1",
+		"1.0.0",
+		"1.0.1",
+		"1.1",
+		"1.2",
+		"1.10",
+		"2.0~rc1",
+		"2.0",
+		"1:0.9",
+	}
+
+	parse := func(v string) *DebianVersion {
+		dv, err := ParseDebianVersion(v)
+		Expect(err).Should(BeNil(), v)
+		return dv
+	}
+
+	It("Compares the versions", func() {
+		for i := range sorted {
+			for j := range sorted {
+				expected := 0
+				if i < j {
+					expected = -1
+				} else if i > j {
+					expected = 1
+				}
+				Expect(CompareVersions(parse(sorted[i]), parse(sorted[j]))).Should(Equal(expected),
+					"%s %s", sorted[i], sorted[j])
+			}
+		}
+
+		for _, c := range [][]string{
+			{"1.0", "1.0-0"},
+			{"1.0", "0:1.0"},
+			{"1.01", "1.1"},
+		} {
+			Expect(CompareVersions(parse(c[0]), parse(c[1]))).Should(Equal(0), "%v", c)
+		}
+	})
+
+	It("Validates the versions", func() {
+		for _, v := range []string{"", "a1.0", "x:1.0", "1:", "1.0-1:2"} {
+			_, err := Version(v)
+			Expect(err).ShouldNot(BeNil(), v)
+		}
+
+		for _, v := range []string{"1.0a123456789", "1.0-1234", "1.0-1ubuntu12345", "1.0=1"} {
+			_, err := Version(v)
+			Expect(err).ShouldNot(BeNil(), v)
+		}
+	})
+
+	It("Translates the versions", func() {
+		for _, c := range []struct{ debian, luet string }{
+			{"1.0", "0.1.0.0.20200000000020200.20200000202000"},
+			{"1:1.2+dfsg-3", "1.1.2.0.553200000000020200.3020200000202000"},
+			{"2.0~rc1-1ubuntu2", "0.2.0.0.14600000001020200.1493000020202000"},
+		} {
+			Expect(luetVersion(c.debian)).Should(Equal(c.luet), c.debian)
+		}
+	})
+
+	It("Is parsed by luet", func() {
+		for _, v := range sorted {
+			l := luetVersion(v)
+
+			s, err := version.ParseVersion(l)
+			Expect(err).Should(BeNil())
+			Expect(s.Condition).Should(BeEquivalentTo(version.PkgCondEqual), l)
+			Expect(s.Version).Should(Equal(l))
+			Expect(version.DefaultVersioner().ValidateSelector(l, l)).Should(BeTrue(), l)
+		}
+	})
+
+	It("Is sorted by luet as dpkg does", func() {
+		v := version.DefaultVersioner()
+
+		// luet ignores the trailing zero components
+		luetSorted := []string{}
+		for _, s := range sorted {
+			if s != "1.0.0" {
+				luetSorted = append(luetSorted, s)
+			}
+		}
+
+		translated := []string{}
+		for _, s := range luetSorted {
+			translated = append(translated, luetVersion(s))
+		}
+		for i := range translated {
+			shuffled := append([]string{}, translated[i:]...)
+			shuffled = append(shuffled, translated[:i]...)
+			Expect(v.Sort(shuffled)).Should(Equal(translated))
+		}
+
+		for i := range luetSorted {
+			for j := range luetSorted {
+				a, b := luetVersion(luetSorted[i]), luetVersion(luetSorted[j])
+				Expect(v.ValidateSelector(a, "<"+b)).Should(Equal(i < j), "%s %s", a, b)
+				Expect(v.ValidateSelector(a, "<="+b)).Should(Equal(i <= j), "%s %s", a, b)
+				Expect(v.ValidateSelector(a, ">"+b)).Should(Equal(i > j), "%s %s", a, b)
+				Expect(v.ValidateSelector(a, ">="+b)).Should(Equal(i >= j), "%s %s", a, b)
+				Expect(v.ValidateSelector(a, b)).Should(Equal(i == j), "%s %s", a, b)
+			}
+		}
+
+		Expect(luetVersion("1.0")).Should(Equal(luetVersion("1.0.0")))
+	})
+})
@@ -0,0 +1,53 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo
+
+import (
+	"path/filepath"
+	"strings"
+
+	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
+)
+
+// MatchAtom returns true if the package matches the atom. The atom could be
+// a package name (cat/pkg), a versioned atom (>=cat/pkg-1.0) or a glob on
+// category and name (cat/*, */pkg).
+func MatchAtom(atom string, gp *_gentoo.GentooPackage) bool {
+	atom = strings.TrimSpace(atom)
+	if atom == "" {
+		return false
+	}
+
+	if strings.ContainsAny(atom, "*?[") && !strings.HasPrefix(atom, "=") {
+		// Glob on category/name
+		match, err := filepath.Match(atom, gp.Category+"/"+gp.Name)
+		return err == nil && match
+	}
+
+	p, err := _gentoo.ParsePackageStr(atom)
+	if err != nil {
+		return false
+	}
+	if p.Category != gp.Category || p.Name != gp.Name {
+		return false
+	}
+	if !strings.Contains(atom, ":") {
+		p.Slot = ""
+	}
+
+	admit, err := p.Admit(gp)
+	return err == nil && admit
+}
@@ -0,0 +1,211 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
+	pkg "github.com/mudler/luet/pkg/package"
+	tree "github.com/mudler/luet/pkg/tree"
+	"github.com/mudler/luet/pkg/tree/builder/common"
+)
+
+const (
+	// DefaultBuildImage is the image used to build the packages without
+	// build requires.
+	DefaultBuildImage = "gentoo/stage3-amd64"
+
+	// DefaultBuildSpecTemplate is the default template of the build.yaml
+	// generated for every converted package.
+	DefaultBuildSpecTemplate = `{{ if .Requires -}}
+requires:
+{{- range .Requires }}
+- category: "{{ .Category }}"
+  name: "{{ .Name }}"
+  version: "{{ if .Version }}{{ .Version }}{{ else }}>=0{{ end }}"
+{{- end }}
+{{- else -}}
+image: "{{ .Image }}"
+{{- end }}
+prelude:
+- mkdir -p /etc/portage/package.use /etc/portage/package.accept_keywords
+{{- if .Use }}
+- echo "{{ .Atom }} {{ join .Use " " }}" > /etc/portage/package.use/luet
+{{- end }}
+{{- if .Keywords }}
+- echo "{{ .Atom }} {{ .Keywords }}" > /etc/portage/package.accept_keywords/luet
+{{- end }}
+steps:
+- emerge -j --oneshot --nodeps "{{ .Atom }}"
+`
+)
+
+// BuildSpecData is the data available to the build spec template.
+type BuildSpecData struct {
+	Package pkg.Package
+	// Atom is the exact Gentoo atom of the package (=cat/pkg-version)
+	Atom string
+	// Image is the image used when there aren't build requires
+	Image string
+	// Requires are the build requires (DEPEND, BDEPEND)
+	Requires []*pkg.DefaultPackage
+	// Use are the USE flags of the package, in package.use format
+	Use []string
+	// Keywords are the keywords to accept for the package
+	Keywords string
+}
+
+// BuildSpecGenerator generates the luet build specs (build.yaml)
+// of the converted packages.
+type BuildSpecGenerator struct {
+	Template *template.Template
+	Image    string
+	Keywords string
+	// Profile, if set, is used to write the USE flags of the package
+	Profile *ConversionProfile
+}
+
+// NewBuildSpecGenerator returns a generator from a template.
+// If tmpl is empty DefaultBuildSpecTemplate is used.
+func NewBuildSpecGenerator(tmpl string) (*BuildSpecGenerator, error) {
+	if tmpl == "" {
+		tmpl = DefaultBuildSpecTemplate
+	}
+
+	t, err := template.New("build.yaml").Funcs(template.FuncMap{
+		"join": strings.Join,
+	}).Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BuildSpecGenerator{
+		Template: t,
+		Image:    DefaultBuildImage,
+	}, nil
+}
+
+// NewBuildSpecGeneratorFromFile returns a generator that uses the template
+// stored in the given file.
+func NewBuildSpecGeneratorFromFile(path string) (*BuildSpecGenerator, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewBuildSpecGenerator(string(data))
+}
+
+func (g *BuildSpecGenerator) getUse(p pkg.Package, atom string) []string {
+	variant, isVariant := p.GetAnnotations()[GentooVariantAnnotation]
+	if g.Profile == nil && !isVariant {
+		return []string{}
+	}
+
+	gp, err := _gentoo.ParsePackageStr(strings.TrimPrefix(atom, "="))
+	if err != nil {
+		return []string{}
+	}
+
+	profile := g.Profile
+	if profile == nil {
+		profile = NewConversionProfile()
+	}
+	flags := profile.GetUseFlags(gp, p.GetUses())
+	// The flags of the variant take precedence over the profile
+	applyUse(flags, strings.Fields(variant))
+	names := make([]string, 0, len(flags))
+	for f := range flags {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+
+	ans := make([]string, 0, len(names))
+	for _, f := range names {
+		if flags[f] {
+			ans = append(ans, f)
+		} else {
+			ans = append(ans, "-"+f)
+		}
+	}
+
+	return ans
+}
+
+// Generate renders the build spec of a converted package.
+func (g *BuildSpecGenerator) Generate(p pkg.Package) ([]byte, error) {
+	atom, ok := p.GetAnnotations()[GentooAtomAnnotation]
+	if !ok {
+		atom = "=" + p.GetCategory() + "/" + p.GetName() + "-" + p.GetVersion()
+	}
+
+	data := &BuildSpecData{
+		Package:  p,
+		Atom:     atom,
+		Image:    g.Image,
+		Requires: common.GetBuildRequires(p),
+		Use:      g.getUse(p, atom),
+		Keywords: g.Keywords,
+	}
+
+	var buf bytes.Buffer
+	if err := g.Template.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Save writes the build spec of every package of the database, with the
+// same layout used by tree.NewGeneralRecipe(db).Save(path).
+func (g *BuildSpecGenerator) Save(db pkg.PackageDatabase, path string) error {
+	return g.SaveLayout(db, path, LayoutVersions)
+}
+
+// SaveLayout writes the build specs with the layout used by SaveTree.
+// With the collection layouts a build.yaml is written for every
+// collection, that selects the spec of each package.
+func (g *BuildSpecGenerator) SaveLayout(db pkg.PackageDatabase, path string, layout TreeLayout) error {
+	for dir, pkgs := range layout.Collections(db) {
+		specs := make([][]byte, 0, len(pkgs))
+		for _, p := range pkgs {
+			data, err := g.Generate(p)
+			if err != nil {
+				return err
+			}
+			specs = append(specs, data)
+		}
+
+		data := specs[0]
+		if layout != LayoutVersions {
+			data = collectionBuildSpec(pkgs, specs)
+		}
+
+		if err := os.MkdirAll(filepath.Join(path, dir), os.ModePerm); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(path, dir, tree.CompilerDefinitionFile), data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
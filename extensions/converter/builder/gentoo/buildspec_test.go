@@ -0,0 +1,118 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
+
+	pkg "github.com/mudler/luet/pkg/package"
+	tree "github.com/mudler/luet/pkg/tree"
+	. "github.com/mudler/luet/pkg/tree/builder/gentoo"
+)
+
+type buildSpec struct {
+	Image    string                `yaml:"image"`
+	Requires []*pkg.DefaultPackage `yaml:"requires"`
+	Prelude  []string              `yaml:"prelude"`
+	Steps    []string              `yaml:"steps"`
+}
+
+var _ = Describe("BuildSpecGenerator", func() {
+
+	Context("Default template", func() {
+		It("Generates the spec of a package with build requires", func() {
+			parser := &SimpleEbuildParser{}
+			pkgs, err := parser.ScanEbuild("../../../../tests/fixtures/overlay/app-crypt/pinentry-base/pinentry-base-1.1.0-r2.ebuild")
+			Expect(err).ToNot(HaveOccurred())
+
+			gen, err := NewBuildSpecGenerator("")
+			Expect(err).ToNot(HaveOccurred())
+			gen.Keywords = "~amd64"
+			gen.Profile = &ConversionProfile{Use: []string{"caps"}}
+
+			data, err := gen.Generate(pkgs[0])
+			Expect(err).ToNot(HaveOccurred())
+
+			spec := &buildSpec{}
+			Expect(yaml.Unmarshal(data, spec)).ToNot(HaveOccurred())
+			Expect(spec.Image).To(Equal(""))
+			Expect(spec.Requires).To(ContainElement(
+				&pkg.DefaultPackage{Name: "gettext", Category: "sys-devel", Version: ">=0"}))
+			Expect(spec.Requires).To(ContainElement(
+				&pkg.DefaultPackage{Name: "libassuan", Category: "dev-libs", Version: ">=" + luetVersion("2.1")}))
+			Expect(spec.Prelude).To(ContainElement(
+				`echo "=app-crypt/pinentry-base-1.1.0-r2 caps -gtk -qt5 -static" > /etc/portage/package.use/luet`))
+			Expect(spec.Prelude).To(ContainElement(
+				`echo "=app-crypt/pinentry-base-1.1.0-r2 ~amd64" > /etc/portage/package.accept_keywords/luet`))
+			Expect(spec.Steps).To(Equal([]string{
+				`emerge -j --oneshot --nodeps "=app-crypt/pinentry-base-1.1.0-r2"`,
+			}))
+		})
+
+		It("Uses the image without build requires", func() {
+			gen, err := NewBuildSpecGenerator("")
+			Expect(err).ToNot(HaveOccurred())
+			gen.Image = "sabayon/builder-amd64"
+
+			data, err := gen.Generate(&pkg.DefaultPackage{Name: "foo", Category: "app-misc", Version: "1.0"})
+			Expect(err).ToNot(HaveOccurred())
+
+			spec := &buildSpec{}
+			Expect(yaml.Unmarshal(data, spec)).ToNot(HaveOccurred())
+			Expect(spec.Image).To(Equal("sabayon/builder-amd64"))
+			Expect(len(spec.Requires)).To(Equal(0))
+			Expect(spec.Steps).To(Equal([]string{`emerge -j --oneshot --nodeps "=app-misc/foo-1.0"`}))
+		})
+	})
+
+	Context("Custom template", func() {
+		It("Renders the template and saves the specs of the tree", func() {
+			tmpdir, err := ioutil.TempDir("", "buildspec")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir)
+
+			gen, err := NewBuildSpecGenerator(`image: "{{ .Image }}"
+steps:
+- emerge {{ .Atom }} # {{ .Package.GetName }}
+`)
+			Expect(err).ToNot(HaveOccurred())
+
+			gb := NewGentooBuilder(&SimpleEbuildParser{}, 2, InMemory)
+			db, err := gb.Generate("../../../../tests/fixtures/overlay")
+			Expect(err).ToNot(HaveOccurred())
+			defer func() {
+				Expect(db.Clean()).ToNot(HaveOccurred())
+			}()
+
+			Expect(tree.NewGeneralRecipe(db).Save(tmpdir)).ToNot(HaveOccurred())
+			Expect(gen.Save(db, tmpdir)).ToNot(HaveOccurred())
+
+			data, err := ioutil.ReadFile(filepath.Join(tmpdir, "app-crypt", "pinentry", luetVersion("1.1.0-r2"), "build.yaml"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(Equal(`image: "gentoo/stage3-amd64"
+steps:
+- emerge =app-crypt/pinentry-1.1.0-r2 # pinentry
+`))
+			Expect(filepath.Join(tmpdir, "app-crypt", "pinentry", luetVersion("1.1.0-r2"), "definition.yaml")).To(BeAnExistingFile())
+		})
+	})
+})
@@ -0,0 +1,396 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	. "github.com/mudler/luet/pkg/logger"
+
+	pkg "github.com/mudler/luet/pkg/package"
+)
+
+// EbuildCacheEntry is the result of the scan of an ebuild.
+type EbuildCacheEntry struct {
+	// Hash is the hash of the ebuild, of the eclasses inherited, of the
+	// other files read for the ebuild and of the conversion options.
+	Hash     string                `json:"hash"`
+	Eclasses []string              `json:"eclasses,omitempty"`
+	Packages []*pkg.DefaultPackage `json:"packages"`
+}
+
+// EbuildCache is a persistent cache of the packages generated from the
+// ebuilds, used to scan only the ebuilds changed since the last conversion.
+type EbuildCache struct {
+	Entries map[string]*EbuildCacheEntry `json:"entries"`
+
+	file    string
+	root    string
+	options string
+	seen    map[string]bool
+	scanned map[string]bool
+	removed []pkg.Package
+	mutex   sync.Mutex
+}
+
+// NewEbuildCache returns the cache stored in the given file,
+// or an empty cache if the file doesn't exist.
+func NewEbuildCache(file string) (*EbuildCache, error) {
+	c := &EbuildCache{
+		Entries: make(map[string]*EbuildCacheEntry),
+		file:    file,
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	if c.Entries == nil {
+		c.Entries = make(map[string]*EbuildCacheEntry)
+	}
+
+	return c, nil
+}
+
+// Begin prepares the cache for the scan of a tree.
+func (c *EbuildCache) Begin(root string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.root = root
+	c.seen = make(map[string]bool)
+	c.scanned = make(map[string]bool)
+	c.removed = []pkg.Package{}
+}
+
+// SetOptions sets the fingerprint of the conversion options, see
+// FingerprintParser. It's part of the hash of the entries, so the ebuilds
+// cached with other options are scanned again.
+func (c *EbuildCache) SetOptions(options string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.options = options
+}
+
+func (c *EbuildCache) key(ebuild string) string {
+	if rel, err := filepath.Rel(c.root, ebuild); err == nil {
+		return rel
+	}
+	return ebuild
+}
+
+// hashInputs returns the hash of the options and of the files read to
+// generate the packages of the ebuild: the ebuild, the metadata.xml of
+// the package (the labels), the thirdpartymirrors of the tree (the
+// SRC_URI) and the eclasses.
+func hashInputs(options, ebuild string, eclasses []string) (string, error) {
+	files := []string{
+		ebuild,
+		filepath.Join(filepath.Dir(ebuild), PackageMetadataFile),
+		filepath.Join(treeRoot(ebuild), ThirdPartyMirrorsFile),
+	}
+	return hashFiles(options, append(files, eclasses...)...)
+}
+
+// hashFiles returns the hash of the options and of the content of the
+// files, the missing files are hashed as such.
+func hashFiles(options string, files ...string) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(options))
+	h.Write([]byte{0})
+	for _, file := range files {
+		f, err := os.Open(file)
+		if os.IsNotExist(err) {
+			h.Write([]byte{1})
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		// separator, to not mix the files
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Keep marks the ebuild as available, without checking its entry.
+func (c *EbuildCache) Keep(ebuild string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.seen[c.key(ebuild)] = true
+}
+
+// KeepDir marks all the cached ebuilds of a directory as available,
+// e.g. the ones of a category not selected for the conversion.
+func (c *EbuildCache) KeepDir(dir string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	prefix := c.key(dir) + string(filepath.Separator)
+	for key := range c.Entries {
+		if strings.HasPrefix(key, prefix) {
+			c.seen[key] = true
+		}
+	}
+}
+
+// Get returns the packages of the ebuild, if the ebuild, its eclasses,
+// the other inputs and the options are not changed since they were cached.
+func (c *EbuildCache) Get(ebuild string) (pkg.Packages, bool) {
+	c.mutex.Lock()
+	key := c.key(ebuild)
+	c.seen[key] = true
+	entry, ok := c.Entries[key]
+	options := c.options
+	c.mutex.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	hash, err := hashInputs(options, ebuild, entry.Eclasses)
+	if err != nil || hash != entry.Hash {
+		return nil, false
+	}
+
+	c.mutex.Lock()
+	c.scanned[key] = true
+	c.mutex.Unlock()
+
+	ans := pkg.Packages{}
+	for _, p := range entry.Packages {
+		ans = append(ans, p)
+	}
+	return ans, true
+}
+
+// Put stores the packages generated from the ebuild and the eclasses.
+// The packages previously generated from the ebuild and not
+// available anymore are marked as removed.
+func (c *EbuildCache) Put(ebuild string, eclasses []string, pkgs pkg.Packages) error {
+	c.mutex.Lock()
+	options := c.options
+	c.mutex.Unlock()
+
+	hash, err := hashInputs(options, ebuild, eclasses)
+	if err != nil {
+		return err
+	}
+
+	entry := &EbuildCacheEntry{
+		Hash:     hash,
+		Eclasses: eclasses,
+		Packages: []*pkg.DefaultPackage{},
+	}
+	current := make(map[string]bool)
+	for _, p := range pkgs {
+		if dp, ok := p.(*pkg.DefaultPackage); ok {
+			entry.Packages = append(entry.Packages, dp)
+			current[dp.HumanReadableString()] = true
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := c.key(ebuild)
+	c.seen[key] = true
+	c.scanned[key] = true
+	if old, ok := c.Entries[key]; ok {
+		for _, p := range old.Packages {
+			if !current[p.HumanReadableString()] {
+				c.removed = append(c.removed, p)
+			}
+		}
+	}
+	c.Entries[key] = entry
+
+	return nil
+}
+
+// End drops the entries of the ebuilds not scanned, because removed from
+// the tree, and marks their packages as removed.
+func (c *EbuildCache) End() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key, entry := range c.Entries {
+		if c.seen[key] {
+			continue
+		}
+		Debug("Ebuild", key, "removed")
+		for _, p := range entry.Packages {
+			c.removed = append(c.removed, p)
+		}
+		delete(c.Entries, key)
+	}
+}
+
+// GetRemoved returns the packages of the ebuilds removed or changed
+// since the previous conversion.
+func (c *EbuildCache) GetRemoved() []pkg.Package {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]pkg.Package{}, c.removed...)
+}
+
+// GetScanned returns the packages of the ebuilds scanned in this
+// conversion, from the cache or from the parser. Unlike the database,
+// they include the packages filtered after the scan.
+func (c *EbuildCache) GetScanned() []pkg.Package {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ans := []pkg.Package{}
+	for key := range c.scanned {
+		for _, p := range c.Entries[key].Packages {
+			ans = append(ans, p)
+		}
+	}
+	return ans
+}
+
+// Save writes the cache to its file.
+func (c *EbuildCache) Save() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(c.file); dir != "" {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(c.file, data, 0644)
+}
+
+// EclassAwareParser is implemented by the parsers that source the eclasses,
+// to find the eclasses of an ebuild.
+type EclassAwareParser interface {
+	GetEclassDirs(ebuild string) []string
+}
+
+// GetEclassDirs returns the eclass directories available for the ebuild.
+func (ep *SimpleEbuildParser) GetEclassDirs(ebuild string) []string {
+	return EclassDirs(ebuild, ep.Overlays)
+}
+
+// FingerprintParser is implemented by the parsers whose output depends on
+// their options, e.g. the profile or the slot strategy. The fingerprint
+// of the options invalidates the cached packages when they change.
+type FingerprintParser interface {
+	Fingerprint() string
+}
+
+// parserOptions are the options of the SimpleEbuildParser that change
+// the packages generated.
+type parserOptions struct {
+	Parser       string             `json:"parser"`
+	Profile      *ConversionProfile `json:"profile,omitempty"`
+	SlotStrategy SlotStrategy       `json:"slot_strategy"`
+	Overlays     []string           `json:"overlays,omitempty"`
+	Helpers      []string           `json:"helpers,omitempty"`
+	Stubs        []string           `json:"stubs,omitempty"`
+	Allowed      []string           `json:"allowed,omitempty"`
+	External     ExternalPolicy     `json:"external"`
+	MaxSteps     int64              `json:"max_steps"`
+	MaxVarsSize  int                `json:"max_vars_size"`
+}
+
+// fingerprint returns the fingerprint of the options of the parser,
+// of the given kind.
+func (ep *SimpleEbuildParser) fingerprint(kind string) string {
+	sandbox := ep.Sandbox
+	if sandbox == nil {
+		sandbox = NewSandbox()
+	}
+	opts := parserOptions{
+		Parser:       kind,
+		Profile:      ep.Profile,
+		SlotStrategy: ep.SlotStrategy,
+		Overlays:     ep.Overlays,
+		Helpers:      []string{},
+		Stubs:        sandbox.Stubs,
+		Allowed:      sandbox.Allowed,
+		External:     sandbox.External,
+		MaxSteps:     sandbox.MaxSteps,
+		MaxVarsSize:  sandbox.MaxVarsSize,
+	}
+	for name := range sandbox.Helpers {
+		opts.Helpers = append(opts.Helpers, name)
+	}
+	sort.Strings(opts.Helpers)
+
+	data, _ := json.Marshal(opts)
+	return string(data)
+}
+
+// Fingerprint returns the fingerprint of the profile, the slot strategy,
+// the overlays and the sandbox of the parser.
+func (ep *SimpleEbuildParser) Fingerprint() string {
+	return ep.fingerprint("simple")
+}
+
+// Fingerprint returns the fingerprint of the options of the parser.
+func (ep *VariantEbuildParser) Fingerprint() string {
+	return ep.SimpleEbuildParser.fingerprint("variant")
+}
+
+// Fingerprint returns the fingerprint of the options of the parser.
+func (ep *CacheEbuildParser) Fingerprint() string {
+	return ep.SimpleEbuildParser.fingerprint("md5-cache")
+}
+
+// inheritedEclasses returns the files of the eclasses inherited
+// by the packages of an ebuild.
+func inheritedEclasses(pkgs pkg.Packages, dirs []string) []string {
+	ans := []string{}
+	seen := make(map[string]bool)
+	for _, p := range pkgs {
+		for _, name := range strings.Fields(p.GetAnnotations()[GentooEclassesAnnotation]) {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			if file, err := FindEclass(name, dirs); err == nil {
+				ans = append(ans, file)
+			}
+		}
+	}
+	return ans
+}
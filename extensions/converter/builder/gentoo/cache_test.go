@@ -0,0 +1,170 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/mudler/luet/pkg/tree/builder/gentoo"
+)
+
+var _ = Describe("Ebuild cache", func() {
+
+	Context("Incremental conversion", func() {
+		var tmpdir, tree, cacheFile string
+
+		write := func(file, content string) {
+			Expect(os.MkdirAll(filepath.Dir(file), os.ModePerm)).Should(BeNil())
+			Expect(ioutil.WriteFile(file, []byte(content), 0644)).Should(BeNil())
+		}
+
+		// generate converts the tree with the cache and
+		// returns the messages of the ebuilds
+		generate := func(parser *SimpleEbuildParser) (map[string]string, []pkg.Package) {
+			cache, err := NewEbuildCache(cacheFile)
+			Expect(err).Should(BeNil())
+
+			gb := NewGentooBuilder(parser, 2, InMemory)
+			gb.Cache = cache
+			db, err := gb.Generate(tree)
+			Expect(err).Should(BeNil())
+			defer db.Clean()
+			Expect(cache.Save()).Should(BeNil())
+
+			ans := map[string]string{}
+			for _, r := range gb.GetReport().Results {
+				Expect(r.Status).Should(Equal(EbuildOk))
+				ans[filepath.Base(r.Ebuild)] = r.Message
+			}
+			return ans, cache.GetRemoved()
+		}
+
+		BeforeEach(func() {
+			var err error
+			tmpdir, err = ioutil.TempDir("", "cache")
+			Expect(err).Should(BeNil())
+			tree = filepath.Join(tmpdir, "tree")
+			cacheFile = filepath.Join(tmpdir, "cache", "ebuilds.json")
+
+			write(filepath.Join(tree, EclassDir, "foo.eclass"), "RDEPEND=\"app-misc/bar\"\n")
+			write(filepath.Join(tree, "app-misc", "foo", "foo-1.0.ebuild"), "EAPI=7\ninherit foo\nSLOT=0\n")
+			write(filepath.Join(tree, "app-misc", "baz", "baz-1.0.ebuild"), "EAPI=7\nSLOT=0\nIUSE=doc\n")
+			write(filepath.Join(tree, "app-misc", "qux", "qux-1.0.ebuild"), "EAPI=7\nSLOT=0\n")
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tmpdir)
+		})
+
+		It("Scans only the ebuilds changed", func() {
+			messages, removed := generate(&SimpleEbuildParser{})
+			Expect(messages).Should(Equal(map[string]string{
+				"foo-1.0.ebuild": "", "baz-1.0.ebuild": "", "qux-1.0.ebuild": "",
+			}))
+			Expect(len(removed)).Should(Equal(0))
+
+			messages, removed = generate(&SimpleEbuildParser{})
+			Expect(messages).Should(Equal(map[string]string{
+				"foo-1.0.ebuild": "cached", "baz-1.0.ebuild": "cached", "qux-1.0.ebuild": "cached",
+			}))
+			Expect(len(removed)).Should(Equal(0))
+
+			// The cached packages are the same
+			cache, err := NewEbuildCache(cacheFile)
+			Expect(err).Should(BeNil())
+			cache.Begin(tree)
+			cache.SetOptions((&SimpleEbuildParser{}).Fingerprint())
+			pkgs, ok := cache.Get(filepath.Join(tree, "app-misc", "foo", "foo-1.0.ebuild"))
+			Expect(ok).Should(BeTrue())
+			Expect(pkgs[0].GetRequires()[0].GetName()).Should(Equal("bar"))
+			Expect(pkgs[0].GetAnnotations()[GentooEclassesAnnotation]).Should(Equal("foo"))
+
+			// A change of the eclass
+			write(filepath.Join(tree, EclassDir, "foo.eclass"), "RDEPEND=\"app-misc/bar2\"\n")
+			messages, _ = generate(&SimpleEbuildParser{})
+			Expect(messages["foo-1.0.ebuild"]).Should(Equal(""))
+			Expect(messages["baz-1.0.ebuild"]).Should(Equal("cached"))
+		})
+
+		It("Scans again the ebuilds when their metadata or the mirrors change", func() {
+			generate(&SimpleEbuildParser{})
+
+			write(filepath.Join(tree, "app-misc", "baz", PackageMetadataFile), `<?xml version="1.0" encoding="UTF-8"?>
+<pkgmetadata>
+	<upstream>
+		<remote-id type="github">baz/baz</remote-id>
+	</upstream>
+</pkgmetadata>
+`)
+			messages, _ := generate(&SimpleEbuildParser{})
+			Expect(messages["baz-1.0.ebuild"]).Should(Equal(""))
+			Expect(messages["foo-1.0.ebuild"]).Should(Equal("cached"))
+
+			write(filepath.Join(tree, ThirdPartyMirrorsFile), "example https://example.com/mirror\n")
+			messages, _ = generate(&SimpleEbuildParser{})
+			Expect(messages["baz-1.0.ebuild"]).Should(Equal(""))
+			Expect(messages["foo-1.0.ebuild"]).Should(Equal(""))
+
+			messages, _ = generate(&SimpleEbuildParser{})
+			Expect(messages["baz-1.0.ebuild"]).Should(Equal("cached"))
+		})
+
+		It("Scans again the ebuilds when the options change", func() {
+			generate(&SimpleEbuildParser{})
+
+			profile := NewConversionProfile()
+			profile.Use = []string{"doc"}
+			messages, _ := generate(&SimpleEbuildParser{Profile: profile})
+			Expect(messages["baz-1.0.ebuild"]).Should(Equal(""))
+
+			messages, _ = generate(&SimpleEbuildParser{Profile: profile, SlotStrategy: SlotInName})
+			Expect(messages["baz-1.0.ebuild"]).Should(Equal(""))
+
+			sandbox := NewSandbox()
+			sandbox.MaxSteps = DefaultMaxSteps / 2
+			messages, _ = generate(&SimpleEbuildParser{Profile: profile, SlotStrategy: SlotInName, Sandbox: sandbox})
+			Expect(messages["baz-1.0.ebuild"]).Should(Equal(""))
+
+			messages, _ = generate(&SimpleEbuildParser{Profile: profile, SlotStrategy: SlotInName, Sandbox: sandbox})
+			Expect(messages["baz-1.0.ebuild"]).Should(Equal("cached"))
+
+			messages, _ = generate(&SimpleEbuildParser{Profile: profile, SlotStrategy: SlotInName, Sandbox: sandbox, Overlays: []string{tmpdir}})
+			Expect(messages["baz-1.0.ebuild"]).Should(Equal(""))
+		})
+
+		It("Returns the packages removed", func() {
+			generate(&SimpleEbuildParser{})
+
+			Expect(os.Remove(filepath.Join(tree, "app-misc", "qux", "qux-1.0.ebuild"))).Should(BeNil())
+			write(filepath.Join(tree, "app-misc", "baz", "baz-1.0.ebuild"), "EAPI=7\nSLOT=2\nIUSE=doc\n")
+
+			messages, removed := generate(&SimpleEbuildParser{})
+			Expect(len(messages)).Should(Equal(2))
+
+			names := []string{}
+			for _, p := range removed {
+				names = append(names, p.GetCategory()+"/"+p.GetName())
+			}
+			Expect(names).Should(ConsistOf("app-misc/qux", "app-misc/baz"))
+		})
+	})
+})
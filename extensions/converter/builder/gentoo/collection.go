@@ -0,0 +1,159 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	pkg "github.com/mudler/luet/pkg/package"
+	spectooling "github.com/mudler/luet/pkg/spectooling"
+	tree "github.com/mudler/luet/pkg/tree"
+	version "github.com/mudler/luet/pkg/versioner"
+
+	"gopkg.in/yaml.v2"
+)
+
+// TreeLayout is the layout of the luet tree written by SaveTree.
+type TreeLayout int
+
+const (
+	// LayoutVersions writes a definition.yaml for every version in
+	// <category>/<name>/<version>, as tree.NewGeneralRecipe(db).Save.
+	LayoutVersions TreeLayout = iota
+	// LayoutCollections writes all the versions of a package in
+	// <category>/<name>/collection.yaml.
+	LayoutCollections
+	// LayoutOverlay writes all the versions and the slots of an ebuild
+	// package in <category>/<package>/collection.yaml, with the category
+	// and the package of the source tree.
+	LayoutOverlay
+)
+
+// ParseTreeLayout returns the TreeLayout from its name
+// (versions, collections, overlay).
+func ParseTreeLayout(s string) (TreeLayout, error) {
+	switch s {
+	case "", "versions":
+		return LayoutVersions, nil
+	case "collections":
+		return LayoutCollections, nil
+	case "overlay":
+		return LayoutOverlay, nil
+	}
+	return LayoutVersions, fmt.Errorf("invalid tree layout %s", s)
+}
+
+// Dir returns the directory of the definition of a package,
+// relative to the tree.
+func (l TreeLayout) Dir(p pkg.Package) string {
+	switch l {
+	case LayoutCollections:
+		return filepath.Join(p.GetCategory(), p.GetName())
+	case LayoutOverlay:
+		if gp, err := gentooPackage(p); err == nil {
+			return filepath.Join(gp.Category, gp.Name)
+		}
+		return filepath.Join(p.GetCategory(), p.GetName())
+	default:
+		return filepath.Join(p.GetCategory(), p.GetName(), p.GetVersion())
+	}
+}
+
+// Collections returns the packages of the database grouped by the
+// directory of the layout. The packages are sorted by category,
+// name and version, so the written files are stable.
+func (l TreeLayout) Collections(db pkg.PackageDatabase) map[string][]pkg.Package {
+	ans := make(map[string][]pkg.Package)
+	for _, p := range db.World() {
+		dir := l.Dir(p)
+		ans[dir] = append(ans[dir], p)
+	}
+
+	v := version.DefaultVersioner()
+	for _, pkgs := range ans {
+		sort.SliceStable(pkgs, func(i, j int) bool {
+			a, b := pkgs[i], pkgs[j]
+			if a.GetCategory() != b.GetCategory() {
+				return a.GetCategory() < b.GetCategory()
+			}
+			if a.GetName() != b.GetName() {
+				return a.GetName() < b.GetName()
+			}
+			if a.GetVersion() == b.GetVersion() {
+				return false
+			}
+			return v.Sort([]string{a.GetVersion(), b.GetVersion()})[0] == a.GetVersion()
+		})
+	}
+
+	return ans
+}
+
+// SaveTree writes the definitions of the packages of the database
+// with the given layout. The collections are written as a whole, with
+// all the versions available in the database.
+func SaveTree(db pkg.PackageDatabase, path string, layout TreeLayout) error {
+	if layout == LayoutVersions {
+		return tree.NewGeneralRecipe(db).Save(path)
+	}
+
+	for dir, pkgs := range layout.Collections(db) {
+		collection := struct {
+			Packages []*spectooling.DefaultPackageSanitized `yaml:"packages"`
+		}{}
+		for _, p := range pkgs {
+			collection.Packages = append(collection.Packages, spectooling.NewDefaultPackageSanitized(p))
+		}
+
+		data, err := yaml.Marshal(&collection)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(path, dir), os.ModePerm); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(path, dir, tree.CollectionFile), data, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// collectionBuildSpec joins the build specs of the packages of a
+// collection. luet renders the build.yaml of a collection for every
+// package, so each spec is selected by the values of the package.
+func collectionBuildSpec(pkgs []pkg.Package, specs [][]byte) []byte {
+	var buf bytes.Buffer
+	for i, p := range pkgs {
+		if i == 0 {
+			buf.WriteString("{{ if ")
+		} else {
+			buf.WriteString("{{ else if ")
+		}
+		buf.WriteString(fmt.Sprintf("and (eq .Values.category %q) (eq .Values.name %q) (eq .Values.version %q) -}}\n",
+			p.GetCategory(), p.GetName(), p.GetVersion()))
+		buf.Write(bytes.TrimRight(specs[i], "\n"))
+		buf.WriteString("\n")
+	}
+	buf.WriteString("{{ end -}}\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,187 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mudler/luet/pkg/helpers"
+	pkg "github.com/mudler/luet/pkg/package"
+	tree "github.com/mudler/luet/pkg/tree"
+	. "github.com/mudler/luet/pkg/tree/builder/gentoo"
+)
+
+var _ = Describe("Tree layout", func() {
+	var tmpdir, output string
+	var db pkg.PackageDatabase
+
+	write := func(file, content string) {
+		Expect(os.MkdirAll(filepath.Dir(file), os.ModePerm)).Should(BeNil())
+		Expect(ioutil.WriteFile(file, []byte(content), 0644)).Should(BeNil())
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = ioutil.TempDir("", "layout")
+		Expect(err).Should(BeNil())
+		output = filepath.Join(tmpdir, "output")
+
+		src := filepath.Join(tmpdir, "tree")
+		write(filepath.Join(src, "dev-lang", "python", "python-2.7.18.ebuild"), "EAPI=7\nSLOT=2.7\nDEPEND=\"dev-libs/libffi\"\n")
+		write(filepath.Join(src, "dev-lang", "python", "python-3.8.5.ebuild"), "EAPI=7\nSLOT=3.8\n")
+		write(filepath.Join(src, "dev-lang", "python", "python-3.8.10.ebuild"), "EAPI=7\nSLOT=3.8\n")
+		write(filepath.Join(src, "dev-libs", "libffi", "libffi-3.3.ebuild"), "EAPI=7\nSLOT=0\n")
+
+		gb := NewGentooBuilder(&SimpleEbuildParser{SlotStrategy: SlotInName}, 2, InMemory)
+		db, err = gb.Generate(src)
+		Expect(err).Should(BeNil())
+		Expect(len(db.World())).Should(Equal(4))
+	})
+
+	AfterEach(func() {
+		db.Clean()
+		os.RemoveAll(tmpdir)
+	})
+
+	// load returns the packages of the written tree
+	load := func() []pkg.Package {
+		r := tree.NewGeneralRecipe(nil)
+		Expect(r.Load(output)).Should(BeNil())
+		return r.GetDatabase().World()
+	}
+
+	It("Parses the layouts", func() {
+		for name, l := range map[string]TreeLayout{
+			"":            LayoutVersions,
+			"versions":    LayoutVersions,
+			"collections": LayoutCollections,
+			"overlay":     LayoutOverlay,
+		} {
+			layout, err := ParseTreeLayout(name)
+			Expect(err).Should(BeNil())
+			Expect(layout).Should(Equal(l))
+		}
+		_, err := ParseTreeLayout("flat")
+		Expect(err).ShouldNot(BeNil())
+	})
+
+	It("Writes a definition for every version", func() {
+		Expect(SaveTree(db, output, LayoutVersions)).Should(BeNil())
+		Expect(filepath.Join(output, "dev-lang", "python-3.8", luetVersion("3.8.10"), tree.DefinitionFile)).Should(BeAnExistingFile())
+		Expect(len(load())).Should(Equal(4))
+	})
+
+	It("Writes a collection for every package", func() {
+		Expect(SaveTree(db, output, LayoutCollections)).Should(BeNil())
+		Expect(filepath.Join(output, "dev-lang", "python-2.7", tree.CollectionFile)).Should(BeAnExistingFile())
+		Expect(filepath.Join(output, "dev-lang", "python-3.8", tree.CollectionFile)).Should(BeAnExistingFile())
+		Expect(filepath.Join(output, "dev-libs", "libffi", tree.CollectionFile)).Should(BeAnExistingFile())
+		Expect(len(load())).Should(Equal(4))
+
+		// sorted by version
+		packs, err := pkg.DefaultPackagesFromYaml(readFile(filepath.Join(output, "dev-lang", "python-3.8", tree.CollectionFile)))
+		Expect(err).Should(BeNil())
+		Expect(packs[0].GetVersion()).Should(Equal(luetVersion("3.8.5")))
+		Expect(packs[1].GetVersion()).Should(Equal(luetVersion("3.8.10")))
+	})
+
+	It("Writes the slots in the collection of the source package", func() {
+		Expect(SaveTree(db, output, LayoutOverlay)).Should(BeNil())
+		dirs, err := ioutil.ReadDir(filepath.Join(output, "dev-lang"))
+		Expect(err).Should(BeNil())
+		Expect(len(dirs)).Should(Equal(1))
+		Expect(dirs[0].Name()).Should(Equal("python"))
+
+		packs, err := pkg.DefaultPackagesFromYaml(readFile(filepath.Join(output, "dev-lang", "python", tree.CollectionFile)))
+		Expect(err).Should(BeNil())
+		Expect(len(packs)).Should(Equal(3))
+		Expect(packs[0].GetName()).Should(Equal("python-2.7"))
+		Expect(len(load())).Should(Equal(4))
+	})
+
+	It("Writes a build spec for every collection", func() {
+		gen, err := NewBuildSpecGenerator("")
+		Expect(err).Should(BeNil())
+		Expect(SaveTree(db, output, LayoutOverlay)).Should(BeNil())
+		Expect(gen.SaveLayout(db, output, LayoutOverlay)).Should(BeNil())
+
+		spec := string(readFile(filepath.Join(output, "dev-lang", "python", tree.CompilerDefinitionFile)))
+		for _, p := range db.World() {
+			if p.GetCategory() != "dev-lang" {
+				continue
+			}
+			expected, err := gen.Generate(p)
+			Expect(err).Should(BeNil())
+
+			rendered, err := helpers.RenderHelm(spec, map[string]interface{}{
+				"category": p.GetCategory(),
+				"name":     p.GetName(),
+				"version":  p.GetVersion(),
+			}, map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			Expect(rendered).Should(Equal(string(expected)))
+		}
+	})
+
+	It("Writes the same files on every conversion", func() {
+		src := filepath.Join(tmpdir, "tree")
+		write(filepath.Join(src, "app-misc", "foo", "foo-1.0.ebuild"), `EAPI=7
+SLOT=0
+IUSE="+a +b"
+DEPEND="dev-libs/d1 dev-libs/d2 a? ( dev-libs/d3 dev-libs/d1 ) dev-libs/d4"
+RDEPEND="dev-libs/r1 b? ( dev-libs/r2 ) dev-libs/r3 dev-libs/r4 !dev-libs/c1 !dev-libs/c2 !dev-libs/c3 dev-libs/r5"
+`)
+
+		for _, layout := range []TreeLayout{LayoutVersions, LayoutCollections} {
+			outputs := []string{}
+			for i := 0; i < 2; i++ {
+				gb := NewGentooBuilder(&SimpleEbuildParser{}, 2, InMemory)
+				converted, err := gb.Generate(src)
+				Expect(err).Should(BeNil())
+				out := filepath.Join(tmpdir, fmt.Sprintf("output-%d-%d", layout, i))
+				Expect(SaveTree(converted, out, layout)).Should(BeNil())
+				converted.Clean()
+				outputs = append(outputs, out)
+			}
+
+			files := 0
+			Expect(filepath.Walk(outputs[0], func(path string, info os.FileInfo, err error) error {
+				Expect(err).Should(BeNil())
+				if info.IsDir() {
+					return nil
+				}
+				rel, err := filepath.Rel(outputs[0], path)
+				Expect(err).Should(BeNil())
+				Expect(string(readFile(filepath.Join(outputs[1], rel)))).Should(Equal(string(readFile(path))), rel)
+				files++
+				return nil
+			})).Should(BeNil())
+			Expect(files).Should(BeNumerically(">", 0))
+		}
+	})
+})
+
+func readFile(file string) []byte {
+	data, err := ioutil.ReadFile(file)
+	Expect(err).Should(BeNil())
+	return data
+}
@@ -0,0 +1,242 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	. "github.com/mudler/luet/pkg/logger"
+
+	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
+	pkg "github.com/mudler/luet/pkg/package"
+	"mvdan.cc/sh/v3/expand"
+)
+
+const (
+	// GentooAtomAnnotation is the package annotation that stores the
+	// exact Gentoo atom (=cat/pkg-version) of a converted ebuild.
+	GentooAtomAnnotation = "gentoo_atom"
+	// GentooSlotAnnotation is the package annotation that stores the
+	// SLOT (with the sub-slot) of a converted ebuild.
+	GentooSlotAnnotation = "gentoo_slot"
+	// GentooKeywordsAnnotation is the package annotation that stores the
+	// KEYWORDS of a converted ebuild.
+	GentooKeywordsAnnotation = "gentoo_keywords"
+	// GentooEclassesAnnotation is the package annotation that stores the
+	// eclasses inherited by a converted ebuild.
+	GentooEclassesAnnotation = "gentoo_eclasses"
+	// GentooVariantAnnotation is the package annotation that stores the
+	// USE flags of the variant of a converted ebuild.
+	GentooVariantAnnotation = "gentoo_variant"
+	// GentooProvidersAnnotation is the package annotation that stores the
+	// alternatives of the RDEPEND of a virtual/* ebuild.
+	GentooProvidersAnnotation = "gentoo_providers"
+	// GentooDistfilesAnnotation is the package annotation that stores the
+	// URIs of a converted ebuild with the name of their distfile (uri -> file).
+	GentooDistfilesAnnotation = "gentoo_distfiles"
+)
+
+var (
+	// RuntimeDependVars are the ebuild variables converted to package requires.
+	RuntimeDependVars = []string{"RDEPEND", "PDEPEND"}
+	// BuildDependVars are the ebuild variables converted to build requires.
+	BuildDependVars = []string{"DEPEND", "BDEPEND"}
+)
+
+// isLegacyEAPI returns true for EAPI 0-3, where RDEPEND defaults to DEPEND.
+func isLegacyEAPI(vars map[string]expand.Variable) bool {
+	eapi, ok := vars["EAPI"]
+	if !ok {
+		return true
+	}
+	switch strings.Trim(strings.TrimSpace(eapi.String()), "\"'") {
+	case "", "0", "1", "2", "3":
+		return true
+	}
+	return false
+}
+
+// parseDependVars parses the given dependency variables through ParseRDEPEND
+// and returns the merged list of requires and conflicts, and the
+// dependencies with the := slot operator.
+// If use is not nil, only the use conditionals enabled are evaluated.
+func (ep *SimpleEbuildParser) parseDependVars(gp *_gentoo.GentooPackage, vars map[string]expand.Variable, names []string, use map[string]bool) ([]*pkg.DefaultPackage, []*pkg.DefaultPackage, []string) {
+	requires := []*pkg.DefaultPackage{}
+	conflicts := []*pkg.DefaultPackage{}
+	rebuild := []string{}
+	// the same dependency could be available in multiple variables.
+	seen := make(map[string]bool)
+
+	for _, name := range names {
+		v, ok := vars[name]
+		if !ok {
+			continue
+		}
+
+		gdeps, err := ParseRDEPEND(v.String())
+		if err != nil {
+			Warning("Error on parsing "+name+" for package ", gp.Category+"/"+gp.Name, err)
+			continue
+		}
+		for _, atom := range gdeps.Invalid {
+			Warning("Ignoring invalid dependency", atom, "of "+name+" for package", gp.Category+"/"+gp.Name)
+		}
+		if use != nil {
+			gdeps = gdeps.FilterUse(use)
+		}
+
+		for _, d := range ep.resolveDependencies(gdeps) {
+			if seen[d.String()] {
+				continue
+			}
+			seen[d.String()] = true
+
+			if d.GetSlotOperator() == "=" && !d.IsBlocker() {
+				entry := d.Dep.Category + "/" + d.Dep.Name
+				if d.GetSlot() != "" {
+					entry += ":" + d.GetSlot()
+				}
+				rebuild = append(rebuild, entry)
+			}
+
+			//TODO: Resolve to db or create a new one.
+			for _, dep := range ep.luetDependencies(gp, d) {
+				Debug(fmt.Sprintf("For package %s found %s dep: %s/%s %s",
+					gp, name, dep.Category, dep.Name, dep.Version))
+				if d.IsBlocker() {
+					conflicts = append(conflicts, dep)
+				} else {
+					requires = append(requires, dep)
+				}
+			}
+		}
+	}
+
+	return requires, conflicts, rebuild
+}
+
+// luetDependencies returns the luet packages of a dependency of gp: a
+// range of versions is required with a package for every selector, while
+// a blocker can use only one selector.
+func (ep *SimpleEbuildParser) luetDependencies(gp *_gentoo.GentooPackage, d *GentooDependency) []*pkg.DefaultPackage {
+	if d.IsBlocker() {
+		return []*pkg.DefaultPackage{ep.luetDependency(gp, d)}
+	}
+
+	selectors, err := LuetSelectors(d.Dep)
+	if err != nil {
+		return []*pkg.DefaultPackage{ep.luetDependency(gp, d)}
+	}
+	category, name := ep.SlotStrategy.Map(d.Dep.Category, d.Dep.Name, d.GetSlot())
+	ans := []*pkg.DefaultPackage{}
+	for _, selector := range selectors {
+		ans = append(ans, &pkg.DefaultPackage{
+			Name:     name,
+			Version:  selector,
+			Category: category,
+		})
+	}
+	return ans
+}
+
+// luetDependency returns the luet package of a dependency of gp, with a
+// single version selector.
+func (ep *SimpleEbuildParser) luetDependency(gp *_gentoo.GentooPackage, d *GentooDependency) *pkg.DefaultPackage {
+	category, name := ep.SlotStrategy.Map(d.Dep.Category, d.Dep.Name, d.GetSlot())
+	version, err := LuetSelector(d.Dep)
+	if err != nil {
+		Warning("Error on translating the dependency", d.String(), "of", gp, err.Error())
+		version = d.Dep.Version + d.Dep.VersionSuffix
+	}
+	return &pkg.DefaultPackage{
+		Name:     name,
+		Version:  version,
+		Category: category,
+	}
+}
+
+// mergeSlotRebuild merges and sorts the lists of dependencies with
+// the := slot operator.
+func mergeSlotRebuild(lists ...[]string) []string {
+	ans := []string{}
+	seen := make(map[string]bool)
+	for _, l := range lists {
+		for _, e := range l {
+			if !seen[e] {
+				seen[e] = true
+				ans = append(ans, e)
+			}
+		}
+	}
+	sort.Strings(ans)
+	return ans
+}
+
+// resolveDependencies returns the flattened dependencies of a parsed
+// variable, with an alternative selected for every any-of group.
+func (ep *SimpleEbuildParser) resolveDependencies(gdeps *GentooRDEPEND) []*GentooDependency {
+	ans := gdeps.GetDependencies()
+	for _, group := range gdeps.GetAnyOfDependencies() {
+		ans = append(ans, ep.resolveAnyOf(group)...)
+	}
+	return ans
+}
+
+// resolveAnyOf selects the alternative of an any-of group to use: the first
+// one available in the World database, or the first one if there is no match.
+func (ep *SimpleEbuildParser) resolveAnyOf(group *GentooDependency) []*GentooDependency {
+	if len(group.SubDeps) == 0 {
+		return []*GentooDependency{}
+	}
+
+	alt := group.SubDeps[0]
+	if ep.World != nil {
+		for _, a := range group.SubDeps {
+			if ep.inWorld(a) {
+				alt = a
+				break
+			}
+		}
+	}
+
+	ans := alt.GetDepsList()
+	for _, nested := range alt.GetAnyOfList() {
+		ans = append(ans, ep.resolveAnyOf(nested)...)
+	}
+	return ans
+}
+
+// inWorld returns true if all the packages of the alternative are
+// available in the World database, with the names of the slot strategy.
+func (ep *SimpleEbuildParser) inWorld(d *GentooDependency) bool {
+	deps := d.GetDepsList()
+	if len(deps) == 0 {
+		return false
+	}
+	for _, dep := range deps {
+		category, name := ep.SlotStrategy.Map(dep.Dep.Category, dep.Dep.Name, dep.GetSlot())
+		pkgs, err := ep.World.FindPackageVersions(&pkg.DefaultPackage{
+			Name:     name,
+			Category: category,
+		})
+		if err != nil || len(pkgs) == 0 {
+			return false
+		}
+	}
+	return true
+}
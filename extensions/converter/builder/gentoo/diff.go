@@ -0,0 +1,258 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	pkg "github.com/mudler/luet/pkg/package"
+	version "github.com/mudler/luet/pkg/versioner"
+)
+
+// PackageVersions are the versions of a package (category/name).
+type PackageVersions struct {
+	Package  string   `json:"package"`
+	Versions []string `json:"versions"`
+}
+
+// VersionBump are the versions added and removed of a package
+// available in both the trees.
+type VersionBump struct {
+	Package string   `json:"package"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// ListDiff are the items added and removed from a list.
+type ListDiff struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// PackageChanges are the changes of a package version
+// (category/name-version) available in both the trees.
+type PackageChanges struct {
+	Package   string    `json:"package"`
+	Requires  *ListDiff `json:"requires,omitempty"`
+	Conflicts *ListDiff `json:"conflicts,omitempty"`
+	Uses      *ListDiff `json:"uses,omitempty"`
+}
+
+// TreeDiff are the differences between two luet trees.
+type TreeDiff struct {
+	Added   []PackageVersions `json:"added"`
+	Removed []PackageVersions `json:"removed"`
+	Bumped  []VersionBump     `json:"bumped"`
+	Changed []PackageChanges  `json:"changed"`
+}
+
+// depString returns a dependency in the form category/name [version].
+func depString(d *pkg.DefaultPackage) string {
+	return strings.TrimSpace(d.GetCategory() + "/" + d.GetName() + " " + d.GetVersion())
+}
+
+func depStrings(deps []*pkg.DefaultPackage) []string {
+	ans := make([]string, 0, len(deps))
+	for _, d := range deps {
+		ans = append(ans, depString(d))
+	}
+	return ans
+}
+
+// diffLists returns the differences of two lists, or nil
+// if they have the same items.
+func diffLists(before, after []string) *ListDiff {
+	set := func(l []string) map[string]bool {
+		ans := make(map[string]bool)
+		for _, i := range l {
+			ans[i] = true
+		}
+		return ans
+	}
+	oldSet, newSet := set(before), set(after)
+
+	d := &ListDiff{}
+	for i := range newSet {
+		if !oldSet[i] {
+			d.Added = append(d.Added, i)
+		}
+	}
+	for i := range oldSet {
+		if !newSet[i] {
+			d.Removed = append(d.Removed, i)
+		}
+	}
+	if len(d.Added) == 0 && len(d.Removed) == 0 {
+		return nil
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	return d
+}
+
+// groupByName returns the packages of the database by category/name
+// and version.
+func groupByName(db pkg.PackageDatabase) map[string]map[string]pkg.Package {
+	ans := make(map[string]map[string]pkg.Package)
+	for _, p := range db.World() {
+		name := p.GetCategory() + "/" + p.GetName()
+		if _, ok := ans[name]; !ok {
+			ans[name] = make(map[string]pkg.Package)
+		}
+		ans[name][p.GetVersion()] = p
+	}
+	return ans
+}
+
+// sortedVersions returns the versions of a package from the lowest.
+func sortedVersions(versions map[string]pkg.Package) []string {
+	ans := make([]string, 0, len(versions))
+	for v := range versions {
+		ans = append(ans, v)
+	}
+	return version.DefaultVersioner().Sort(ans)
+}
+
+// DiffTrees compares the packages of a new tree with the ones of an old
+// tree: the packages added and removed, the versions added and removed
+// and the requires, conflicts and uses changed in the same version.
+func DiffTrees(oldTree, newTree pkg.PackageDatabase) *TreeDiff {
+	ans := &TreeDiff{
+		Added:   []PackageVersions{},
+		Removed: []PackageVersions{},
+		Bumped:  []VersionBump{},
+		Changed: []PackageChanges{},
+	}
+	oldPkgs, newPkgs := groupByName(oldTree), groupByName(newTree)
+
+	names := []string{}
+	for name := range oldPkgs {
+		names = append(names, name)
+	}
+	for name := range newPkgs {
+		if _, ok := oldPkgs[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		oldVersions, inOld := oldPkgs[name]
+		newVersions, inNew := newPkgs[name]
+		switch {
+		case !inOld:
+			ans.Added = append(ans.Added, PackageVersions{Package: name, Versions: sortedVersions(newVersions)})
+			continue
+		case !inNew:
+			ans.Removed = append(ans.Removed, PackageVersions{Package: name, Versions: sortedVersions(oldVersions)})
+			continue
+		}
+
+		bump := VersionBump{Package: name}
+		for _, v := range sortedVersions(newVersions) {
+			if _, ok := oldVersions[v]; !ok {
+				bump.Added = append(bump.Added, v)
+			}
+		}
+		for _, v := range sortedVersions(oldVersions) {
+			n, ok := newVersions[v]
+			if !ok {
+				bump.Removed = append(bump.Removed, v)
+				continue
+			}
+
+			o := oldVersions[v]
+			changes := PackageChanges{
+				Package:   name + "-" + v,
+				Requires:  diffLists(depStrings(o.GetRequires()), depStrings(n.GetRequires())),
+				Conflicts: diffLists(depStrings(o.GetConflicts()), depStrings(n.GetConflicts())),
+				Uses:      diffLists(o.GetUses(), n.GetUses()),
+			}
+			if changes.Requires != nil || changes.Conflicts != nil || changes.Uses != nil {
+				ans.Changed = append(ans.Changed, changes)
+			}
+		}
+		if len(bump.Added) > 0 || len(bump.Removed) > 0 {
+			ans.Bumped = append(ans.Bumped, bump)
+		}
+	}
+
+	return ans
+}
+
+// IsEmpty returns true if the trees have the same packages.
+func (d *TreeDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Bumped) == 0 && len(d.Changed) == 0
+}
+
+// WriteJSON writes the differences in JSON format.
+func (d *TreeDiff) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+// WriteText writes the differences in a human readable format:
+// the packages added (+), removed (-), with new versions (~) and
+// changed (*).
+func (d *TreeDiff) WriteText(w io.Writer) error {
+	var b strings.Builder
+
+	for _, p := range d.Added {
+		fmt.Fprintf(&b, "+ %s %s\n", p.Package, strings.Join(p.Versions, " "))
+	}
+	for _, p := range d.Removed {
+		fmt.Fprintf(&b, "- %s %s\n", p.Package, strings.Join(p.Versions, " "))
+	}
+	for _, p := range d.Bumped {
+		versions := []string{}
+		for _, v := range p.Added {
+			versions = append(versions, "+"+v)
+		}
+		for _, v := range p.Removed {
+			versions = append(versions, "-"+v)
+		}
+		fmt.Fprintf(&b, "~ %s %s\n", p.Package, strings.Join(versions, " "))
+	}
+
+	list := func(name string, l *ListDiff) {
+		if l == nil {
+			return
+		}
+		for _, i := range l.Added {
+			fmt.Fprintf(&b, "    %s: +%s\n", name, i)
+		}
+		for _, i := range l.Removed {
+			fmt.Fprintf(&b, "    %s: -%s\n", name, i)
+		}
+	}
+	for _, p := range d.Changed {
+		fmt.Fprintf(&b, "* %s\n", p.Package)
+		list("requires", p.Requires)
+		list("conflicts", p.Conflicts)
+		list("uses", p.Uses)
+	}
+
+	fmt.Fprintf(&b, "%d added, %d removed, %d with new versions, %d changed\n",
+		len(d.Added), len(d.Removed), len(d.Bumped), len(d.Changed))
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
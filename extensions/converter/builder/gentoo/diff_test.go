@@ -0,0 +1,95 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo_test
+
+import (
+	"bytes"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/mudler/luet/pkg/tree/builder/gentoo"
+)
+
+var _ = Describe("Tree diff", func() {
+	var oldTree, newTree pkg.PackageDatabase
+
+	add := func(db pkg.PackageDatabase, p *pkg.DefaultPackage) {
+		_, err := db.CreatePackage(p)
+		Expect(err).Should(BeNil())
+	}
+
+	BeforeEach(func() {
+		oldTree = pkg.NewInMemoryDatabase(false)
+		newTree = pkg.NewInMemoryDatabase(false)
+
+		libffi := &pkg.DefaultPackage{Category: "dev-libs", Name: "libffi"}
+		add(oldTree, &pkg.DefaultPackage{Category: "dev-lang", Name: "python", Version: "3.8.5",
+			PackageRequires: []*pkg.DefaultPackage{libffi}, UseFlags: []string{"ssl"}})
+		add(oldTree, &pkg.DefaultPackage{Category: "dev-lang", Name: "python", Version: "3.8.6"})
+		add(oldTree, &pkg.DefaultPackage{Category: "app-misc", Name: "old", Version: "1.0"})
+		add(oldTree, &pkg.DefaultPackage{Category: "dev-libs", Name: "libffi", Version: "3.3"})
+
+		add(newTree, &pkg.DefaultPackage{Category: "dev-lang", Name: "python", Version: "3.8.5",
+			PackageRequires:  []*pkg.DefaultPackage{{Category: "dev-libs", Name: "libffi", Version: ">=3.3"}},
+			PackageConflicts: []*pkg.DefaultPackage{{Category: "dev-lang", Name: "python-legacy"}},
+			UseFlags:         []string{"ssl", "sqlite"}})
+		add(newTree, &pkg.DefaultPackage{Category: "dev-lang", Name: "python", Version: "3.8.10"})
+		add(newTree, &pkg.DefaultPackage{Category: "app-misc", Name: "new", Version: "2.0"})
+		add(newTree, &pkg.DefaultPackage{Category: "dev-libs", Name: "libffi", Version: "3.3"})
+	})
+
+	It("Compares the trees", func() {
+		d := DiffTrees(oldTree, newTree)
+		Expect(d.IsEmpty()).Should(BeFalse())
+		Expect(d.Added).Should(Equal([]PackageVersions{{Package: "app-misc/new", Versions: []string{"2.0"}}}))
+		Expect(d.Removed).Should(Equal([]PackageVersions{{Package: "app-misc/old", Versions: []string{"1.0"}}}))
+		Expect(d.Bumped).Should(Equal([]VersionBump{{Package: "dev-lang/python", Added: []string{"3.8.10"}, Removed: []string{"3.8.6"}}}))
+		Expect(d.Changed).Should(Equal([]PackageChanges{{
+			Package:   "dev-lang/python-3.8.5",
+			Requires:  &ListDiff{Added: []string{"dev-libs/libffi >=3.3"}, Removed: []string{"dev-libs/libffi"}},
+			Conflicts: &ListDiff{Added: []string{"dev-lang/python-legacy"}},
+			Uses:      &ListDiff{Added: []string{"sqlite"}},
+		}}))
+
+		Expect(DiffTrees(oldTree, oldTree).IsEmpty()).Should(BeTrue())
+	})
+
+	It("Writes the differences", func() {
+		d := DiffTrees(oldTree, newTree)
+
+		var text bytes.Buffer
+		Expect(d.WriteText(&text)).Should(BeNil())
+		Expect(text.String()).Should(Equal(`+ app-misc/new 2.0
+- app-misc/old 1.0
+~ dev-lang/python +3.8.10 -3.8.6
+* dev-lang/python-3.8.5
+    requires: +dev-libs/libffi >=3.3
+    requires: -dev-libs/libffi
+    conflicts: +dev-lang/python-legacy
+    uses: +sqlite
+1 added, 1 removed, 1 with new versions, 1 changed
+`))
+
+		var out bytes.Buffer
+		Expect(d.WriteJSON(&out)).Should(BeNil())
+		decoded := &TreeDiff{}
+		Expect(json.Unmarshal(out.Bytes(), decoded)).Should(BeNil())
+		Expect(decoded).Should(Equal(d))
+	})
+})
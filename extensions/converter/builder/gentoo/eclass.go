@@ -0,0 +1,202 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/mudler/luet/pkg/logger"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+const (
+	// EclassDir is the directory of the eclasses in a Gentoo tree.
+	EclassDir = "eclass"
+	// EclassExt is the extension of the eclass files.
+	EclassExt = ".eclass"
+)
+
+// EclassAccumulatedVars are the variables that, as in Portage, are
+// accumulated from the inherited eclasses instead of being overwritten
+// by the ebuild.
+var EclassAccumulatedVars = []string{
+	"IUSE", "REQUIRED_USE", "DEPEND", "RDEPEND", "PDEPEND", "BDEPEND",
+}
+
+// treeRoot returns the root of the tree of an ebuild:
+// <tree>/<category>/<package>/<ebuild>
+func treeRoot(ebuild string) string {
+	return filepath.Dir(filepath.Dir(filepath.Dir(ebuild)))
+}
+
+// EclassDirs returns the eclass directories available for an ebuild: the
+// one of its tree and then the ones of the given overlays.
+func EclassDirs(ebuild string, overlays []string) []string {
+	ans := []string{}
+	for _, t := range append([]string{treeRoot(ebuild)}, overlays...) {
+		dir := filepath.Join(t, EclassDir)
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			ans = append(ans, dir)
+		}
+	}
+	return ans
+}
+
+// FindEclass returns the path of an eclass from the given directories.
+func FindEclass(name string, dirs []string) (string, error) {
+	for _, dir := range dirs {
+		file := filepath.Join(dir, name+EclassExt)
+		if _, err := os.Stat(file); err == nil {
+			return file, nil
+		}
+	}
+	return "", &os.PathError{Op: "inherit", Path: name, Err: os.ErrNotExist}
+}
+
+// inheritFunc returns the definition of the inherit function. Every eclass
+// is sourced once, the accumulated variables it sets are appended to
+// the E_ variables and the values of the ebuild are restored.
+func inheritFunc() string {
+	var save, restore strings.Builder
+	for _, v := range EclassAccumulatedVars {
+		save.WriteString(fmt.Sprintf("\t\tlocal _set_%s=\"${%s+set}\" _val_%s=\"${%s}\"\n\t\tunset %s\n",
+			v, v, v, v, v))
+		restore.WriteString(fmt.Sprintf("\t\tE_%s=\"${E_%s} ${%s}\"\n\t\tunset %s\n\t\tif [ -n \"${_set_%s}\" ]; then %s=\"${_val_%s}\"; fi\n",
+			v, v, v, v, v, v, v))
+	}
+
+	// NOTE: the loop variable can't be local, the interpreter
+	// assigns it always in the global scope.
+	return "inherit() {\n" +
+		"\tfor _eclass in \"$@\"; do\n" +
+		"\t\tcase \" ${INHERITED} \" in\n" +
+		"\t\t*\" ${_eclass} \"*) continue ;;\n" +
+		"\t\tesac\n" +
+		"\t\tINHERITED=\"${INHERITED} ${_eclass}\"\n" +
+		save.String() +
+		"\t\tsource \"${_eclass}" + EclassExt + "\"\n" +
+		restore.String() +
+		"\tdone\n" +
+		"}\n"
+}
+
+// sourceNode runs the prelude and then the node in the sandbox with the
+// inherit support and returns the global variables.
+func (s *Sandbox) sourceNode(ctx context.Context, node *syntax.File, prelude string, eclassDirs []string) (map[string]expand.Variable, error) {
+	var stderr bytes.Buffer
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	run := s.newRun(eclassDirs, cancel)
+	sctx := &stepContext{Context: ctx, run: run}
+
+	// Only PATH is inherited from the host, to find the Allowed commands.
+	r, err := interp.New(
+		interp.Env(expand.ListEnviron("PATH="+os.Getenv("PATH"))),
+		interp.StdIO(nil, ioutil.Discard, &stderr),
+		interp.OpenHandler(run.openHandler()),
+		interp.ExecHandler(run.execHandler()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	pre, err := syntax.NewParser().Parse(strings.NewReader(inheritFunc()+prelude), "prelude")
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Run(sctx, pre); err != nil {
+		return nil, err
+	}
+
+	// The statements are run one by one to check the size of the
+	// variables, with no subshell running.
+	for _, stmt := range node.Stmts {
+		err = r.Run(sctx, stmt)
+		if r.Exited() {
+			break
+		}
+		if _, ok := interp.IsExitStatus(err); err != nil && !ok {
+			break
+		}
+		if s.MaxVarsSize > 0 && varsSize(r) > s.MaxVarsSize {
+			run.violate(&SandboxError{Violation: MemoryLimit, Detail: fmt.Sprintf("%d bytes", s.MaxVarsSize)})
+			break
+		}
+	}
+	if stderr.Len() > 0 {
+		Debug("Errors on source", node.Name, stderr.String())
+	}
+	if v := run.getViolation(); v != nil {
+		return nil, v
+	}
+	// The exit status of the last command is not relevant.
+	if _, ok := interp.IsExitStatus(err); err != nil && !ok {
+		return nil, fmt.Errorf("could not run: %v", err)
+	}
+
+	vars := make(map[string]expand.Variable)
+	for name, v := range r.Vars {
+		switch name {
+		// internal shell vars
+		case "PWD", "UID", "HOME", "PATH", "IFS", "OPTIND", "_eclass":
+			continue
+		}
+		if v.IsSet() {
+			vars[name] = v
+		}
+	}
+	mergeEclassVars(vars)
+
+	return vars, nil
+}
+
+// mergeEclassVars appends the values accumulated from the eclasses to the
+// values of the ebuild.
+func mergeEclassVars(vars map[string]expand.Variable) {
+	// EAPI 0-3: RDEPEND defaults to the DEPEND of the ebuild.
+	if _, ok := vars["RDEPEND"]; !ok && isLegacyEAPI(vars) {
+		if depend, ok := vars["DEPEND"]; ok {
+			vars["RDEPEND"] = depend
+		}
+	}
+
+	for _, name := range EclassAccumulatedVars {
+		e, ok := vars["E_"+name]
+		if !ok {
+			continue
+		}
+		delete(vars, "E_"+name)
+
+		value := strings.TrimSpace(e.String())
+		if value == "" {
+			continue
+		}
+		if v, ok := vars[name]; ok {
+			value = strings.TrimSpace(v.String() + " " + value)
+		}
+		vars[name] = expand.Variable{Kind: expand.String, Str: value}
+	}
+}
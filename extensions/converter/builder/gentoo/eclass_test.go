@@ -0,0 +1,123 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/mudler/luet/pkg/tree/builder/gentoo"
+)
+
+var _ = Describe("Eclass loader", func() {
+
+	Context("Inherited eclasses", func() {
+		var tmpdir, ebuild, overlay string
+
+		BeforeEach(func() {
+			var err error
+			tmpdir, err = ioutil.TempDir("", "eclass")
+			Expect(err).Should(BeNil())
+
+			write := func(file, content string) {
+				Expect(os.MkdirAll(filepath.Dir(file), os.ModePerm)).Should(BeNil())
+				Expect(ioutil.WriteFile(file, []byte(content), 0644)).Should(BeNil())
+			}
+
+			tree := filepath.Join(tmpdir, "tree")
+			overlay = filepath.Join(tmpdir, "gentoo")
+
+			write(filepath.Join(tree, EclassDir, "foo.eclass"), `
+inherit bar
+
+case ${EAPI:-0} in
+	7) ;;
+	*) die "EAPI ${EAPI} not supported" ;;
+esac
+
+foo_slot() {
+	echo 5
+}
+
+IUSE="python_targets_python3_9"
+RDEPEND="dev-lang/python"
+SLOT="$(foo_slot)"
+
+EXPORT_FUNCTIONS src_compile
+`)
+			write(filepath.Join(overlay, EclassDir, "bar.eclass"), `
+inherit foo
+IUSE="+bar"
+RDEPEND="app-misc/bar"
+BAR_SLOT=2
+`)
+			ebuild = filepath.Join(tree, "app-misc", "baz", "baz-1.0.ebuild")
+			write(ebuild, `EAPI=7
+inherit foo
+
+IUSE="doc"
+SLOT="${BAR_SLOT}"
+RDEPEND="app-misc/baz-data"
+`)
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tmpdir)
+		})
+
+		It("Accumulates the variables of the eclasses", func() {
+			parser := &SimpleEbuildParser{Overlays: []string{overlay}}
+			pkgs, err := parser.ScanEbuild(ebuild)
+			Expect(err).Should(BeNil())
+			Expect(len(pkgs)).Should(Equal(1))
+
+			p := pkgs[0]
+			Expect(p.GetCategory()).Should(Equal("app-misc-2"))
+			Expect(p.GetUses()).Should(ConsistOf("doc", "+bar", "python_targets_python3_9"))
+
+			requires := []string{}
+			for _, r := range p.GetRequires() {
+				requires = append(requires, r.GetCategory()+"/"+r.GetName())
+			}
+			Expect(requires).Should(ConsistOf("app-misc/baz-data", "app-misc/bar", "dev-lang/python"))
+		})
+
+		It("Ignores the eclasses not available", func() {
+			parser := &SimpleEbuildParser{}
+			pkgs, err := parser.ScanEbuild(ebuild)
+			Expect(err).Should(BeNil())
+			Expect(len(pkgs)).Should(Equal(1))
+			Expect(pkgs[0].GetUses()).Should(ConsistOf("doc", "python_targets_python3_9"))
+			Expect(len(pkgs[0].GetRequires())).Should(Equal(2))
+		})
+
+		It("Finds the eclasses from the tree first", func() {
+			dirs := EclassDirs(ebuild, []string{overlay, filepath.Join(tmpdir, "missing")})
+			Expect(len(dirs)).Should(Equal(2))
+
+			file, err := FindEclass("bar", dirs)
+			Expect(err).Should(BeNil())
+			Expect(file).Should(Equal(filepath.Join(overlay, EclassDir, "bar.eclass")))
+
+			_, err = FindEclass("missing", dirs)
+			Expect(err).ShouldNot(BeNil())
+		})
+	})
+})
@@ -0,0 +1,265 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
+	pkg "github.com/mudler/luet/pkg/package"
+)
+
+// PackageMaskFile is the path of the package.mask of a Gentoo tree.
+const PackageMaskFile = "profiles/package.mask"
+
+// Stability is the level of stability of the converted packages.
+type Stability int
+
+const (
+	// StabilityAny accepts every package, whatever its keywords.
+	StabilityAny Stability = iota
+	// StabilityTesting accepts the packages with stable or testing (~arch) keywords.
+	StabilityTesting
+	// StabilityStable accepts only the packages with stable keywords.
+	StabilityStable
+)
+
+// ParseStability returns the Stability from its name (stable, testing, any).
+func ParseStability(s string) (Stability, error) {
+	switch s {
+	case "", "any":
+		return StabilityAny, nil
+	case "testing":
+		return StabilityTesting, nil
+	case "stable":
+		return StabilityStable, nil
+	}
+	return StabilityAny, fmt.Errorf("invalid stability %s", s)
+}
+
+// SkippedEbuild is an ebuild, or a package, excluded from the conversion.
+type SkippedEbuild struct {
+	Ebuild  string
+	Package string
+	Reason  string
+}
+
+// PackageFilter selects the packages to convert by keywords and masks.
+type PackageFilter struct {
+	// Arch is the architecture of the keywords (e.g. amd64)
+	Arch      string
+	Stability Stability
+	// Masks are the masked atoms, in package.mask format
+	Masks []string
+	// BestVersion keeps only the highest version of every slot
+	BestVersion bool
+}
+
+// NewPackageFilter returns a filter that accepts every package.
+func NewPackageFilter() *PackageFilter {
+	return &PackageFilter{Stability: StabilityAny, Masks: []string{}}
+}
+
+// AddMask adds a line of a package.mask file. Lines starting with -
+// remove a previous mask.
+func (f *PackageFilter) AddMask(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+
+	if strings.HasPrefix(line, "-") {
+		atom := strings.TrimPrefix(line, "-")
+		masks := make([]string, 0, len(f.Masks))
+		for _, m := range f.Masks {
+			if m != atom {
+				masks = append(masks, m)
+			}
+		}
+		f.Masks = masks
+		return
+	}
+
+	f.Masks = append(f.Masks, line)
+}
+
+// LoadMaskFile adds the masks of a package.mask file or directory.
+func (f *PackageFilter) LoadMaskFile(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		files, err := ioutil.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		// ReadDir returns the files sorted by name
+		for _, file := range files {
+			if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+				continue
+			}
+			if err := f.LoadMaskFile(filepath.Join(path, file.Name())); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		f.AddMask(scanner.Text())
+	}
+	return scanner.Err()
+}
+
+// AcceptKeywords returns true if the keywords are accepted
+// by the arch and the stability of the filter.
+func (f *PackageFilter) AcceptKeywords(keywords []string) bool {
+	if f.Stability == StabilityAny || f.Arch == "" {
+		return true
+	}
+
+	for _, k := range keywords {
+		switch k {
+		case f.Arch, "*":
+			return true
+		case "~" + f.Arch, "~*":
+			if f.Stability == StabilityTesting {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Admit returns true if the package is accepted by the filter,
+// or false and the reason.
+func (f *PackageFilter) Admit(p pkg.Package) (bool, string) {
+	keywords := strings.Fields(p.GetAnnotations()[GentooKeywordsAnnotation])
+	if !f.AcceptKeywords(keywords) {
+		if len(keywords) == 0 {
+			return false, "no keywords"
+		}
+		return false, "keywords " + strings.Join(keywords, " ")
+	}
+
+	if len(f.Masks) > 0 {
+		gp, err := gentooPackage(p)
+		if err != nil {
+			return true, ""
+		}
+		for _, m := range f.Masks {
+			if MatchAtom(m, gp) {
+				return false, "masked by " + m
+			}
+		}
+	}
+
+	return true, ""
+}
+
+// FilterBestVersions removes from the database all the packages that
+// aren't the highest version of their slot.
+func (f *PackageFilter) FilterBestVersions(db pkg.PackageDatabase) ([]SkippedEbuild, error) {
+	skipped := []SkippedEbuild{}
+	if !f.BestVersion {
+		return skipped, nil
+	}
+
+	type version struct {
+		p  pkg.Package
+		gv *GentooVersion
+	}
+	best := make(map[string]version)
+	toRemove := []pkg.Package{}
+
+	for _, p := range db.World() {
+		gp, err := gentooPackage(p)
+		if err != nil {
+			continue
+		}
+		gv, err := ParseGentooVersion(gp.Version + gp.VersionSuffix)
+		if err != nil {
+			continue
+		}
+		// The variants of an ebuild are different packages
+		key := gp.GetPackageName() + ":" + gp.Slot + ":" + p.GetName()
+
+		b, ok := best[key]
+		if !ok {
+			best[key] = version{p: p, gv: gv}
+			continue
+		}
+		if CompareVersions(gv, b.gv) > 0 {
+			best[key] = version{p: p, gv: gv}
+			toRemove = append(toRemove, b.p)
+		} else {
+			toRemove = append(toRemove, p)
+		}
+	}
+
+	for _, p := range toRemove {
+		if err := db.RemovePackage(p); err != nil {
+			return skipped, err
+		}
+		skipped = append(skipped, SkippedEbuild{
+			Package: p.HumanReadableString(),
+			Reason:  "not the best version of the slot",
+		})
+	}
+	sort.Slice(skipped, func(i, j int) bool { return skipped[i].Package < skipped[j].Package })
+
+	return skipped, nil
+}
+
+// gentooPackage returns the Gentoo package of a converted package.
+func gentooPackage(p pkg.Package) (*_gentoo.GentooPackage, error) {
+	atom, ok := p.GetAnnotations()[GentooAtomAnnotation]
+	if !ok {
+		return nil, fmt.Errorf("package %s without gentoo atom", p.HumanReadableString())
+	}
+
+	gp, err := _gentoo.ParsePackageStr(strings.TrimPrefix(atom, "="))
+	if err != nil {
+		return nil, err
+	}
+	gp.Condition = _gentoo.PkgCondInvalid
+
+	slot := p.GetAnnotations()[GentooSlotAnnotation]
+	if i := strings.Index(slot, "/"); i >= 0 {
+		slot = slot[:i]
+	}
+	if slot == "" {
+		slot = "0"
+	}
+	gp.Slot = slot
+
+	return gp, nil
+}
@@ -0,0 +1,136 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/mudler/luet/pkg/tree/builder/gentoo"
+)
+
+var _ = Describe("Package filter", func() {
+
+	Context("Keywords", func() {
+		It("Parses the stability", func() {
+			s, err := ParseStability("testing")
+			Expect(err).Should(BeNil())
+			Expect(s).Should(Equal(StabilityTesting))
+			_, err = ParseStability("unstable")
+			Expect(err).ShouldNot(BeNil())
+		})
+
+		It("Accepts the keywords", func() {
+			for _, c := range []struct {
+				stability Stability
+				keywords  []string
+				accepted  bool
+			}{
+				{StabilityStable, []string{"amd64", "~x86"}, true},
+				{StabilityStable, []string{"~amd64", "x86"}, false},
+				{StabilityTesting, []string{"~amd64"}, true},
+				{StabilityTesting, []string{}, false},
+				{StabilityTesting, []string{"-*"}, false},
+				{StabilityStable, []string{"-*", "amd64"}, true},
+				{StabilityTesting, []string{"-amd64", "~x86"}, false},
+				{StabilityAny, []string{}, true},
+			} {
+				f := NewPackageFilter()
+				f.Arch = "amd64"
+				f.Stability = c.stability
+				Expect(f.AcceptKeywords(c.keywords)).Should(Equal(c.accepted), "%v", c)
+			}
+		})
+	})
+
+	Context("Masks and best versions", func() {
+		var tmpdir string
+
+		write := func(file, content string) {
+			Expect(os.MkdirAll(filepath.Dir(file), os.ModePerm)).Should(BeNil())
+			Expect(ioutil.WriteFile(file, []byte(content), 0644)).Should(BeNil())
+		}
+
+		BeforeEach(func() {
+			var err error
+			tmpdir, err = ioutil.TempDir("", "filter")
+			Expect(err).Should(BeNil())
+
+			for _, e := range []struct{ pf, slot, keywords string }{
+				{"foo-1.0", "0", "amd64 x86"},
+				{"foo-1.1", "0", "~amd64"},
+				{"foo-2.0", "2", "amd64"},
+				{"foo-2.1", "2", "amd64"},
+				{"foo-9999", "2", ""},
+				{"foo-3.0", "3", "amd64"},
+			} {
+				write(filepath.Join(tmpdir, "app-misc", "foo", e.pf+".ebuild"),
+					"EAPI=7\nSLOT=\""+e.slot+"\"\nKEYWORDS=\""+e.keywords+"\"\n")
+			}
+			write(filepath.Join(tmpdir, PackageMaskFile), `# Masked for removal
+>=app-misc/foo-3.0
+app-misc/bar
+`)
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tmpdir)
+		})
+
+		It("Loads and removes the masks", func() {
+			f := NewPackageFilter()
+			Expect(f.LoadMaskFile(filepath.Join(tmpdir, PackageMaskFile))).Should(BeNil())
+			Expect(f.Masks).Should(Equal([]string{">=app-misc/foo-3.0", "app-misc/bar"}))
+			f.AddMask("-app-misc/bar")
+			Expect(f.Masks).Should(Equal([]string{">=app-misc/foo-3.0"}))
+		})
+
+		It("Converts only the packages admitted", func() {
+			f := NewPackageFilter()
+			f.Arch = "amd64"
+			f.Stability = StabilityStable
+			f.BestVersion = true
+			Expect(f.LoadMaskFile(filepath.Join(tmpdir, PackageMaskFile))).Should(BeNil())
+
+			gb := NewGentooBuilder(&SimpleEbuildParser{}, 2, InMemory)
+			gb.Filter = f
+			db, err := gb.Generate(tmpdir)
+			Expect(err).Should(BeNil())
+			defer db.Clean()
+
+			versions := []string{}
+			for _, p := range db.World() {
+				versions = append(versions, p.GetCategory()+"/"+p.GetName()+"-"+p.GetVersion())
+			}
+			Expect(versions).Should(ConsistOf("app-misc/foo-"+luetVersion("1.0"), "app-misc-2/foo-"+luetVersion("2.1")))
+
+			reasons := map[string]string{}
+			for _, s := range gb.GetSkipped() {
+				reasons[s.Package] = s.Reason
+			}
+			Expect(len(reasons)).Should(Equal(4))
+			Expect(reasons[(&pkg.DefaultPackage{Category: "app-misc", Name: "foo", Version: luetVersion("1.1")}).HumanReadableString()]).Should(Equal("keywords ~amd64"))
+			Expect(reasons[(&pkg.DefaultPackage{Category: "app-misc-2", Name: "foo", Version: luetVersion("9999")}).HumanReadableString()]).Should(Equal("no keywords"))
+			Expect(reasons[(&pkg.DefaultPackage{Category: "app-misc-3", Name: "foo", Version: luetVersion("3.0")}).HumanReadableString()]).Should(Equal("masked by >=app-misc/foo-3.0"))
+			Expect(reasons[(&pkg.DefaultPackage{Category: "app-misc-2", Name: "foo", Version: luetVersion("2.0")}).HumanReadableString()]).Should(Equal("not the best version of the slot"))
+		})
+	})
+})
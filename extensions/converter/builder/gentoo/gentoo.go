@@ -19,17 +19,21 @@ package gentoo
 // https://gist.github.com/adnaan/6ca68c7985c6f851def3
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	. "github.com/mudler/luet/pkg/logger"
-	tree "github.com/mudler/luet/pkg/tree"
 
 	pkg "github.com/mudler/luet/pkg/package"
+	"github.com/mudler/luet/pkg/tree/builder/common"
 )
 
 type MemoryDB int
@@ -39,103 +43,378 @@ const (
 	BoltDB   MemoryDB = iota
 )
 
-func NewGentooBuilder(e EbuildParser, concurrency int, db MemoryDB) tree.Parser {
-	return &GentooBuilder{EbuildParser: e, Concurrency: concurrency}
+func NewGentooBuilder(e EbuildParser, concurrency int, db MemoryDB) *GentooBuilder {
+	return &GentooBuilder{EbuildParser: e, Concurrency: concurrency, DBType: db}
 }
 
 type GentooBuilder struct {
 	EbuildParser EbuildParser
 	Concurrency  int
 	DBType       MemoryDB
+	// DBPath is the file of the BoltDB database. If empty a temporary
+	// file is used.
+	DBPath string
+	// Resume skips the ebuilds already available in the BoltDB database
+	// at DBPath, instead of starting from an empty database.
+	Resume bool
+	// Profile is passed to the parsers implementing ProfileAwareParser.
+	Profile *ConversionProfile
+	// Filter, if set, selects the packages to convert.
+	Filter *PackageFilter
+	// Selector, if set, selects the ebuilds of the tree to scan.
+	Selector *PackageSelector
+	// Virtuals, if set, replaces the virtual/* packages with their
+	// providers after the scan.
+	Virtuals *VirtualResolver
+	// Cache, if set, is used to scan only the ebuilds changed.
+	Cache *EbuildCache
+	// Timeout is the maximum time spent on every ebuild, if zero
+	// the parsers use DefaultEbuildTimeout.
+	Timeout time.Duration
+	// OnProgress, if set, is called after every ebuild scanned and
+	// replaces the spinner.
+	OnProgress common.ProgressFunc
+
+	skipped      []SkippedEbuild
+	skippedMutex sync.Mutex
+	report       *ConversionReport
+}
+
+// GetSkipped returns the ebuilds excluded by the Filter on the last Generate.
+func (gb *GentooBuilder) GetSkipped() []SkippedEbuild {
+	gb.skippedMutex.Lock()
+	defer gb.skippedMutex.Unlock()
+	return append([]SkippedEbuild{}, gb.skipped...)
+}
+
+func (gb *GentooBuilder) addSkipped(s ...SkippedEbuild) {
+	gb.skippedMutex.Lock()
+	defer gb.skippedMutex.Unlock()
+	gb.skipped = append(gb.skipped, s...)
 }
 
 type EbuildParser interface {
 	ScanEbuild(string) (pkg.Packages, error)
 }
 
-func (gb *GentooBuilder) scanEbuild(path string, db pkg.PackageDatabase) error {
+// ContextEbuildParser is implemented by the parsers that can stop the
+// scan of an ebuild when the context is canceled.
+type ContextEbuildParser interface {
+	ScanEbuildContext(context.Context, string) (pkg.Packages, error)
+}
+
+func (gb *GentooBuilder) scanEbuild(ctx context.Context, path string, db pkg.PackageDatabase) (res *EbuildResult) {
+	start := time.Now()
 	defer func() {
 		if r := recover(); r != nil {
-			Error(r)
+			Error(path, ":", r)
+			res = newResult(path, start)
+			res.Status = EbuildPanic
+			res.Message = fmt.Sprint(r)
 		}
 	}()
-	pkgs, err := gb.EbuildParser.ScanEbuild(path)
+
+	pkgs, cached, err := gb.scan(ctx, path)
+	res = newResult(path, start)
 	if err != nil {
-		return err
+		res.Status = EbuildParseError
+		var serr *SandboxError
+		if errors.Is(err, ErrEbuildTimeout) {
+			res.Status = EbuildTimeout
+		} else if errors.As(err, &serr) {
+			res.Status = EbuildForbidden
+		}
+		res.Message = err.Error()
+		return res
+	}
+	if cached {
+		res.Message = "cached"
 	}
+
+	reasons := []string{}
 	for _, p := range pkgs {
+		if gb.Filter != nil {
+			if ok, reason := gb.Filter.Admit(p); !ok {
+				Debug("Skip", path, reason)
+				gb.addSkipped(SkippedEbuild{
+					Ebuild:  path,
+					Package: p.HumanReadableString(),
+					Reason:  reason,
+				})
+				reasons = append(reasons, reason)
+				continue
+			}
+		}
 		_, err := db.FindPackage(p)
 		if err != nil {
 			_, err := db.CreatePackage(p)
 			if err != nil {
-				return err
+				res.Status = EbuildParseError
+				res.Message = err.Error()
+				return res
 			}
 		}
+		res.Packages = append(res.Packages, p.HumanReadableString())
 	}
-	return nil
+
+	if len(res.Packages) == 0 && len(reasons) > 0 {
+		res.Status = EbuildSkipped
+		res.Message = strings.Join(reasons, "; ")
+	}
+
+	return res
 }
 
-func (gb *GentooBuilder) worker(i int, wg *sync.WaitGroup, s <-chan string, db pkg.PackageDatabase) {
+// scan returns the packages of an ebuild from the cache, when available,
+// or from the parser.
+func (gb *GentooBuilder) scan(ctx context.Context, path string) (pkg.Packages, bool, error) {
+	if gb.Cache == nil {
+		pkgs, err := gb.parse(ctx, path)
+		return pkgs, false, err
+	}
+
+	if pkgs, ok := gb.Cache.Get(path); ok {
+		Debug("Using cache for", path)
+		return pkgs, true, nil
+	}
+
+	pkgs, err := gb.parse(ctx, path)
+	if err != nil {
+		return pkgs, false, err
+	}
+
+	eclasses := []string{}
+	if p, ok := gb.EbuildParser.(EclassAwareParser); ok {
+		eclasses = inheritedEclasses(pkgs, p.GetEclassDirs(path))
+	}
+	if err := gb.Cache.Put(path, eclasses, pkgs); err != nil {
+		Warning("Error on caching", path, err.Error())
+	}
+
+	return pkgs, false, nil
+}
+
+// parse scans an ebuild with the parser, within the Timeout.
+func (gb *GentooBuilder) parse(ctx context.Context, path string) (pkg.Packages, error) {
+	p, ok := gb.EbuildParser.(ContextEbuildParser)
+	if !ok {
+		return gb.EbuildParser.ScanEbuild(path)
+	}
+
+	if gb.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, gb.Timeout)
+		defer cancel()
+	}
+	pkgs, err := p.ScanEbuildContext(ctx, path)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = ErrEbuildTimeout
+	}
+	return pkgs, err
+}
+
+func (gb *GentooBuilder) worker(ctx context.Context, i int, wg *sync.WaitGroup, s <-chan string, db pkg.PackageDatabase, progress *common.ProgressTracker) {
 	defer wg.Done()
 
 	for path := range s {
 		Info("#"+strconv.Itoa(i), "parsing", path)
-		err := gb.scanEbuild(path, db)
-		if err != nil {
-			Error(path, ":", err.Error())
+		res := gb.scanEbuild(ctx, path, db)
+		if ctx.Err() != nil {
+			// Interrupted, the ebuild is not converted
+			continue
+		}
+		if res.Status.IsFailure() {
+			Error(path, ":", res.Message)
 		}
+		gb.report.Add(res)
+		progress.Done(res.Status.IsFailure())
 	}
 
 }
 
-func (gb *GentooBuilder) Generate(dir string) (pkg.PackageDatabase, error) {
+// GetReport returns the results of the ebuilds scanned on the last Generate.
+func (gb *GentooBuilder) GetReport() *ConversionReport {
+	return gb.report
+}
 
-	var toScan = make(chan string)
-	Spinner(27)
-	defer SpinnerStop()
-	var db pkg.PackageDatabase
-	// Support for
+// NewDatabase returns the database where Generate stores the packages.
+// The BoltDB database at DBPath is cleaned, unless Resume is set.
+func (gb *GentooBuilder) NewDatabase() (pkg.PackageDatabase, error) {
 	switch gb.DBType {
-	case InMemory:
-		db = pkg.NewInMemoryDatabase(false)
 	case BoltDB:
-		tmpfile, err := ioutil.TempFile("", "boltdb")
-		if err != nil {
+		if gb.DBPath == "" {
+			tmpfile, err := ioutil.TempFile("", "boltdb")
+			if err != nil {
+				return nil, err
+			}
+			tmpfile.Close()
+			return pkg.NewBoltDatabase(tmpfile.Name()), nil
+		}
+
+		if err := os.MkdirAll(filepath.Dir(gb.DBPath), os.ModePerm); err != nil {
 			return nil, err
 		}
-		db = pkg.NewBoltDatabase(tmpfile.Name())
+		db := pkg.NewBoltDatabase(gb.DBPath)
+		if !gb.Resume {
+			if err := db.Clean(); err != nil {
+				return nil, err
+			}
+		}
+		return db, nil
 	default:
-		db = pkg.NewInMemoryDatabase(false)
+		return pkg.NewInMemoryDatabase(false), nil
 	}
+}
 
-	Debug("Concurrency", gb.Concurrency)
-	// the waitgroup will allow us to wait for all the goroutines to finish at the end
-	var wg = new(sync.WaitGroup)
-	for i := 0; i < gb.Concurrency; i++ {
-		wg.Add(1)
-		go gb.worker(i, wg, toScan, db)
+func (gb *GentooBuilder) Generate(dir string) (pkg.PackageDatabase, error) {
+	return gb.GenerateContext(context.Background(), dir)
+}
+
+// GenerateContext is Generate with a context: when it is canceled the
+// walk and the workers are stopped and its error is returned.
+func (gb *GentooBuilder) GenerateContext(ctx context.Context, dir string) (pkg.PackageDatabase, error) {
+
+	if p, ok := gb.EbuildParser.(ProfileAwareParser); ok && gb.Profile != nil {
+		p.SetProfile(gb.Profile)
+	}
+
+	gb.skipped = []SkippedEbuild{}
+	gb.report = NewConversionReport()
+	if gb.Cache != nil {
+		gb.Cache.Begin(dir)
+		if p, ok := gb.EbuildParser.(FingerprintParser); ok {
+			gb.Cache.SetOptions(p.Fingerprint())
+		}
+	}
+
+	var toScan = make(chan string)
+	if gb.OnProgress == nil {
+		Spinner(27)
+		defer SpinnerStop()
+	}
+	db, err := gb.NewDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	converted := make(map[string]bool)
+	if gb.Resume {
+		for _, p := range db.World() {
+			converted[p.GetAnnotations()[GentooAtomAnnotation]] = true
+		}
+		Info("Resuming conversion,", len(converted), "ebuilds already converted")
 	}
 
 	// TODO: Handle cleaning after? Cleanup implemented in GetPackageSet().Clean()
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+	ebuilds := []string{}
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		if info.IsDir() {
+			if gb.Selector != nil && filepath.Dir(path) == filepath.Clean(dir) && gb.Selector.SkipCategory(info.Name()) {
+				// The packages not selected are left as they are
+				if gb.Cache != nil {
+					gb.Cache.KeepDir(path)
+				}
+				return filepath.SkipDir
+			}
 			return nil
 		}
 		// Ensure that only file with suffix .ebuild are elaborated.
 		// and ignore .swp files or files with string ebuild on name
 		if strings.HasSuffix(info.Name(), ".ebuild") {
-			toScan <- path
+			if gb.Selector != nil {
+				if gp, err := parseEbuildPath(path); err == nil {
+					if ok, reason := gb.Selector.Select(gp); !ok {
+						Debug("Skip", path, reason)
+						if gb.Cache != nil {
+							gb.Cache.Keep(path)
+						}
+						return nil
+					}
+				}
+			}
+			if gb.Resume {
+				if gp, err := parseEbuildPath(path); err == nil && converted[ebuildAtom(gp)] {
+					Debug("Skip", path, "already converted")
+					res := newResult(path, time.Now())
+					res.Message = "already in the database"
+					gb.report.Add(res)
+					if gb.Cache != nil {
+						gb.Cache.Keep(path)
+					}
+					return nil
+				}
+			}
+			ebuilds = append(ebuilds, path)
 		}
 		return nil
 	})
+	if err != nil {
+		return db, err
+	}
+
+	Debug("Concurrency", gb.Concurrency)
+	progress := common.NewProgressTracker(len(ebuilds), gb.OnProgress)
+	// the waitgroup will allow us to wait for all the goroutines to finish at the end
+	var wg = new(sync.WaitGroup)
+	for i := 0; i < gb.Concurrency; i++ {
+		wg.Add(1)
+		go gb.worker(ctx, i, wg, toScan, db, progress)
+	}
+
+send:
+	for _, path := range ebuilds {
+		select {
+		case toScan <- path:
+		case <-ctx.Done():
+			break send
+		}
+	}
 
 	close(toScan)
 	wg.Wait()
-	if err != nil {
-		return db, err
+	if ctx.Err() != nil {
+		return db, ctx.Err()
+	}
+
+	if gb.Cache != nil {
+		gb.Cache.End()
+	}
+
+	if gb.Selector != nil && gb.Selector.Deps {
+		for _, p := range gb.Selector.Unrequired(db) {
+			if err := db.RemovePackage(p); err != nil {
+				return db, err
+			}
+			gb.report.SkipPackage(p.HumanReadableString(), "not required by the selected packages")
+		}
+	}
+
+	if gb.Filter != nil {
+		skipped, err := gb.Filter.FilterBestVersions(db)
+		gb.addSkipped(skipped...)
+		for _, s := range skipped {
+			gb.report.SkipPackage(s.Package, s.Reason)
+		}
+		if err != nil {
+			return db, err
+		}
+	}
+
+	if gb.Virtuals != nil {
+		resolved, err := gb.Virtuals.Resolve(db)
+		for _, r := range resolved {
+			gb.report.SkipPackage(r.Virtual.HumanReadableString(),
+				"virtual provided by "+r.Provider.GetCategory()+"/"+r.Provider.GetName())
+		}
+		if err != nil {
+			return db, err
+		}
 	}
 
 	return db, nil
@@ -16,11 +16,18 @@
 package gentoo_test
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	pkg "github.com/mudler/luet/pkg/package"
+	"github.com/mudler/luet/pkg/tree/builder/common"
 	. "github.com/mudler/luet/pkg/tree/builder/gentoo"
 )
 
@@ -47,6 +54,100 @@ var _ = Describe("GentooBuilder", func() {
 		}
 	})
 
+	Context("Persistent BoltDB database", func() {
+		It("Resumes the conversion", func() {
+			tmpdir, err := ioutil.TempDir("", "boltdb")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir)
+			dbPath := filepath.Join(tmpdir, "db", "packages.db")
+
+			gb := NewGentooBuilder(&SimpleEbuildParser{}, 2, BoltDB)
+			gb.DBPath = dbPath
+			Expect(gb.DBType).To(Equal(BoltDB))
+			tree, err := gb.Generate("../../../../tests/fixtures/overlay")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(tree.World())).To(Equal(10))
+			Expect(dbPath).To(BeAnExistingFile())
+
+			gb = NewGentooBuilder(&SimpleEbuildParser{}, 2, BoltDB)
+			gb.DBPath = dbPath
+			gb.Resume = true
+			tree, err = gb.Generate("../../../../tests/fixtures/overlay")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(tree.World())).To(Equal(10))
+			Expect(gb.GetReport().Count(EbuildOk)).To(Equal(10))
+			for _, r := range gb.GetReport().Results {
+				Expect(r.Message).To(Equal("already in the database"))
+			}
+
+			// Without resume the database is regenerated
+			gb = NewGentooBuilder(&FakeParser{}, 2, BoltDB)
+			gb.DBPath = dbPath
+			tree, err = gb.Generate("../../../../tests/fixtures/overlay")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(tree.World())).To(Equal(10))
+			for _, p := range tree.World() {
+				Expect(p.GetAnnotations()).ToNot(HaveKey(GentooAtomAnnotation))
+			}
+		})
+	})
+
+	Context("Cancellation and progress", func() {
+		It("Reports the progress of the scan", func() {
+			progress := []common.Progress{}
+			gb := NewGentooBuilder(&FakeParser{}, 4, InMemory)
+			gb.OnProgress = func(p common.Progress) {
+				progress = append(progress, p)
+			}
+			tree, err := gb.Generate("../../../../tests/fixtures/overlay")
+			Expect(err).ToNot(HaveOccurred())
+			defer tree.Clean()
+
+			Expect(len(progress)).To(Equal(10))
+			last := progress[len(progress)-1]
+			Expect(last.Scanned).To(Equal(10))
+			Expect(last.Total).To(Equal(10))
+			Expect(last.Failed).To(Equal(0))
+			Expect(last.ETA()).To(Equal(time.Duration(0)))
+		})
+
+		It("Estimates the time left", func() {
+			p := common.Progress{Scanned: 10, Total: 40, Elapsed: 5 * time.Second}
+			Expect(p.ETA()).To(Equal(15 * time.Second))
+			Expect(p.String()).To(Equal("10/40 scanned, 0 failed, ETA 15s"))
+		})
+
+		It("Stops when the context is canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			gb := NewGentooBuilder(&SimpleEbuildParser{}, 2, InMemory)
+			_, err := gb.GenerateContext(ctx, "../../../../tests/fixtures/overlay")
+			Expect(err).To(Equal(context.Canceled))
+			Expect(len(gb.GetReport().Results)).To(Equal(0))
+		})
+
+		It("Stops the ebuilds taking more than the timeout", func() {
+			tmpdir, err := ioutil.TempDir("", "timeout")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir)
+			ebuild := filepath.Join(tmpdir, "app-misc", "loop", "loop-1.0.ebuild")
+			Expect(os.MkdirAll(filepath.Dir(ebuild), os.ModePerm)).To(Succeed())
+			Expect(ioutil.WriteFile(ebuild, []byte("SLOT=0\nwhile true; do :; done\n"), 0644)).To(Succeed())
+
+			// Without the step limit, that would stop the loop before
+			sandbox := NewSandbox()
+			sandbox.MaxSteps = 0
+			gb := NewGentooBuilder(&SimpleEbuildParser{Sandbox: sandbox}, 1, InMemory)
+			gb.Timeout = 200 * time.Millisecond
+			tree, err := gb.Generate(tmpdir)
+			Expect(err).ToNot(HaveOccurred())
+			defer tree.Clean()
+			Expect(len(tree.World())).To(Equal(0))
+			Expect(gb.GetReport().Count(EbuildTimeout)).To(Equal(1))
+		})
+	})
+
 	Context("Parse ebuild1", func() {
 		parser := &SimpleEbuildParser{}
 		pkgs, err := parser.ScanEbuild("../../../../tests/fixtures/overlay/app-crypt/pinentry-gnome/pinentry-gnome-1.0.0-r2.ebuild")
@@ -77,12 +178,77 @@ var _ = Describe("GentooBuilder", func() {
 		It("Check parsing of the ebuild3", func() {
 			Expect(err).ToNot(HaveOccurred())
 			fmt.Println("PKG ", pkgs[0])
-			Expect(len(pkgs[0].GetRequires())).To(Equal(0))
+			// The first alternative of the any-of group
+			Expect(pkgs[0].GetRequires()).To(Equal([]*pkg.DefaultPackage{
+				&pkg.DefaultPackage{Name: "sabayon-sources", Category: "sys-kernel"},
+			}))
 			Expect(pkgs[0].GetLicense()).To(Equal(""))
 			Expect(pkgs[0].GetDescription()).To(Equal("Virtual for Linux kernel sources"))
 		})
 	})
 
+	Context("Parse ebuild3 with a World database", func() {
+		world := pkg.NewInMemoryDatabase(false)
+		world.CreatePackage(&pkg.DefaultPackage{Name: "gentoo-sources", Category: "sys-kernel", Version: "5.4.0"})
+		parser := &SimpleEbuildParser{World: world}
+		pkgs, err := parser.ScanEbuild("../../../../tests/fixtures/parser/linux-sources-1.ebuild")
+
+		It("Prefers the alternative available in World", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pkgs[0].GetRequires()).To(Equal([]*pkg.DefaultPackage{
+				&pkg.DefaultPackage{Name: "gentoo-sources", Category: "sys-kernel"},
+			}))
+		})
+
+		It("Looks up the slotted alternatives with the slot strategy", func() {
+			tmpdir, err := ioutil.TempDir("", "world")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir)
+
+			ebuild := filepath.Join(tmpdir, "app-misc", "foo", "foo-1.0.ebuild")
+			Expect(os.MkdirAll(filepath.Dir(ebuild), os.ModePerm)).ToNot(HaveOccurred())
+			Expect(ioutil.WriteFile(ebuild, []byte(`EAPI=7
+SLOT=0
+RDEPEND="|| ( dev-lang/python:3.8 dev-lang/python:3.9 )"
+`), 0644)).ToNot(HaveOccurred())
+
+			world := pkg.NewInMemoryDatabase(false)
+			world.CreatePackage(&pkg.DefaultPackage{Name: "python", Category: "dev-lang-3.9", Version: "3.9.0"})
+			parser := &SimpleEbuildParser{World: world}
+			pkgs, err := parser.ScanEbuild(ebuild)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pkgs[0].GetRequires()).To(Equal([]*pkg.DefaultPackage{
+				&pkg.DefaultPackage{Name: "python", Category: "dev-lang-3.9"},
+			}))
+		})
+	})
+
+	Context("Parse an ebuild with a version range", func() {
+		It("Requires the package with both the selectors of the range", func() {
+			tmpdir, err := ioutil.TempDir("", "range")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir)
+
+			ebuild := filepath.Join(tmpdir, "app-misc", "foo", "foo-1.0.ebuild")
+			Expect(os.MkdirAll(filepath.Dir(ebuild), os.ModePerm)).ToNot(HaveOccurred())
+			Expect(ioutil.WriteFile(ebuild, []byte(`EAPI=7
+SLOT=0
+RDEPEND="=dev-libs/bar-1.2* !=dev-libs/baz-2*"
+`), 0644)).ToNot(HaveOccurred())
+
+			parser := &SimpleEbuildParser{}
+			pkgs, err := parser.ScanEbuild(ebuild)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pkgs[0].GetRequires()).To(Equal([]*pkg.DefaultPackage{
+				&pkg.DefaultPackage{Name: "bar", Category: "dev-libs", Version: ">=1.2"},
+				&pkg.DefaultPackage{Name: "bar", Category: "dev-libs", Version: "<1.3"},
+			}))
+			Expect(pkgs[0].GetConflicts()).To(Equal([]*pkg.DefaultPackage{
+				&pkg.DefaultPackage{Name: "baz", Category: "dev-libs", Version: "=2.0.0*"},
+			}))
+		})
+	})
+
 	Context("Parse ebuild4", func() {
 		parser := &SimpleEbuildParser{}
 		pkgs, err := parser.ScanEbuild("../../../../tests/fixtures/parser/sabayon-mce-1.1-r5.ebuild")
@@ -142,7 +308,8 @@ var _ = Describe("GentooBuilder", func() {
 		It("Check parsing of the ebuild8", func() {
 			Expect(err).ToNot(HaveOccurred())
 			fmt.Println("PKG ", pkgs[0])
-			Expect(len(pkgs[0].GetRequires())).To(Equal(25))
+			// 25 from RDEPEND + subversion-java from PDEPEND
+			Expect(len(pkgs[0].GetRequires())).To(Equal(26))
 			Expect(pkgs[0].GetLicense()).To(Equal("Subversion GPL-2"))
 			Expect(pkgs[0].GetDescription()).To(Equal("Advanced version control system"))
 		})
@@ -174,4 +341,42 @@ var _ = Describe("GentooBuilder", func() {
 		})
 	})
 
+	Context("Parse build dependencies", func() {
+		parser := &SimpleEbuildParser{}
+		pkgs, err := parser.ScanEbuild("../../../../tests/fixtures/overlay/app-crypt/pinentry-base/pinentry-base-1.1.0-r2.ebuild")
+
+		It("Splits DEPEND from RDEPEND", func() {
+			Expect(err).ToNot(HaveOccurred())
+			p := pkgs[0].(*pkg.DefaultPackage)
+
+			var requires, buildRequires []string
+			for _, r := range p.GetRequires() {
+				requires = append(requires, r.GetCategory()+"/"+r.GetName())
+			}
+			for _, r := range common.GetBuildRequires(p) {
+				buildRequires = append(buildRequires, r.GetCategory()+"/"+r.GetName())
+			}
+
+			Expect(requires).To(ContainElement("dev-libs/libassuan"))
+			Expect(requires).ToNot(ContainElement("sys-devel/gettext"))
+			Expect(buildRequires).To(ContainElement("dev-libs/libassuan"))
+			Expect(buildRequires).To(ContainElement("sys-devel/gettext"))
+			Expect(buildRequires).To(ContainElement("virtual/pkgconfig"))
+			Expect(len(common.GetBuildConflicts(p))).To(Equal(0))
+		})
+	})
+
+	Context("Parse build dependencies with USE deps", func() {
+		parser := &SimpleEbuildParser{}
+		pkgs, err := parser.ScanEbuild("../../../../tests/fixtures/parser/calamares-sabayon-base-modules-1.15.ebuild")
+
+		It("Drops USE deps from build requires", func() {
+			Expect(err).ToNot(HaveOccurred())
+			p := pkgs[0].(*pkg.DefaultPackage)
+			Expect(common.GetBuildRequires(p)).To(Equal([]*pkg.DefaultPackage{
+				&pkg.DefaultPackage{Name: "calamares", Category: "app-admin"},
+			}))
+		})
+	})
+
 })
@@ -0,0 +1,439 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// DefaultEbuildHelpers are the helpers of the package manager and of
+// the common eclasses (versionator) available in the global scope of
+// the ebuilds. The USE flags are read from USE and IUSE. The functions defined
+// by the inherited eclasses take precedence over the helpers.
+var DefaultEbuildHelpers = map[string]EbuildHelper{
+	"use":        helperUse,
+	"usex":       helperUsex,
+	"use_with":   helperUseWith("with", "without"),
+	"use_enable": helperUseWith("enable", "disable"),
+	"has":        helperHas(false),
+	"hasq":       helperHas(false),
+	"hasv":       helperHas(true),
+	"ver_cut":    helperVerCut,
+	"ver_rs":     helperVerRs,
+	"ver_test":   helperVerTest,
+
+	"get_version_component_range":    helperVerCut,
+	"get_major_version":              versionatorCut("1"),
+	"get_after_major_version":        versionatorCut("2-"),
+	"get_version_component_count":    helperVersionComponentCount,
+	"get_version_components":         helperVersionComponents,
+	"replace_version_separator":      helperReplaceVersionSeparator,
+	"delete_version_separator":       helperDeleteVersionSeparator,
+	"replace_all_version_separators": helperReplaceAllVersionSeparators,
+	"delete_all_version_separators":  helperDeleteAllVersionSeparators,
+	"version_is_at_least":            helperVersionIsAtLeast,
+}
+
+// exitStatus returns the exit status of a condition.
+func exitStatus(ok bool) error {
+	if ok {
+		return nil
+	}
+	return interp.NewExitStatus(1)
+}
+
+// useEnabled returns the state of a flag, or of its negation (!flag).
+// USE holds the flags of the profile in make.conf syntax (flag, -flag,
+// -*), applied over the IUSE defaults as done by GetUseFlags.
+func useEnabled(hc interp.HandlerContext, flag string) bool {
+	negate := strings.HasPrefix(flag, "!")
+	flag = strings.TrimPrefix(flag, "!")
+	use := make(map[string]bool)
+	for _, u := range strings.Fields(hc.Env.Get("IUSE").String()) {
+		if strings.HasPrefix(u, "+") {
+			use[u[1:]] = true
+		}
+	}
+	applyUse(use, strings.Fields(hc.Env.Get("USE").String()))
+	return use[flag] != negate
+}
+
+// use <flag>
+func helperUse(hc interp.HandlerContext, args []string) error {
+	if len(args) < 2 {
+		return interp.NewExitStatus(2)
+	}
+	return exitStatus(useEnabled(hc, args[1]))
+}
+
+// usex <flag> [true1] [false1] [true2] [false2]
+func helperUsex(hc interp.HandlerContext, args []string) error {
+	if len(args) < 2 {
+		return interp.NewExitStatus(2)
+	}
+	values := []string{"yes", "no", "", ""}
+	for i := 2; i < len(args) && i-2 < len(values); i++ {
+		values[i-2] = args[i]
+	}
+	if useEnabled(hc, args[1]) {
+		fmt.Fprintln(hc.Stdout, values[0]+values[2])
+	} else {
+		fmt.Fprintln(hc.Stdout, values[1]+values[3])
+	}
+	return nil
+}
+
+// use_with and use_enable: <flag> [option] [value]
+func helperUseWith(enable, disable string) EbuildHelper {
+	return func(hc interp.HandlerContext, args []string) error {
+		if len(args) < 2 {
+			return interp.NewExitStatus(2)
+		}
+		option := strings.TrimPrefix(args[1], "!")
+		if len(args) > 2 {
+			option = args[2]
+		}
+		if !useEnabled(hc, args[1]) {
+			fmt.Fprintf(hc.Stdout, "--%s-%s\n", disable, option)
+			return nil
+		}
+		if len(args) > 3 {
+			fmt.Fprintf(hc.Stdout, "--%s-%s=%s\n", enable, option, args[3])
+		} else {
+			fmt.Fprintf(hc.Stdout, "--%s-%s\n", enable, option)
+		}
+		return nil
+	}
+}
+
+// splitVersion splits a version in the separators and the components
+// of PMS: ans[0] is the separator before the first component, then a
+// component and the separator that follows it.
+func splitVersion(v string) []string {
+	ans := []string{}
+	sep, comp := "", ""
+	kind := func(r rune) int {
+		switch {
+		case unicode.IsDigit(r):
+			return 1
+		case unicode.IsLetter(r):
+			return 2
+		}
+		return 0
+	}
+
+	last := 0
+	for _, r := range v {
+		k := kind(r)
+		switch {
+		case k == 0:
+			if comp != "" {
+				ans = append(ans, sep, comp)
+				sep, comp = "", ""
+			}
+			sep += string(r)
+		case comp != "" && k != last:
+			ans = append(ans, sep, comp)
+			sep, comp = "", string(r)
+		default:
+			comp += string(r)
+		}
+		last = k
+	}
+	if comp != "" {
+		ans = append(ans, sep, comp)
+		sep = ""
+	}
+	return append(ans, sep)
+}
+
+// parseVersionRange parses a range of components: N, N- or N-M.
+func parseVersionRange(s string, max int) (int, int, error) {
+	parts := strings.SplitN(s, "-", 2)
+	start, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range %s", s)
+	}
+	end := start
+	if len(parts) == 2 {
+		if parts[1] == "" {
+			end = max
+		} else if end, err = strconv.Atoi(parts[1]); err != nil {
+			return 0, 0, fmt.Errorf("invalid range %s", s)
+		}
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("invalid range %s", s)
+	}
+	return start, end, nil
+}
+
+// versionArg returns the version argument at index i, PV by default.
+func versionArg(hc interp.HandlerContext, args []string, i int) string {
+	if len(args) > i {
+		return args[i]
+	}
+	return hc.Env.Get("PV").String()
+}
+
+// helperError writes the error of a helper and returns its exit status.
+func helperError(hc interp.HandlerContext, name string, err error) error {
+	fmt.Fprintln(hc.Stderr, name+":", err)
+	return interp.NewExitStatus(1)
+}
+
+// has <needle> [haystack...], hasv echoes the needle when found.
+func helperHas(echo bool) EbuildHelper {
+	return func(hc interp.HandlerContext, args []string) error {
+		if len(args) < 2 {
+			return interp.NewExitStatus(1)
+		}
+		for _, s := range args[2:] {
+			if s == args[1] {
+				if echo {
+					fmt.Fprintln(hc.Stdout, s)
+				}
+				return nil
+			}
+		}
+		return exitStatus(false)
+	}
+}
+
+// cutVersion returns the components of the range with the
+// separators between them, as ver_cut.
+func cutVersion(r, v string) (string, error) {
+	parts := splitVersion(v)
+	// separators are at the even indexes, components at the odd ones
+	components := len(parts) / 2
+	start, end, err := parseVersionRange(r, components)
+	if err != nil {
+		return "", err
+	}
+	if end > components {
+		end = components
+	}
+
+	from := 0
+	if start > 0 {
+		from = 2*start - 1
+	}
+	var b strings.Builder
+	for i := from; i <= 2*end-1; i++ {
+		b.WriteString(parts[i])
+	}
+	return b.String(), nil
+}
+
+// replaceSeparators replaces the separators of the given range, the
+// missing separators before the first component and after the last
+// one are left as they are.
+func replaceSeparators(parts []string, r, repl string) error {
+	components := len(parts) / 2
+	start, end, err := parseVersionRange(r, components)
+	if err != nil {
+		return err
+	}
+	if end > components {
+		end = components
+	}
+	for i := start; i <= end; i++ {
+		if (i == 0 || i == components) && parts[2*i] == "" {
+			continue
+		}
+		parts[2*i] = repl
+	}
+	return nil
+}
+
+// ver_cut <range> [version], get_version_component_range <range> [version]
+func helperVerCut(hc interp.HandlerContext, args []string) error {
+	if len(args) < 2 {
+		return interp.NewExitStatus(2)
+	}
+	ans, err := cutVersion(args[1], versionArg(hc, args, 2))
+	if err != nil {
+		return helperError(hc, args[0], err)
+	}
+	fmt.Fprintln(hc.Stdout, ans)
+	return nil
+}
+
+// versionatorCut returns a versionator helper that cuts the given range.
+func versionatorCut(r string) EbuildHelper {
+	return func(hc interp.HandlerContext, args []string) error {
+		ans, err := cutVersion(r, versionArg(hc, args, 1))
+		if err != nil {
+			return helperError(hc, args[0], err)
+		}
+		fmt.Fprintln(hc.Stdout, ans)
+		return nil
+	}
+}
+
+// ver_rs <range> <repl> [<range> <repl>...] [version]
+func helperVerRs(hc interp.HandlerContext, args []string) error {
+	if len(args) < 3 {
+		return interp.NewExitStatus(2)
+	}
+	rules := args[1:]
+	v := hc.Env.Get("PV").String()
+	if len(rules)%2 == 1 {
+		v = rules[len(rules)-1]
+		rules = rules[:len(rules)-1]
+	}
+
+	parts := splitVersion(v)
+	for i := 0; i < len(rules); i += 2 {
+		if err := replaceSeparators(parts, rules[i], rules[i+1]); err != nil {
+			return helperError(hc, args[0], err)
+		}
+	}
+	fmt.Fprintln(hc.Stdout, strings.Join(parts, ""))
+	return nil
+}
+
+// ver_test [v1] <op> <v2>, v1 is PVR by default.
+func helperVerTest(hc interp.HandlerContext, args []string) error {
+	var a, op, b string
+	switch len(args) {
+	case 3:
+		a, op, b = hc.Env.Get("PVR").String(), args[1], args[2]
+	case 4:
+		a, op, b = args[1], args[2], args[3]
+	default:
+		return interp.NewExitStatus(2)
+	}
+
+	va, err := ParseGentooVersion(a)
+	if err != nil {
+		return helperError(hc, args[0], err)
+	}
+	vb, err := ParseGentooVersion(b)
+	if err != nil {
+		return helperError(hc, args[0], err)
+	}
+
+	c := CompareVersions(va, vb)
+	switch op {
+	case "-eq":
+		return exitStatus(c == 0)
+	case "-ne":
+		return exitStatus(c != 0)
+	case "-lt":
+		return exitStatus(c < 0)
+	case "-le":
+		return exitStatus(c <= 0)
+	case "-gt":
+		return exitStatus(c > 0)
+	case "-ge":
+		return exitStatus(c >= 0)
+	}
+	return helperError(hc, args[0], fmt.Errorf("invalid operator %s", op))
+}
+
+// version_is_at_least <want> [have]
+func helperVersionIsAtLeast(hc interp.HandlerContext, args []string) error {
+	if len(args) < 2 {
+		return interp.NewExitStatus(2)
+	}
+	want, err := ParseGentooVersion(args[1])
+	if err != nil {
+		return helperError(hc, args[0], err)
+	}
+	have, err := ParseGentooVersion(versionArg(hc, args, 2))
+	if err != nil {
+		return helperError(hc, args[0], err)
+	}
+	return exitStatus(CompareVersions(have, want) >= 0)
+}
+
+// get_version_component_count [version]
+func helperVersionComponentCount(hc interp.HandlerContext, args []string) error {
+	fmt.Fprintln(hc.Stdout, len(splitVersion(versionArg(hc, args, 1)))/2)
+	return nil
+}
+
+// get_version_components [version]
+func helperVersionComponents(hc interp.HandlerContext, args []string) error {
+	parts := splitVersion(versionArg(hc, args, 1))
+	components := []string{}
+	for i := 1; i < len(parts); i += 2 {
+		components = append(components, parts[i])
+	}
+	fmt.Fprintln(hc.Stdout, strings.Join(components, " "))
+	return nil
+}
+
+// separatorIndex returns the index of the separator of the versionator
+// helpers: a number, or the first separator equal to the given one.
+func separatorIndex(parts []string, sep string) string {
+	if _, err := strconv.Atoi(sep); err == nil {
+		return sep
+	}
+	for i := 2; i < len(parts); i += 2 {
+		if parts[i] == sep {
+			return strconv.Itoa(i / 2)
+		}
+	}
+	// No separator to replace
+	return strconv.Itoa(len(parts))
+}
+
+// replace_version_separator <index|separator> <repl> [version]
+func helperReplaceVersionSeparator(hc interp.HandlerContext, args []string) error {
+	if len(args) < 3 {
+		return interp.NewExitStatus(2)
+	}
+	parts := splitVersion(versionArg(hc, args, 3))
+	if err := replaceSeparators(parts, separatorIndex(parts, args[1]), args[2]); err != nil {
+		return helperError(hc, args[0], err)
+	}
+	fmt.Fprintln(hc.Stdout, strings.Join(parts, ""))
+	return nil
+}
+
+// delete_version_separator <index|separator> [version]
+func helperDeleteVersionSeparator(hc interp.HandlerContext, args []string) error {
+	if len(args) < 2 {
+		return interp.NewExitStatus(2)
+	}
+	return helperReplaceVersionSeparator(hc, append([]string{args[0], args[1], ""}, args[2:]...))
+}
+
+// replace_all_version_separators <repl> [version]
+func helperReplaceAllVersionSeparators(hc interp.HandlerContext, args []string) error {
+	if len(args) < 2 {
+		return interp.NewExitStatus(2)
+	}
+	parts := splitVersion(versionArg(hc, args, 2))
+	// only the separators between the components
+	for i := 2; i < len(parts)-1; i += 2 {
+		parts[i] = args[1]
+	}
+	fmt.Fprintln(hc.Stdout, strings.Join(parts, ""))
+	return nil
+}
+
+// delete_all_version_separators [version]
+func helperDeleteAllVersionSeparators(hc interp.HandlerContext, args []string) error {
+	return helperReplaceAllVersionSeparators(hc, append([]string{args[0], ""}, args[1:]...))
+}
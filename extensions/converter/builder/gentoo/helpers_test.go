@@ -0,0 +1,152 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
+	pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/mudler/luet/pkg/tree/builder/gentoo"
+)
+
+var _ = Describe("Ebuild helpers", func() {
+	var tmpdir string
+
+	// eval returns the value of X after sourcing the ebuild of
+	// app-misc/foo-1.2.3_rc4-r1 with the given statement
+	eval := func(stmt string) string {
+		gp, err := _gentoo.ParsePackageStr("app-misc/foo-1.2.3_rc4-r1")
+		Expect(err).ToNot(HaveOccurred())
+		ebuild := filepath.Join(tmpdir, "foo-1.2.3_rc4-r1.ebuild")
+		Expect(ioutil.WriteFile(ebuild, []byte("SLOT=0\n"+stmt+"\n"), 0644)).Should(BeNil())
+
+		vars, err := SourceFile(context.Background(), ebuild, gp)
+		Expect(err).ToNot(HaveOccurred())
+		return vars["X"].String()
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = ioutil.TempDir("", "helpers")
+		Expect(err).Should(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpdir)
+	})
+
+	It("Evaluates the version helpers", func() {
+		for _, c := range []struct{ stmt, expected string }{
+			{`X=$(ver_cut 1)`, "1"},
+			{`X=$(ver_cut 1-3)`, "1.2.3"},
+			{`X=$(ver_cut 4-)`, "rc4"},
+			{`X=$(ver_cut 0-1 .1.2)`, ".1"},
+			{`X=$(ver_cut 2-9 1.2)`, "2"},
+			{`X=$(ver_rs 1 -)`, "1-2.3_rc4"},
+			{`X=$(ver_rs 1- _ 1.2.3)`, "1_2_3"},
+			{`X=$(ver_rs 1 - 2 '' 1.2.3b)`, "1-23b"},
+			{`X=$(ver_rs 3 . 1.2b)`, "1.2b"},
+			{`X=$(get_version_component_range 2-3)`, "2.3"},
+			{`X=$(get_major_version 5.4.1)`, "5"},
+			{`X=$(get_after_major_version 5.4.1)`, "4.1"},
+			{`X=$(get_version_component_count 5.4.1)`, "3"},
+			{`X=$(get_version_components 5.4.1b)`, "5 4 1 b"},
+			{`X=$(replace_version_separator 2 _ 5.4.1)`, "5.4_1"},
+			{`X=$(replace_version_separator _ . 5.4_1)`, "5.4.1"},
+			{`X=$(delete_version_separator 1 5.4.1)`, "54.1"},
+			{`X=$(replace_all_version_separators - 5.4.1)`, "5-4-1"},
+			{`X=$(delete_all_version_separators 5.4.1)`, "541"},
+			{`version_is_at_least 1.2 && X=yes`, "yes"},
+			{`version_is_at_least 1.3 || X=no`, "no"},
+			{`ver_test -ge 1.2.3_rc1 && X=yes`, "yes"},
+			{`ver_test 1.2 -lt 1.10 && X=yes`, "yes"},
+			{`ver_test 1.2 -eq 1.2-r1 || X=no`, "no"},
+		} {
+			Expect(eval(c.stmt)).To(Equal(c.expected), c.stmt)
+		}
+	})
+
+	It("Evaluates has and the USE helpers", func() {
+		for _, c := range []struct{ stmt, expected string }{
+			{`has b a b c && X=yes`, "yes"},
+			{`has d a b c || X=no`, "no"},
+			{`X=$(hasv b a b c)`, "b"},
+			{`X=$(hasq b a b c)`, ""},
+			{`USE="x"; X=$(usex x "" "-no")`, ""},
+			{`X=$(usex x 1 0)`, "0"},
+			{`USE="x"; X=$(use_with !x foo)`, "--without-foo"},
+		} {
+			Expect(eval(c.stmt)).To(Equal(c.expected), c.stmt)
+		}
+	})
+
+	It("Does not inherit the environment", func() {
+		os.Setenv("USE", "x")
+		os.Setenv("LUET_HOST_VAR", "leaked")
+		defer os.Unsetenv("USE")
+		defer os.Unsetenv("LUET_HOST_VAR")
+
+		Expect(eval(`X=$(usex x 1 0)`)).To(Equal("0"))
+		Expect(eval(`X=$(use_with x foo)`)).To(Equal("--without-foo"))
+		Expect(eval(`X=${LUET_HOST_VAR}`)).To(Equal(""))
+	})
+
+	It("Evaluates the [[ ]] tests", func() {
+		for _, c := range []struct{ stmt, expected string }{
+			{`[[ ${PV} == *_rc* ]] && X=rc`, "rc"},
+			{`[[ ${PV} == 9999 ]] && X=live || X=release`, "release"},
+			{`if [[ ${PV} =~ ^[0-9]+\.[0-9]+ ]]; then X=match; fi`, "match"},
+			{`[[ -n ${PN} && ${PVR} != ${PV} ]] && X=${PVR}`, "1.2.3_rc4-r1"},
+		} {
+			Expect(eval(c.stmt)).To(Equal(c.expected), c.stmt)
+		}
+	})
+
+	It("Evaluates the globals of a live ebuild", func() {
+		ebuild := filepath.Join(tmpdir, "app-misc", "foo", "foo-9999.ebuild")
+		Expect(os.MkdirAll(filepath.Dir(ebuild), os.ModePerm)).Should(BeNil())
+		Expect(ioutil.WriteFile(ebuild, []byte(`EAPI=7
+MY_PV="$(ver_rs 1- _)"
+
+src_prepare() {
+	default
+}
+
+if [[ ${PV} == 9999 ]]; then
+	SRC_URI=""
+	SLOT="live"
+else
+	SRC_URI="https://example.com/foo-${MY_PV}.tar.gz"
+	SLOT="$(ver_cut 1)"
+fi
+RDEPEND="dev-libs/bar"
+`), 0644)).Should(BeNil())
+
+		pkgs, err := (&SimpleEbuildParser{}).ScanEbuild(ebuild)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pkgs[0].GetAnnotations()[GentooSlotAnnotation]).To(Equal("live"))
+		Expect(pkgs[0].GetRequires()).To(Equal([]*pkg.DefaultPackage{
+			{Category: "dev-libs", Name: "bar"},
+		}))
+	})
+})
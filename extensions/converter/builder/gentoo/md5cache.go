@@ -0,0 +1,169 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/mudler/luet/pkg/logger"
+
+	pkg "github.com/mudler/luet/pkg/package"
+	"mvdan.cc/sh/v3/expand"
+)
+
+// MD5CacheDir is the directory of the metadata cache in a Gentoo tree.
+const MD5CacheDir = "metadata/md5-cache"
+
+// LoadMD5Cache parses an entry of the md5-cache (KEY=value lines) and
+// returns the variables as they would be sourced from the ebuild.
+func LoadMD5Cache(file string) (map[string]expand.Variable, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	vars := make(map[string]expand.Variable)
+	scanner := bufio.NewScanner(f)
+	// the dependencies of some packages are very long
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			continue
+		}
+		vars[line[:i]] = expand.Variable{Kind: expand.String, Str: line[i+1:]}
+	}
+
+	// _eclasses_ has the name and the checksum of every eclass
+	if eclasses, ok := vars["_eclasses_"]; ok {
+		fields := strings.Fields(eclasses.String())
+		names := []string{}
+		for i := 0; i < len(fields); i += 2 {
+			names = append(names, fields[i])
+		}
+		vars["INHERITED"] = expand.Variable{Kind: expand.String, Str: strings.Join(names, " ")}
+	}
+
+	return vars, scanner.Err()
+}
+
+// MD5CacheFile returns the path of the md5-cache entry of an ebuild.
+func MD5CacheFile(ebuild string) string {
+	category := filepath.Base(filepath.Dir(filepath.Dir(ebuild)))
+	pf := strings.TrimSuffix(filepath.Base(ebuild), ".ebuild")
+	return filepath.Join(treeRoot(ebuild), MD5CacheDir, category, pf)
+}
+
+// CacheEbuildParser reads the metadata of the ebuilds from the md5-cache
+// of the tree, without sourcing them. The ebuilds without a cache entry,
+// or whose entry doesn't match the checksums of the ebuild and of its
+// eclasses, are parsed with the SimpleEbuildParser.
+type CacheEbuildParser struct {
+	*SimpleEbuildParser
+}
+
+// NewCacheEbuildParser returns a CacheEbuildParser that uses the given
+// parser for the ebuilds not available in the cache.
+func NewCacheEbuildParser(fallback *SimpleEbuildParser) *CacheEbuildParser {
+	if fallback == nil {
+		fallback = &SimpleEbuildParser{}
+	}
+	return &CacheEbuildParser{SimpleEbuildParser: fallback}
+}
+
+// ScanEbuild returns the package of an ebuild from its md5-cache entry.
+func (ep *CacheEbuildParser) ScanEbuild(path string) (pkg.Packages, error) {
+	return ep.ScanEbuildContext(context.Background(), path)
+}
+
+// ScanEbuildContext is ScanEbuild with a context, used when the ebuild
+// is sourced.
+func (ep *CacheEbuildParser) ScanEbuildContext(ctx context.Context, path string) (pkg.Packages, error) {
+	vars, err := LoadMD5Cache(MD5CacheFile(path))
+	if err != nil {
+		Debug("No cache entry for", path, "fallback to the ebuild")
+		return ep.SimpleEbuildParser.ScanEbuildContext(ctx, path)
+	}
+	if err := checkMD5Cache(path, vars, EclassDirs(path, ep.Overlays)); err != nil {
+		Debug("Stale cache entry for", path, err.Error(), "fallback to the ebuild")
+		return ep.SimpleEbuildParser.ScanEbuildContext(ctx, path)
+	}
+
+	Debug("Loaded cache entry of ebuild", path)
+	gp, err := parseEbuildPath(path)
+	if err != nil {
+		return pkg.Packages{}, err
+	}
+	return pkg.Packages{ep.newPackage(gp, path, vars)}, nil
+}
+
+// md5File returns the md5 checksum of a file, as in the md5-cache.
+func md5File(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checkMD5Cache returns an error if the md5-cache entry of the ebuild is
+// stale: _md5_ doesn't match the ebuild or _eclasses_ doesn't match
+// the eclasses found in the given directories.
+func checkMD5Cache(ebuild string, vars map[string]expand.Variable, eclassDirs []string) error {
+	sum, err := md5File(ebuild)
+	if err != nil {
+		return err
+	}
+	if sum != vars["_md5_"].String() {
+		return fmt.Errorf("checksum mismatch for %s", ebuild)
+	}
+
+	fields := strings.Fields(vars["_eclasses_"].String())
+	if len(fields)%2 != 0 {
+		return fmt.Errorf("invalid _eclasses_ entry")
+	}
+	for i := 0; i < len(fields); i += 2 {
+		file, err := FindEclass(fields[i], eclassDirs)
+		if err != nil {
+			return err
+		}
+		sum, err := md5File(file)
+		if err != nil {
+			return err
+		}
+		if sum != fields[i+1] {
+			return fmt.Errorf("checksum mismatch for eclass %s", fields[i])
+		}
+	}
+
+	return nil
+}
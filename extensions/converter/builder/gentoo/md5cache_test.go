@@ -0,0 +1,178 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo_test
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mudler/luet/pkg/tree/builder/common"
+	. "github.com/mudler/luet/pkg/tree/builder/gentoo"
+)
+
+var _ = Describe("Cache parser", func() {
+
+	Context("md5-cache and metadata.xml", func() {
+		var tmpdir, tree string
+
+		write := func(file, content string) {
+			Expect(os.MkdirAll(filepath.Dir(file), os.ModePerm)).Should(BeNil())
+			Expect(ioutil.WriteFile(file, []byte(content), 0644)).Should(BeNil())
+		}
+
+		md5sum := func(file string) string {
+			data, err := ioutil.ReadFile(file)
+			Expect(err).Should(BeNil())
+			return fmt.Sprintf("%x", md5.Sum(data))
+		}
+
+		BeforeEach(func() {
+			var err error
+			tmpdir, err = ioutil.TempDir("", "md5cache")
+			Expect(err).Should(BeNil())
+			tree = filepath.Join(tmpdir, "tree")
+
+			write(filepath.Join(tree, EclassDir, "python-r1.eclass"), `IUSE="python_targets_python3_9"
+`)
+			write(filepath.Join(tree, "app-misc", "foo", "foo-1.0.ebuild"), `EAPI=7
+inherit python-r1
+IUSE="doc"
+SLOT="0"
+`)
+			write(filepath.Join(tree, "app-misc", "foo", "foo-2.0.ebuild"), `EAPI=7
+IUSE="doc"
+SLOT="0"
+RDEPEND="app-misc/bar"
+`)
+			write(filepath.Join(tree, MD5CacheDir, "app-misc", "foo-1.0"), `DEFINED_PHASES=compile install
+DEPEND=dev-lang/python:3.9
+DESCRIPTION=Foo from the cache
+EAPI=7
+IUSE=doc python_targets_python3_9
+KEYWORDS=~amd64
+LICENSE=MIT
+RDEPEND=python_targets_python3_9? ( dev-lang/python:3.9 ) app-misc/bar
+SLOT=0
+SRC_URI=https://example.org/foo-1.0.tar.gz
+_eclasses_=python-r1	`+md5sum(filepath.Join(tree, EclassDir, "python-r1.eclass"))+`
+_md5_=`+md5sum(filepath.Join(tree, "app-misc", "foo", "foo-1.0.ebuild"))+`
+`)
+			write(filepath.Join(tree, "app-misc", "foo", PackageMetadataFile), `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE pkgmetadata SYSTEM "http://www.gentoo.org/dtd/metadata.dtd">
+<pkgmetadata>
+	<maintainer type="person">
+		<email>foo@gentoo.org</email>
+		<name>Foo</name>
+	</maintainer>
+	<maintainer type="project">
+		<email>python@gentoo.org</email>
+	</maintainer>
+	<longdescription lang="en">
+		A very long
+		description.
+	</longdescription>
+	<use>
+		<flag name="doc">Build the docs with <pkg>dev-python/sphinx</pkg> &amp; more</flag>
+	</use>
+	<upstream>
+		<remote-id type="github">foo/foo</remote-id>
+	</upstream>
+</pkgmetadata>
+`)
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tmpdir)
+		})
+
+		It("Reads the cache entry", func() {
+			parser := NewCacheEbuildParser(nil)
+			pkgs, err := parser.ScanEbuild(filepath.Join(tree, "app-misc", "foo", "foo-1.0.ebuild"))
+			Expect(err).Should(BeNil())
+			Expect(len(pkgs)).Should(Equal(1))
+
+			p := pkgs[0]
+			Expect(p.GetDescription()).Should(Equal("Foo from the cache"))
+			Expect(p.GetLicense()).Should(Equal("MIT"))
+			Expect(p.GetUses()).Should(ConsistOf("doc", "python_targets_python3_9"))
+			Expect(p.GetURI()).Should(Equal([]string{"https://example.org/foo-1.0.tar.gz"}))
+			Expect(len(p.GetRequires())).Should(Equal(2))
+			Expect(common.GetBuildRequires(p)[0].GetName()).Should(Equal("python"))
+		})
+
+		It("Falls back to the ebuild", func() {
+			parser := NewCacheEbuildParser(&SimpleEbuildParser{})
+			pkgs, err := parser.ScanEbuild(filepath.Join(tree, "app-misc", "foo", "foo-2.0.ebuild"))
+			Expect(err).Should(BeNil())
+			Expect(len(pkgs)).Should(Equal(1))
+			Expect(pkgs[0].GetUses()).Should(Equal([]string{"doc"}))
+			Expect(len(pkgs[0].GetRequires())).Should(Equal(1))
+		})
+
+		It("Falls back to the ebuild when the cache entry is stale", func() {
+			parser := NewCacheEbuildParser(&SimpleEbuildParser{})
+			ebuild := filepath.Join(tree, "app-misc", "foo", "foo-1.0.ebuild")
+			eclass := filepath.Join(tree, EclassDir, "python-r1.eclass")
+
+			// The ebuild is changed
+			write(ebuild, "EAPI=7\ninherit python-r1\nIUSE=\"doc\"\nSLOT=\"0\"\nDESCRIPTION=\"Foo\"\n")
+			pkgs, err := parser.ScanEbuild(ebuild)
+			Expect(err).Should(BeNil())
+			Expect(pkgs[0].GetDescription()).Should(Equal("Foo"))
+
+			// The eclass is changed
+			write(ebuild, "EAPI=7\ninherit python-r1\nIUSE=\"doc\"\nSLOT=\"0\"\n")
+			pkgs, err = parser.ScanEbuild(ebuild)
+			Expect(err).Should(BeNil())
+			Expect(pkgs[0].GetDescription()).Should(Equal("Foo from the cache"))
+
+			write(eclass, "IUSE=\"python_targets_python3_10\"\n")
+			pkgs, err = parser.ScanEbuild(ebuild)
+			Expect(err).Should(BeNil())
+			Expect(pkgs[0].GetDescription()).Should(Equal(""))
+			Expect(pkgs[0].GetUses()).Should(ConsistOf("doc", "python_targets_python3_10"))
+		})
+
+		It("Adds the metadata.xml labels", func() {
+			parser := NewCacheEbuildParser(nil)
+			for _, v := range []string{"1.0", "2.0"} {
+				pkgs, err := parser.ScanEbuild(filepath.Join(tree, "app-misc", "foo", "foo-"+v+".ebuild"))
+				Expect(err).Should(BeNil())
+				Expect(pkgs[0].GetLabels()).Should(Equal(map[string]string{
+					MaintainersLabel:               "foo@gentoo.org,python@gentoo.org",
+					LongDescriptionLabel:           "A very long description.",
+					UpstreamLabelPrefix + "github": "foo/foo",
+					UseLabelPrefix + "doc":         "Build the docs with dev-python/sphinx & more",
+				}))
+			}
+		})
+
+		It("Adds the metadata.xml labels with every parser", func() {
+			ebuild := filepath.Join(tree, "app-misc", "foo", "foo-2.0.ebuild")
+			for _, parser := range []EbuildParser{&SimpleEbuildParser{}, NewVariantEbuildParser(nil)} {
+				pkgs, err := parser.ScanEbuild(ebuild)
+				Expect(err).Should(BeNil())
+				Expect(pkgs[0].GetLabels()[MaintainersLabel]).Should(Equal("foo@gentoo.org,python@gentoo.org"))
+			}
+		})
+	})
+})
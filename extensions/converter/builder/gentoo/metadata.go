@@ -0,0 +1,137 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo
+
+import (
+	"encoding/xml"
+	"html"
+	"io/ioutil"
+	"strings"
+
+	pkg "github.com/mudler/luet/pkg/package"
+)
+
+const (
+	// PackageMetadataFile is the metadata file of a package directory.
+	PackageMetadataFile = "metadata.xml"
+
+	// MaintainersLabel is the label with the emails of the maintainers.
+	MaintainersLabel = "maintainers"
+	// LongDescriptionLabel is the label with the long description.
+	LongDescriptionLabel = "long_description"
+	// UpstreamLabelPrefix is the prefix of the labels with the remote ids
+	// of the package, e.g. upstream.github.
+	UpstreamLabelPrefix = "upstream."
+	// UseLabelPrefix is the prefix of the labels with the description
+	// of the USE flags, e.g. use.doc.
+	UseLabelPrefix = "use."
+)
+
+// PackageMetadata is the content of the metadata.xml of a package.
+type PackageMetadata struct {
+	XMLName     xml.Name `xml:"pkgmetadata"`
+	Maintainers []struct {
+		Type  string `xml:"type,attr"`
+		Email string `xml:"email"`
+		Name  string `xml:"name"`
+	} `xml:"maintainer"`
+	LongDescription []struct {
+		Lang  string `xml:"lang,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"longdescription"`
+	Upstream struct {
+		RemoteIds []struct {
+			Type  string `xml:"type,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"remote-id"`
+	} `xml:"upstream"`
+	Use []struct {
+		Lang  string `xml:"lang,attr"`
+		Flags []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:",innerxml"`
+		} `xml:"flag"`
+	} `xml:"use"`
+}
+
+// LoadPackageMetadata parses a metadata.xml file.
+func LoadPackageMetadata(file string) (*PackageMetadata, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	ans := &PackageMetadata{}
+	if err := xml.Unmarshal(data, ans); err != nil {
+		return nil, err
+	}
+	return ans, nil
+}
+
+// normalizeText strips the markup and the indentation of a description.
+func normalizeText(s string) string {
+	var b strings.Builder
+	tag := false
+	for _, c := range s {
+		switch {
+		case c == '<':
+			tag = true
+		case c == '>':
+			tag = false
+		case !tag:
+			b.WriteRune(c)
+		}
+	}
+	return html.UnescapeString(strings.Join(strings.Fields(b.String()), " "))
+}
+
+// isEnglish returns true for the english (default) descriptions.
+func isEnglish(lang string) bool {
+	return lang == "" || lang == "en"
+}
+
+// AddLabels adds the fields of the metadata as labels of the package.
+func (m *PackageMetadata) AddLabels(p *pkg.DefaultPackage) {
+	emails := []string{}
+	for _, maintainer := range m.Maintainers {
+		if maintainer.Email != "" {
+			emails = append(emails, strings.TrimSpace(maintainer.Email))
+		}
+	}
+	if len(emails) > 0 {
+		p.AddLabel(MaintainersLabel, strings.Join(emails, ","))
+	}
+
+	for _, d := range m.LongDescription {
+		if isEnglish(d.Lang) {
+			p.AddLabel(LongDescriptionLabel, normalizeText(d.Value))
+			break
+		}
+	}
+
+	for _, r := range m.Upstream.RemoteIds {
+		p.AddLabel(UpstreamLabelPrefix+r.Type, strings.TrimSpace(r.Value))
+	}
+
+	for _, use := range m.Use {
+		if !isEnglish(use.Lang) {
+			continue
+		}
+		for _, f := range use.Flags {
+			p.AddLabel(UseLabelPrefix+f.Name, normalizeText(f.Value))
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo
+
+import (
+	"io/ioutil"
+	"strings"
+
+	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
+	"gopkg.in/yaml.v2"
+)
+
+// ConversionProfile defines the USE flags used to evaluate the
+// use conditionals of the dependencies on conversion.
+//
+//	use:
+//	- "-X"
+//	- "ssl"
+//	package_use:
+//	- "app-crypt/pinentry gtk -qt5"
+//	- ">=dev-lang/python-3.8 sqlite"
+//	variants:
+//	- atom: "dev-python/*"
+//	  use_expand: PYTHON_TARGETS
+//	providers:
+//	  virtual/jdk: dev-java/openjdk-bin
+type ConversionProfile struct {
+	// Use is the global USE: "flag" enables and "-flag" disables a flag,
+	// "-*" disables all the flags, IUSE defaults included.
+	Use []string `yaml:"use,omitempty" json:"use,omitempty"`
+	// PackageUse contains package.use style lines: an atom followed by flags.
+	PackageUse []string `yaml:"package_use,omitempty" json:"package_use,omitempty"`
+	// Variants are the rules used by the VariantEbuildParser.
+	Variants []VariantRule `yaml:"variants,omitempty" json:"variants,omitempty"`
+	// Providers maps the virtuals to their preferred provider, used
+	// by the VirtualResolver.
+	Providers map[string]string `yaml:"providers,omitempty" json:"providers,omitempty"`
+}
+
+// NewConversionProfile returns an empty profile, where only the IUSE
+// defaults are enabled.
+func NewConversionProfile() *ConversionProfile {
+	return &ConversionProfile{
+		Use:        []string{},
+		PackageUse: []string{},
+	}
+}
+
+// LoadConversionProfile reads a conversion profile from a YAML file.
+func LoadConversionProfile(path string) (*ConversionProfile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ans := NewConversionProfile()
+	if err := yaml.Unmarshal(data, ans); err != nil {
+		return nil, err
+	}
+
+	return ans, nil
+}
+
+// GetUseFlags returns the state of the USE flags of the package.
+// The flags of IUSE are evaluated in order: IUSE defaults (+flag),
+// global USE and then the matching package_use lines.
+func (p *ConversionProfile) GetUseFlags(gp *_gentoo.GentooPackage, iuse []string) map[string]bool {
+	ans := make(map[string]bool)
+
+	for _, u := range iuse {
+		switch {
+		case strings.HasPrefix(u, "+"):
+			ans[u[1:]] = true
+		case strings.HasPrefix(u, "-"):
+			ans[u[1:]] = false
+		default:
+			ans[u] = false
+		}
+	}
+
+	applyUse(ans, p.GetPackageUse(gp))
+
+	return ans
+}
+
+// GetPackageUse returns the flags of the profile for the package, in the
+// order they are applied: global USE and then the matching package_use lines.
+func (p *ConversionProfile) GetPackageUse(gp *_gentoo.GentooPackage) []string {
+	ans := append([]string{}, p.Use...)
+	for _, line := range p.PackageUse {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if MatchAtom(fields[0], gp) {
+			ans = append(ans, fields[1:]...)
+		}
+	}
+	return ans
+}
+
+// applyUse enables (flag) or disables (-flag) the flags,
+// -* disables all of them.
+func applyUse(use map[string]bool, flags []string) {
+	for _, f := range flags {
+		switch {
+		case f == "-*":
+			for k := range use {
+				use[k] = false
+			}
+		case strings.HasPrefix(f, "-"):
+			use[f[1:]] = false
+		default:
+			use[strings.TrimPrefix(f, "+")] = true
+		}
+	}
+}
+
+// ProfileAwareParser is implemented by the EbuildParser that
+// evaluate the use conditionals with a ConversionProfile.
+type ProfileAwareParser interface {
+	SetProfile(*ConversionProfile)
+}
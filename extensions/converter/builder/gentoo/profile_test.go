@@ -0,0 +1,188 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
+	pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/mudler/luet/pkg/tree/builder/gentoo"
+)
+
+var _ = Describe("ConversionProfile", func() {
+
+	gp := &_gentoo.GentooPackage{
+		Category: "app-crypt",
+		Name:     "pinentry",
+		Version:  "1.0.0",
+		Slot:     "0",
+	}
+	iuse := []string{"caps", "+gtk", "-qt5", "ncurses"}
+
+	Context("USE flags evaluation", func() {
+		It("Enables the IUSE defaults", func() {
+			p := NewConversionProfile()
+			Expect(p.GetUseFlags(gp, iuse)).To(Equal(map[string]bool{
+				"caps": false, "gtk": true, "qt5": false, "ncurses": false,
+			}))
+		})
+
+		It("Applies global USE and package.use in order", func() {
+			p := &ConversionProfile{
+				Use: []string{"-*", "caps", "qt5"},
+				PackageUse: []string{
+					"app-crypt/pinentry -qt5 ncurses",
+					"# comment",
+					">=app-crypt/pinentry-2.0 gtk",
+					"app-crypt/* -caps",
+				},
+			}
+			Expect(p.GetUseFlags(gp, iuse)).To(Equal(map[string]bool{
+				"caps": false, "gtk": false, "qt5": false, "ncurses": true,
+			}))
+		})
+	})
+
+	Context("Atoms", func() {
+		It("Matches names, versions and globs", func() {
+			Expect(MatchAtom("app-crypt/pinentry", gp)).To(BeTrue())
+			Expect(MatchAtom(">=app-crypt/pinentry-1.0", gp)).To(BeTrue())
+			Expect(MatchAtom("<app-crypt/pinentry-1.0", gp)).To(BeFalse())
+			Expect(MatchAtom("app-crypt/*", gp)).To(BeTrue())
+			Expect(MatchAtom("*/pinentry", gp)).To(BeTrue())
+			Expect(MatchAtom("app-crypt/pinentry-base", gp)).To(BeFalse())
+			Expect(MatchAtom("dev-libs/*", gp)).To(BeFalse())
+		})
+	})
+
+	Context("Use conditionals filtering", func() {
+		gr, err := ParseRDEPEND(`
+	app-crypt/pinentry-base
+	gtk? ( x11-libs/gtk+:2 !qt5? ( x11-libs/libX11 ) )
+	qt5? ( dev-qt/qtgui:5 )
+	!caps? ( sys-libs/libcap )
+`)
+
+		It("Drops the disabled conditionals", func() {
+			Expect(err).ToNot(HaveOccurred())
+			filtered := gr.FilterUse(map[string]bool{"gtk": true})
+
+			var names []string
+			for _, d := range filtered.GetDependencies() {
+				names = append(names, d.Dep.Name)
+			}
+			Expect(names).To(ConsistOf("pinentry-base", "gtk+", "libX11", "libcap"))
+			// The original tree is untouched
+			Expect(len(gr.GetDependencies())).To(Equal(5))
+		})
+	})
+
+	Context("Parse ebuild with a profile", func() {
+		ebuild := "../../../../tests/fixtures/overlay/app-crypt/pinentry/pinentry-1.0.0-r2.ebuild"
+
+		It("Emits all the conditionals without a profile", func() {
+			parser := &SimpleEbuildParser{}
+			pkgs, err := parser.ScanEbuild(ebuild)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(pkgs[0].GetRequires())).To(Equal(4))
+		})
+
+		It("Emits only the enabled conditionals", func() {
+			parser := &SimpleEbuildParser{Profile: &ConversionProfile{Use: []string{"gtk"}}}
+			pkgs, err := parser.ScanEbuild(ebuild)
+			Expect(err).ToNot(HaveOccurred())
+
+			var names []string
+			for _, r := range pkgs[0].GetRequires() {
+				names = append(names, r.GetName())
+			}
+			Expect(names).To(ConsistOf("pinentry-base", "pinentry-gtk2"))
+		})
+
+		It("Exports the USE flags of the profile to the ebuild", func() {
+			tmpdir, err := ioutil.TempDir("", "profile")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir)
+
+			ebuild := filepath.Join(tmpdir, "app-misc", "foo", "foo-1.0.ebuild")
+			Expect(os.MkdirAll(filepath.Dir(ebuild), os.ModePerm)).ToNot(HaveOccurred())
+			Expect(ioutil.WriteFile(ebuild, []byte(`EAPI=7
+SLOT=0
+IUSE="+gtk qt5 caps ssl"
+RDEPEND="app-misc/base"
+use gtk && RDEPEND+=" x11-libs/gtk+"
+use qt5 && RDEPEND+=" dev-qt/qtgui"
+use caps || RDEPEND+=" sys-libs/libcap"
+use_with ssl > /dev/null && [[ $(use_with ssl) == --without-ssl ]] && RDEPEND+=" app-misc/without-ssl"
+`), 0644)).ToNot(HaveOccurred())
+
+			parser := &SimpleEbuildParser{Profile: &ConversionProfile{
+				Use:        []string{"qt5"},
+				PackageUse: []string{"app-misc/foo caps"},
+			}}
+			pkgs, err := parser.ScanEbuild(ebuild)
+			Expect(err).ToNot(HaveOccurred())
+
+			var names []string
+			for _, r := range pkgs[0].GetRequires() {
+				names = append(names, r.GetName())
+			}
+			Expect(names).To(ConsistOf("base", "gtk+", "qtgui", "without-ssl"))
+		})
+
+		It("Loads the profile from file and passes it through the builder", func() {
+			tmpdir, err := ioutil.TempDir("", "profile")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(tmpdir)
+
+			profileFile := filepath.Join(tmpdir, "profile.yaml")
+			Expect(ioutil.WriteFile(profileFile, []byte(`
+package_use:
+- "app-crypt/pinentry qt5"
+`), 0644)).ToNot(HaveOccurred())
+
+			profile, err := LoadConversionProfile(profileFile)
+			Expect(err).ToNot(HaveOccurred())
+
+			gb := NewGentooBuilder(&SimpleEbuildParser{}, 2, InMemory)
+			gb.Profile = profile
+			tree, err := gb.Generate("../../../../tests/fixtures/overlay")
+			Expect(err).ToNot(HaveOccurred())
+			defer func() {
+				Expect(tree.Clean()).ToNot(HaveOccurred())
+			}()
+
+			p, err := tree.FindPackage(&pkg.DefaultPackage{
+				Name:     "pinentry",
+				Category: "app-crypt",
+				Version:  luetVersion("1.0.0-r2"),
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			var names []string
+			for _, r := range p.GetRequires() {
+				names = append(names, r.GetName())
+			}
+			Expect(names).To(ConsistOf("pinentry-base", "pinentry-qt5"))
+		})
+	})
+})
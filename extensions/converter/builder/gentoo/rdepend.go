@@ -0,0 +1,493 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
+)
+
+// GentooDependencyGroup is the kind of a group of dependencies.
+type GentooDependencyGroup int
+
+const (
+	// GroupAllOf is a plain group ( ... ) or a use conditional use? ( ... )
+	GroupAllOf GentooDependencyGroup = iota
+	// GroupAnyOf is an any-of group || ( ... )
+	GroupAnyOf
+	// GroupAtMostOneOf is an at-most-one-of group ?? ( ... )
+	GroupAtMostOneOf
+	// GroupExactlyOneOf is an exactly-one-of group ^^ ( ... )
+	GroupExactlyOneOf
+)
+
+// GentooBlocker is the kind of blocker of a dependency atom.
+type GentooBlocker int
+
+const (
+	BlockerNone GentooBlocker = iota
+	// BlockerWeak is a !cat/pkg blocker
+	BlockerWeak
+	// BlockerStrong is a !!cat/pkg blocker
+	BlockerStrong
+)
+
+// GentooDependency is a node of the dependency tree: an atom (Dep),
+// a use conditional (Use) or a group of SubDeps.
+type GentooDependency struct {
+	Use          string
+	UseCondition _gentoo.PackageCond
+	SubDeps      []*GentooDependency
+	Dep          *_gentoo.GentooPackage
+	// Group is the kind of group for nodes without Dep.
+	Group GentooDependencyGroup
+	// Blocker is set for blocker atoms.
+	Blocker GentooBlocker
+}
+
+// GentooRDEPEND is the tree of a dependency variable (DEPEND, RDEPEND, ...)
+type GentooRDEPEND struct {
+	Dependencies []*GentooDependency
+	// Invalid are the atoms skipped because not valid,
+	// e.g. foo-${PV} with PV unset.
+	Invalid []string
+}
+
+func NewGentooDependency(pkg, use string) (*GentooDependency, error) {
+	var err error
+	ans := &GentooDependency{
+		Use:     use,
+		SubDeps: make([]*GentooDependency, 0),
+	}
+
+	if strings.HasPrefix(use, "!") {
+		ans.Use = ans.Use[1:]
+		ans.UseCondition = _gentoo.PkgCondNot
+	}
+
+	if pkg != "" {
+		if strings.HasPrefix(pkg, "!!") {
+			ans.Blocker = BlockerStrong
+			pkg = pkg[2:]
+		} else if strings.HasPrefix(pkg, "!") {
+			ans.Blocker = BlockerWeak
+			pkg = pkg[1:]
+		}
+
+		// USE deps are parsed here because pkgs-checker doesn't
+		// support defaults (foo(+)) and conditionals (foo?, foo=)
+		var useDeps []string
+		if i := strings.Index(pkg, "["); i > 0 && strings.HasSuffix(pkg, "]") {
+			useDeps = strings.Split(pkg[i+1:len(pkg)-1], ",")
+			pkg = pkg[:i]
+		}
+
+		ans.Dep, err = _gentoo.ParsePackageStr(pkg)
+		if err != nil {
+			return nil, err
+		}
+		if len(useDeps) > 0 {
+			ans.Dep.UseFlags = useDeps
+		}
+
+		// An empty version, e.g. foo-${PV} with PV unset
+		if strings.HasSuffix(ans.Dep.Name, "-") {
+			return nil, fmt.Errorf("invalid atom %s: missing version", pkg)
+		}
+
+	}
+
+	return ans, nil
+}
+
+// NewGentooDependencyGroup returns an empty group of the given kind.
+func NewGentooDependencyGroup(group GentooDependencyGroup) *GentooDependency {
+	return &GentooDependency{
+		SubDeps: make([]*GentooDependency, 0),
+		Group:   group,
+	}
+}
+
+// NewGentooAnyOfDependency returns an empty any-of group.
+func NewGentooAnyOfDependency() *GentooDependency {
+	return NewGentooDependencyGroup(GroupAnyOf)
+}
+
+func (g GentooDependencyGroup) String() string {
+	switch g {
+	case GroupAnyOf:
+		return "||"
+	case GroupAtMostOneOf:
+		return "??"
+	case GroupExactlyOneOf:
+		return "^^"
+	}
+	return ""
+}
+
+func (d *GentooDependency) String() string {
+	if d.Dep != nil {
+		return fmt.Sprintf("%s%s", strings.Repeat("!", int(d.Blocker)), d.Dep)
+	} else if d.Group != GroupAllOf {
+		return fmt.Sprintf("%s %s", d.Group, d.SubDeps)
+	} else {
+		return fmt.Sprintf("%s %d %s", d.Use, d.UseCondition, d.SubDeps)
+	}
+}
+
+// IsBlocker returns true if the dependency is a weak or strong blocker.
+func (d *GentooDependency) IsBlocker() bool {
+	return d.Blocker != BlockerNone
+}
+
+// IsChoice returns true for the groups where only some of the
+// SubDeps are selected (||, ?? and ^^).
+func (d *GentooDependency) IsChoice() bool {
+	return d.Dep == nil && d.Group != GroupAllOf
+}
+
+// GetSlot returns the slot of the dependency atom, without sub-slot and
+// slot operator.
+func (d *GentooDependency) GetSlot() string {
+	slot, _, _ := d.splitSlot()
+	return slot
+}
+
+// GetSubSlot returns the sub-slot of the dependency atom (:N/M).
+func (d *GentooDependency) GetSubSlot() string {
+	_, subslot, _ := d.splitSlot()
+	return subslot
+}
+
+// GetSlotOperator returns the slot operator (= or *) of the dependency atom.
+func (d *GentooDependency) GetSlotOperator() string {
+	_, _, op := d.splitSlot()
+	return op
+}
+
+func (d *GentooDependency) splitSlot() (slot, subslot, op string) {
+	if d.Dep == nil {
+		return
+	}
+	slot = d.Dep.Slot
+	if strings.HasSuffix(slot, "=") || strings.HasSuffix(slot, "*") {
+		op = slot[len(slot)-1:]
+		slot = slot[:len(slot)-1]
+	}
+	if i := strings.Index(slot, "/"); i >= 0 {
+		subslot = slot[i+1:]
+		slot = slot[:i]
+	}
+	return
+}
+
+// GetDepsList returns the flattened list of the dependencies.
+// The alternatives of the choice groups are not included, see GetAnyOfList.
+func (d *GentooDependency) GetDepsList() []*GentooDependency {
+	ans := make([]*GentooDependency, 0)
+
+	if d.IsChoice() {
+		return ans
+	}
+
+	if len(d.SubDeps) > 0 {
+		for _, d2 := range d.SubDeps {
+			list := d2.GetDepsList()
+			ans = append(ans, list...)
+		}
+	}
+
+	if d.Dep != nil {
+		ans = append(ans, d)
+	}
+
+	return ans
+}
+
+// GetAnyOfList returns the groups requiring one of their alternatives
+// (|| and ^^) reachable without crossing another choice group.
+func (d *GentooDependency) GetAnyOfList() []*GentooDependency {
+	ans := make([]*GentooDependency, 0)
+
+	switch {
+	case d.Group == GroupAnyOf || d.Group == GroupExactlyOneOf:
+		return append(ans, d)
+	case d.IsChoice():
+		// ?? ( ... ) doesn't require anything
+		return ans
+	}
+
+	for _, d2 := range d.SubDeps {
+		ans = append(ans, d2.GetAnyOfList()...)
+	}
+
+	return ans
+}
+
+func (d *GentooDependency) AddSubDependency(pkg, use string) (*GentooDependency, error) {
+	ans, err := NewGentooDependency(pkg, use)
+	if err != nil {
+		return nil, err
+	}
+
+	d.SubDeps = append(d.SubDeps, ans)
+
+	return ans, nil
+}
+
+func (r *GentooRDEPEND) GetDependencies() []*GentooDependency {
+	ans := make([]*GentooDependency, 0)
+
+	// the same dependency could be available in multiple use flags.
+	// It's needed avoid duplicate, keeping the order of the variable.
+	seen := make(map[string]bool, 0)
+
+	for _, d := range r.Dependencies {
+		for _, p := range d.GetDepsList() {
+			if !seen[p.String()] {
+				seen[p.String()] = true
+				ans = append(ans, p)
+			}
+		}
+	}
+
+	return ans
+}
+
+// GetAnyOfDependencies returns the top level any-of groups,
+// including the ones nested in use conditionals.
+func (r *GentooRDEPEND) GetAnyOfDependencies() []*GentooDependency {
+	ans := make([]*GentooDependency, 0)
+
+	for _, d := range r.Dependencies {
+		ans = append(ans, d.GetAnyOfList()...)
+	}
+
+	return ans
+}
+
+// FilterUse returns a copy of the dependency without the use conditionals
+// that are false with the given USE flags. It returns nil if the
+// dependency itself is a false use conditional.
+func (d *GentooDependency) FilterUse(use map[string]bool) *GentooDependency {
+	if d.Use != "" {
+		enabled := use[d.Use]
+		if d.UseCondition == _gentoo.PkgCondNot {
+			enabled = !enabled
+		}
+		if !enabled {
+			return nil
+		}
+	}
+
+	ans := *d
+	ans.SubDeps = make([]*GentooDependency, 0, len(d.SubDeps))
+	for _, d2 := range d.SubDeps {
+		if f := d2.FilterUse(use); f != nil {
+			ans.SubDeps = append(ans.SubDeps, f)
+		}
+	}
+
+	return &ans
+}
+
+// FilterUse returns a copy of the tree with only the use conditionals
+// that are true with the given USE flags.
+func (r *GentooRDEPEND) FilterUse(use map[string]bool) *GentooRDEPEND {
+	ans := &GentooRDEPEND{
+		Dependencies: make([]*GentooDependency, 0, len(r.Dependencies)),
+		Invalid:      r.Invalid,
+	}
+
+	for _, d := range r.Dependencies {
+		if f := d.FilterUse(use); f != nil {
+			ans.Dependencies = append(ans.Dependencies, f)
+		}
+	}
+
+	return ans
+}
+
+type depTokenType int
+
+const (
+	tokenAtom depTokenType = iota
+	tokenUse
+	tokenGroup
+	tokenOpen
+	tokenClose
+)
+
+type depToken struct {
+	Type  depTokenType
+	Value string
+}
+
+// tokenizeDepend splits a dependency string in tokens. Parenthesis are
+// always tokens on their own, unless they are part of USE deps (foo(+)).
+func tokenizeDepend(s string) []depToken {
+	ans := []depToken{}
+	var current strings.Builder
+	brackets := 0
+
+	flush := func() {
+		if current.Len() == 0 {
+			return
+		}
+		v := current.String()
+		current.Reset()
+
+		switch {
+		case v == "||" || v == "??" || v == "^^":
+			ans = append(ans, depToken{Type: tokenGroup, Value: v})
+		case strings.HasSuffix(v, "?"):
+			ans = append(ans, depToken{Type: tokenUse, Value: v[:len(v)-1]})
+		default:
+			ans = append(ans, depToken{Type: tokenAtom, Value: v})
+		}
+	}
+
+	for _, c := range s {
+		switch {
+		case c == '[':
+			brackets++
+			current.WriteRune(c)
+		case c == ']':
+			if brackets > 0 {
+				brackets--
+			}
+			current.WriteRune(c)
+		case brackets > 0:
+			current.WriteRune(c)
+		case unicode.IsSpace(c):
+			flush()
+		case c == '(':
+			flush()
+			ans = append(ans, depToken{Type: tokenOpen, Value: "("})
+		case c == ')':
+			flush()
+			ans = append(ans, depToken{Type: tokenClose, Value: ")"})
+		default:
+			current.WriteRune(c)
+		}
+	}
+	flush()
+
+	return ans
+}
+
+type dependParser struct {
+	tokens  []depToken
+	pos     int
+	invalid []string
+}
+
+func (p *dependParser) next() (depToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return depToken{}, false
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, true
+}
+
+func (p *dependParser) expectOpen(after string) error {
+	t, ok := p.next()
+	if !ok || t.Type != tokenOpen {
+		return fmt.Errorf("expected ( after %s", after)
+	}
+	return nil
+}
+
+// parseList parses the dependencies until the end of the string (nested is
+// false) or the closing parenthesis of the current group (nested is true).
+func (p *dependParser) parseList(nested bool) ([]*GentooDependency, error) {
+	ans := make([]*GentooDependency, 0)
+
+	for {
+		t, ok := p.next()
+		if !ok {
+			if nested {
+				return nil, fmt.Errorf("missing )")
+			}
+			return ans, nil
+		}
+
+		var dep *GentooDependency
+		switch t.Type {
+		case tokenClose:
+			if !nested {
+				return nil, fmt.Errorf("unexpected ) at token %d", p.pos)
+			}
+			return ans, nil
+
+		case tokenOpen:
+			dep = NewGentooDependencyGroup(GroupAllOf)
+
+		case tokenGroup:
+			if err := p.expectOpen(t.Value); err != nil {
+				return nil, err
+			}
+			switch t.Value {
+			case "||":
+				dep = NewGentooDependencyGroup(GroupAnyOf)
+			case "??":
+				dep = NewGentooDependencyGroup(GroupAtMostOneOf)
+			default:
+				dep = NewGentooDependencyGroup(GroupExactlyOneOf)
+			}
+
+		case tokenUse:
+			if err := p.expectOpen(t.Value + "?"); err != nil {
+				return nil, err
+			}
+			dep, _ = NewGentooDependency("", t.Value)
+
+		default:
+			var err error
+			dep, err = NewGentooDependency(t.Value, "")
+			if err != nil {
+				p.invalid = append(p.invalid, t.Value)
+				continue
+			}
+			ans = append(ans, dep)
+			continue
+		}
+
+		subdeps, err := p.parseList(true)
+		if err != nil {
+			return nil, err
+		}
+		dep.SubDeps = subdeps
+		ans = append(ans, dep)
+	}
+}
+
+// ParseRDEPEND parses a dependency string (DEPEND, RDEPEND, BDEPEND,
+// PDEPEND) in the tree of the dependencies.
+func ParseRDEPEND(rdepend string) (*GentooRDEPEND, error) {
+	p := &dependParser{tokens: tokenizeDepend(rdepend)}
+
+	deps, err := p.parseList(false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GentooRDEPEND{Dependencies: deps, Invalid: p.invalid}, nil
+}
@@ -0,0 +1,176 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
+	. "github.com/mudler/luet/pkg/tree/builder/gentoo"
+)
+
+var _ = Describe("Dependency parser", func() {
+
+	Context("Use conditionals and nested groups on the same line", func() {
+		gr, err := ParseRDEPEND(`mount? ( sys-fs/fuse ext2? ( sys-fs/genext2fs ) ) app-arch/xz-utils
+	!gtk? ( ( x11-libs/libX11 x11-libs/libXt ) )`)
+
+		It("Parses the tree", func() {
+			Expect(err).Should(BeNil())
+			Expect(len(gr.Dependencies)).Should(Equal(3))
+
+			mount := gr.Dependencies[0]
+			Expect(mount.Use).Should(Equal("mount"))
+			Expect(len(mount.SubDeps)).Should(Equal(2))
+			Expect(mount.SubDeps[1].Use).Should(Equal("ext2"))
+			Expect(mount.SubDeps[1].SubDeps[0].Dep.Name).Should(Equal("genext2fs"))
+
+			Expect(gr.Dependencies[1].Dep.Name).Should(Equal("xz-utils"))
+
+			gtk := gr.Dependencies[2]
+			Expect(gtk.Use).Should(Equal("gtk"))
+			Expect(gtk.UseCondition).Should(BeEquivalentTo(_gentoo.PkgCondNot))
+			Expect(gtk.SubDeps[0].Group).Should(Equal(GroupAllOf))
+			Expect(len(gtk.SubDeps[0].SubDeps)).Should(Equal(2))
+
+			Expect(len(gr.GetDependencies())).Should(Equal(5))
+		})
+	})
+
+	Context("Parenthesis without spaces", func() {
+		gr, err := ParseRDEPEND(`ssl?(dev-libs/openssl:0=)||(net-misc/curl net-misc/wget)`)
+
+		It("Parses the tree", func() {
+			Expect(err).Should(BeNil())
+			Expect(len(gr.Dependencies)).Should(Equal(2))
+			Expect(gr.Dependencies[0].Use).Should(Equal("ssl"))
+			Expect(gr.Dependencies[0].SubDeps[0].Dep.Name).Should(Equal("openssl"))
+			Expect(gr.Dependencies[1].Group).Should(Equal(GroupAnyOf))
+			Expect(len(gr.Dependencies[1].SubDeps)).Should(Equal(2))
+		})
+	})
+
+	Context("Choice groups", func() {
+		gr, err := ParseRDEPEND(`
+	?? ( dev-lang/lua:5.1 dev-lang/luajit )
+	^^ ( dev-lang/python:3.8 dev-lang/python:3.9 )
+`)
+
+		It("Parses the groups", func() {
+			Expect(err).Should(BeNil())
+			Expect(gr.Dependencies[0].Group).Should(Equal(GroupAtMostOneOf))
+			Expect(gr.Dependencies[1].Group).Should(Equal(GroupExactlyOneOf))
+			Expect(len(gr.GetDependencies())).Should(Equal(0))
+			// ?? doesn't require any of its alternatives
+			Expect(gr.GetAnyOfDependencies()).Should(Equal([]*GentooDependency{gr.Dependencies[1]}))
+		})
+	})
+
+	Context("Blockers", func() {
+		gr, err := ParseRDEPEND(`!app-misc/foo !!<sys-apps/bar-2.0 !app-misc/baz:2`)
+
+		It("Parses the blockers", func() {
+			Expect(err).Should(BeNil())
+			Expect(len(gr.Dependencies)).Should(Equal(3))
+
+			Expect(gr.Dependencies[0].Blocker).Should(Equal(BlockerWeak))
+			Expect(gr.Dependencies[0].Dep.Name).Should(Equal("foo"))
+			Expect(gr.Dependencies[0].Dep.Category).Should(Equal("app-misc"))
+
+			Expect(gr.Dependencies[1].Blocker).Should(Equal(BlockerStrong))
+			Expect(gr.Dependencies[1].IsBlocker()).Should(BeTrue())
+			Expect(gr.Dependencies[1].Dep.Name).Should(Equal("bar"))
+			Expect(gr.Dependencies[1].Dep.Version).Should(Equal("2.0"))
+			Expect(gr.Dependencies[1].Dep.Condition).Should(BeEquivalentTo(_gentoo.PkgCondLess))
+
+			Expect(gr.Dependencies[2].GetSlot()).Should(Equal("2"))
+		})
+	})
+
+	Context("Slot operators", func() {
+		gr, err := ParseRDEPEND(`dev-libs/a:= dev-libs/b:* dev-libs/c:3/3.1 dev-libs/d:3/3.1= dev-libs/e:0=`)
+
+		It("Parses the slots", func() {
+			Expect(err).Should(BeNil())
+			Expect(len(gr.Dependencies)).Should(Equal(5))
+
+			Expect(gr.Dependencies[0].GetSlot()).Should(Equal(""))
+			Expect(gr.Dependencies[0].GetSlotOperator()).Should(Equal("="))
+
+			Expect(gr.Dependencies[1].GetSlot()).Should(Equal(""))
+			Expect(gr.Dependencies[1].GetSlotOperator()).Should(Equal("*"))
+
+			Expect(gr.Dependencies[2].GetSlot()).Should(Equal("3"))
+			Expect(gr.Dependencies[2].GetSubSlot()).Should(Equal("3.1"))
+			Expect(gr.Dependencies[2].GetSlotOperator()).Should(Equal(""))
+
+			Expect(gr.Dependencies[3].GetSlot()).Should(Equal("3"))
+			Expect(gr.Dependencies[3].GetSubSlot()).Should(Equal("3.1"))
+			Expect(gr.Dependencies[3].GetSlotOperator()).Should(Equal("="))
+
+			Expect(gr.Dependencies[4].GetSlot()).Should(Equal("0"))
+			Expect(gr.Dependencies[4].GetSlotOperator()).Should(Equal("="))
+		})
+	})
+
+	Context("USE dependencies", func() {
+		gr, err := ParseRDEPEND(`>=sys-libs/ncurses-5.7-r5:0=[static-libs,-gpm] media-libs/mesa[X(+),gles2?,!egl=]`)
+
+		It("Parses the USE deps", func() {
+			Expect(err).Should(BeNil())
+			Expect(len(gr.Dependencies)).Should(Equal(2))
+
+			ncurses := gr.Dependencies[0].Dep
+			Expect(ncurses.Name).Should(Equal("ncurses"))
+			Expect(ncurses.Version).Should(Equal("5.7"))
+			Expect(ncurses.VersionSuffix).Should(Equal("-r5"))
+			Expect(ncurses.Slot).Should(Equal("0="))
+			Expect(ncurses.UseFlags).Should(Equal([]string{"static-libs", "-gpm"}))
+
+			mesa := gr.Dependencies[1].Dep
+			Expect(mesa.Name).Should(Equal("mesa"))
+			Expect(mesa.UseFlags).Should(Equal([]string{"X(+)", "gles2?", "!egl="}))
+		})
+	})
+
+	Context("Invalid strings", func() {
+		It("Fails on unbalanced parenthesis", func() {
+			_, err := ParseRDEPEND(`mount? ( sys-fs/fuse`)
+			Expect(err).Should(HaveOccurred())
+
+			_, err = ParseRDEPEND(`sys-fs/fuse )`)
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("Fails on groups without parenthesis", func() {
+			_, err := ParseRDEPEND(`|| sys-fs/fuse`)
+			Expect(err).Should(HaveOccurred())
+
+			_, err = ParseRDEPEND(`mount? sys-fs/fuse`)
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("Reports the atoms without version", func() {
+			r, err := ParseRDEPEND(`=dev-libs/foo- doc? ( ~dev-libs/bar- ) sys-fs/fuse`)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(r.Invalid).Should(Equal([]string{"=dev-libs/foo-", "~dev-libs/bar-"}))
+			Expect(len(r.Dependencies)).Should(Equal(2))
+			Expect(r.FilterUse(map[string]bool{}).Invalid).Should(Equal(r.Invalid))
+		})
+	})
+
+})
@@ -13,12 +13,7 @@
 // You should have received a copy of the GNU General Public License along
 // with this program; if not, see <http://www.gnu.org/licenses/>.
 
-// Recipe is a builder imeplementation.
-
-// It reads a Tree and spit it in human readable form (YAML), called recipe,
-// It also loads a tree (recipe) from a YAML (to a db, e.g. BoltDB), allowing to query it
-// with the solver, using the package object.
-package tree_test
+package gentoo_test
 
 import (
 	"io/ioutil"
@@ -33,9 +28,6 @@ import (
 	. "github.com/mudler/luet/pkg/tree"
 )
 
-type FakeParser struct {
-}
-
 var _ = Describe("Recipe", func() {
 	for _, dbType := range []gentoo.MemoryDB{gentoo.InMemory, gentoo.BoltDB} {
 		Context("Tree generation and storing", func() {
@@ -45,7 +37,7 @@ var _ = Describe("Recipe", func() {
 				defer os.RemoveAll(tmpdir) // clean up
 
 				gb := gentoo.NewGentooBuilder(&gentoo.SimpleEbuildParser{}, 20, dbType)
-				tree, err := gb.Generate("../../tests/fixtures/overlay")
+				tree, err := gb.Generate("../../../../tests/fixtures/overlay")
 				Expect(err).ToNot(HaveOccurred())
 				defer func() {
 					Expect(tree.Clean()).ToNot(HaveOccurred())
@@ -66,7 +58,7 @@ var _ = Describe("Recipe", func() {
 				defer os.RemoveAll(tmpdir) // clean up
 
 				gb := gentoo.NewGentooBuilder(&gentoo.SimpleEbuildParser{}, 20, dbType)
-				tree, err := gb.Generate("../../tests/fixtures/overlay")
+				tree, err := gb.Generate("../../../../tests/fixtures/overlay")
 				Expect(err).ToNot(HaveOccurred())
 				defer func() {
 					Expect(tree.Clean()).ToNot(HaveOccurred())
@@ -102,7 +94,7 @@ var _ = Describe("Recipe", func() {
 				defer os.RemoveAll(tmpdir) // clean up
 
 				gb := gentoo.NewGentooBuilder(&gentoo.SimpleEbuildParser{}, 20, dbType)
-				tree, err := gb.Generate("../../tests/fixtures/overlay")
+				tree, err := gb.Generate("../../../../tests/fixtures/overlay")
 				Expect(err).ToNot(HaveOccurred())
 				defer func() {
 					Expect(tree.Clean()).ToNot(HaveOccurred())
@@ -112,7 +104,7 @@ var _ = Describe("Recipe", func() {
 
 				pack, err := tree.FindPackage(&pkg.DefaultPackage{
 					Name:     "pinentry",
-					Version:  "1.0.0-r2",
+					Version:  luetVersion("1.0.0-r2"),
 					Category: "app-crypt",
 				}) // Note: the definition depends on pinentry-base without an explicit version
 				Expect(err).ToNot(HaveOccurred())
@@ -120,16 +112,18 @@ var _ = Describe("Recipe", func() {
 				s := solver.NewSolver(solver.Options{Type: solver.SingleCoreSimple}, pkg.NewInMemoryDatabase(false), tree, tree)
 				solution, err := s.Install([]pkg.Package{pack})
 				Expect(err).ToNot(HaveOccurred())
-				Expect(len(solution)).To(Equal(14))
+				// The packages of the tree and all the dependencies, with the
+				// USE conditionals
+				Expect(len(solution)).To(Equal(24))
 
 				var allSol string
 				for _, sol := range solution {
 					allSol = allSol + "\n" + sol.ToString()
 				}
 
-				Expect(allSol).To(ContainSubstring("app-crypt/pinentry-base 1.0.0 installed"))
-				Expect(allSol).To(ContainSubstring("app-crypt/pinentry 1.1.0-r2 not installed"))
-				Expect(allSol).To(ContainSubstring("app-crypt/pinentry 1.0.0-r2 installed"))
+				Expect(allSol).To(ContainSubstring("app-crypt/pinentry-base " + luetVersion("1.0.0-r2") + " installed"))
+				Expect(allSol).To(ContainSubstring("app-crypt/pinentry " + luetVersion("1.1.0-r2") + " not installed"))
+				Expect(allSol).To(ContainSubstring("app-crypt/pinentry " + luetVersion("1.0.0-r2") + " installed"))
 			})
 		})
 	}
@@ -0,0 +1,220 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EbuildStatus is the result of the conversion of an ebuild.
+type EbuildStatus string
+
+const (
+	EbuildOk         EbuildStatus = "ok"
+	EbuildParseError EbuildStatus = "parse_error"
+	EbuildTimeout    EbuildStatus = "timeout"
+	EbuildPanic      EbuildStatus = "panic"
+	EbuildSkipped    EbuildStatus = "skipped"
+	EbuildForbidden  EbuildStatus = "forbidden"
+)
+
+// IsFailure returns true for the statuses of the ebuilds not converted
+// because of an error.
+func (s EbuildStatus) IsFailure() bool {
+	switch s {
+	case EbuildParseError, EbuildTimeout, EbuildPanic, EbuildForbidden:
+		return true
+	}
+	return false
+}
+
+// EbuildResult is the result of the conversion of an ebuild.
+type EbuildResult struct {
+	Ebuild   string       `json:"ebuild"`
+	Packages []string     `json:"packages,omitempty"`
+	Status   EbuildStatus `json:"status"`
+	Message  string       `json:"message,omitempty"`
+	// Time is the time spent on the ebuild, in seconds
+	Time float64 `json:"time"`
+}
+
+// ConversionReport collects the results of the ebuilds scanned by Generate.
+type ConversionReport struct {
+	Results []*EbuildResult `json:"results"`
+
+	mutex sync.Mutex
+}
+
+// NewConversionReport returns an empty report.
+func NewConversionReport() *ConversionReport {
+	return &ConversionReport{Results: []*EbuildResult{}}
+}
+
+// Add adds the result of an ebuild.
+func (r *ConversionReport) Add(res *EbuildResult) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Results = append(r.Results, res)
+}
+
+// newResult returns the result of an ebuild started at the given time.
+func newResult(ebuild string, start time.Time) *EbuildResult {
+	return &EbuildResult{
+		Ebuild:   ebuild,
+		Packages: []string{},
+		Status:   EbuildOk,
+		Time:     time.Since(start).Seconds(),
+	}
+}
+
+// SkipPackage removes a package, excluded after the scan of its ebuild,
+// from the results. The ebuilds without packages are marked as skipped.
+func (r *ConversionReport) SkipPackage(p, reason string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, res := range r.Results {
+		for i, rp := range res.Packages {
+			if rp != p {
+				continue
+			}
+			res.Packages = append(res.Packages[:i], res.Packages[i+1:]...)
+			if len(res.Packages) == 0 && res.Status == EbuildOk {
+				res.Status = EbuildSkipped
+				res.Message = reason
+			}
+			return
+		}
+	}
+}
+
+// Count returns the number of ebuilds with the given status.
+func (r *ConversionReport) Count(status EbuildStatus) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	n := 0
+	for _, res := range r.Results {
+		if res.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Failures returns the number of ebuilds not converted because of an error.
+func (r *ConversionReport) Failures() int {
+	return r.Count(EbuildParseError) + r.Count(EbuildTimeout) + r.Count(EbuildPanic) +
+		r.Count(EbuildForbidden)
+}
+
+// sorted returns the results sorted by ebuild.
+func (r *ConversionReport) sorted() []*EbuildResult {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ans := append([]*EbuildResult{}, r.Results...)
+	sort.Slice(ans, func(i, j int) bool { return ans[i].Ebuild < ans[j].Ebuild })
+	return ans
+}
+
+// WriteJSON writes the report in JSON format.
+func (r *ConversionReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&struct {
+		Results []*EbuildResult `json:"results"`
+	}{r.sorted()})
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name         `xml:"testsuite"`
+	Name      string           `xml:"name,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	Errors    int              `xml:"errors,attr"`
+	Skipped   int              `xml:"skipped,attr"`
+	Time      string           `xml:"time,attr"`
+	TestCases []*junitTestCase `xml:"testcase"`
+}
+
+// WriteJUnit writes the report in JUnit XML format: an ebuild is a test case,
+// the parse errors and the timeouts are failures and the panics are errors.
+func (r *ConversionReport) WriteJUnit(w io.Writer) error {
+	suite := &junitTestSuite{Name: "convert", TestCases: []*junitTestCase{}}
+
+	total := 0.0
+	for _, res := range r.sorted() {
+		tc := &junitTestCase{
+			// <category>/<package>
+			ClassName: filepath.Base(filepath.Dir(filepath.Dir(res.Ebuild))) + "/" +
+				filepath.Base(filepath.Dir(res.Ebuild)),
+			Name: filepath.Base(res.Ebuild),
+			Time: fmt.Sprintf("%.3f", res.Time),
+		}
+		msg := &junitMessage{Message: res.Message, Type: string(res.Status), Text: res.Message}
+
+		switch res.Status {
+		case EbuildParseError, EbuildTimeout, EbuildForbidden:
+			tc.Failure = msg
+			suite.Failures++
+		case EbuildPanic:
+			tc.Error = msg
+			suite.Errors++
+		case EbuildSkipped:
+			tc.Skipped = &junitMessage{Message: res.Message}
+			suite.Skipped++
+		}
+
+		total += res.Time
+		suite.Tests++
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+	suite.Time = fmt.Sprintf("%.3f", total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
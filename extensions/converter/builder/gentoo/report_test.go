@@ -0,0 +1,131 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/mudler/luet/pkg/tree/builder/gentoo"
+)
+
+// faultyParser fails on the ebuilds of the packages named panic and timeout.
+type faultyParser struct {
+	SimpleEbuildParser
+}
+
+func (p *faultyParser) ScanEbuild(path string) (pkg.Packages, error) {
+	return p.ScanEbuildContext(context.Background(), path)
+}
+
+func (p *faultyParser) ScanEbuildContext(ctx context.Context, path string) (pkg.Packages, error) {
+	switch filepath.Base(filepath.Dir(path)) {
+	case "panic":
+		panic("unexpected ebuild")
+	case "timeout":
+		return pkg.Packages{}, ErrEbuildTimeout
+	}
+	return p.SimpleEbuildParser.ScanEbuildContext(ctx, path)
+}
+
+var _ = Describe("Conversion report", func() {
+
+	Context("Generate", func() {
+		var tmpdir string
+		var gb *GentooBuilder
+
+		write := func(file, content string) {
+			Expect(os.MkdirAll(filepath.Dir(file), os.ModePerm)).Should(BeNil())
+			Expect(ioutil.WriteFile(file, []byte(content), 0644)).Should(BeNil())
+		}
+
+		BeforeEach(func() {
+			var err error
+			tmpdir, err = ioutil.TempDir("", "report")
+			Expect(err).Should(BeNil())
+
+			write(filepath.Join(tmpdir, "app-misc", "ok", "ok-1.0.ebuild"), "EAPI=7\nSLOT=0\nKEYWORDS=amd64\n")
+			write(filepath.Join(tmpdir, "app-misc", "live", "live-9999.ebuild"), "EAPI=7\nSLOT=0\n")
+			write(filepath.Join(tmpdir, "app-misc", "broken", "broken-1.0.ebuild"), "EAPI=7\nIUSE=\"doc\n")
+			write(filepath.Join(tmpdir, "app-misc", "panic", "panic-1.0.ebuild"), "EAPI=7\n")
+			write(filepath.Join(tmpdir, "app-misc", "timeout", "timeout-1.0.ebuild"), "EAPI=7\n")
+
+			f := NewPackageFilter()
+			f.Arch = "amd64"
+			f.Stability = StabilityStable
+
+			gb = NewGentooBuilder(&faultyParser{}, 2, InMemory)
+			gb.Filter = f
+			db, err := gb.Generate(tmpdir)
+			Expect(err).Should(BeNil())
+			db.Clean()
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tmpdir)
+		})
+
+		It("Collects the result of every ebuild", func() {
+			report := gb.GetReport()
+			Expect(len(report.Results)).Should(Equal(5))
+			Expect(report.Count(EbuildOk)).Should(Equal(1))
+			Expect(report.Count(EbuildSkipped)).Should(Equal(1))
+			Expect(report.Count(EbuildParseError)).Should(Equal(1))
+			Expect(report.Count(EbuildTimeout)).Should(Equal(1))
+			Expect(report.Count(EbuildPanic)).Should(Equal(1))
+			Expect(report.Failures()).Should(Equal(3))
+		})
+
+		It("Writes the report in JSON", func() {
+			var buf bytes.Buffer
+			Expect(gb.GetReport().WriteJSON(&buf)).Should(BeNil())
+
+			decoded := struct {
+				Results []EbuildResult `json:"results"`
+			}{}
+			Expect(json.Unmarshal(buf.Bytes(), &decoded)).Should(BeNil())
+			Expect(len(decoded.Results)).Should(Equal(5))
+
+			// sorted by ebuild
+			Expect(decoded.Results[0].Ebuild).Should(HaveSuffix("broken-1.0.ebuild"))
+			Expect(decoded.Results[0].Status).Should(Equal(EbuildParseError))
+			Expect(decoded.Results[1].Status).Should(Equal(EbuildSkipped))
+			Expect(decoded.Results[1].Message).Should(Equal("no keywords"))
+			Expect(decoded.Results[2].Packages).Should(Equal([]string{
+				(&pkg.DefaultPackage{Category: "app-misc", Name: "ok", Version: luetVersion("1.0")}).HumanReadableString(),
+			}))
+			Expect(decoded.Results[3].Message).Should(Equal("unexpected ebuild"))
+		})
+
+		It("Writes the report in JUnit", func() {
+			var buf bytes.Buffer
+			Expect(gb.GetReport().WriteJUnit(&buf)).Should(BeNil())
+			out := buf.String()
+			Expect(out).Should(ContainSubstring(`<testsuite name="convert" tests="5" failures="2" errors="1" skipped="1"`))
+			Expect(out).Should(ContainSubstring(`<testcase classname="app-misc/ok" name="ok-1.0.ebuild"`))
+			Expect(strings.Count(out, "<failure")).Should(Equal(2))
+		})
+	})
+})
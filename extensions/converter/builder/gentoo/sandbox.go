@@ -0,0 +1,239 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/mudler/luet/pkg/logger"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+const (
+	// DefaultMaxSteps is the maximum number of statements, loop
+	// iterations included, executed on the source of an ebuild.
+	DefaultMaxSteps = 1000000
+	// DefaultMaxVarsSize is the maximum size in bytes of the variables
+	// of an ebuild.
+	DefaultMaxVarsSize = 16 * 1024 * 1024
+)
+
+// DefaultEbuildStubs are the commands of the package manager that are
+// meaningful only in the phases, they always succeed without output.
+var DefaultEbuildStubs = []string{
+	"die", "assert", "nonfatal", "EXPORT_FUNCTIONS",
+	"einfo", "einfon", "elog", "ewarn", "eerror", "eqawarn", "ebegin", "eend",
+	"debug-print", "debug-print-function", "debug-print-section",
+	"has_version", "best_version", "addread", "addwrite", "addpredict", "adddeny",
+}
+
+// SandboxViolation is the kind of construct forbidden by the Sandbox.
+type SandboxViolation string
+
+const (
+	// ForbiddenCommand is the execution of an external command.
+	ForbiddenCommand SandboxViolation = "command"
+	// ForbiddenFile is the access to a file that isn't an eclass.
+	ForbiddenFile SandboxViolation = "file"
+	// StepLimit is the execution of more than MaxSteps statements.
+	StepLimit SandboxViolation = "steps"
+	// MemoryLimit is the use of more than MaxVarsSize bytes of variables.
+	MemoryLimit SandboxViolation = "memory"
+)
+
+// SandboxError is returned when an ebuild hits a construct forbidden
+// by the Sandbox.
+type SandboxError struct {
+	Violation SandboxViolation
+	// Detail is the command, the file or the limit exceeded.
+	Detail string
+}
+
+func (e *SandboxError) Error() string {
+	switch e.Violation {
+	case ForbiddenCommand:
+		return "forbidden command " + e.Detail
+	case ForbiddenFile:
+		return "forbidden access to " + e.Detail
+	}
+	return fmt.Sprintf("%s limit of %s exceeded", e.Violation, e.Detail)
+}
+
+// ExternalPolicy is how the Sandbox handles the commands that aren't
+// functions, builtins, helpers, stubs nor allowed.
+type ExternalPolicy int
+
+const (
+	// StubExternal replaces them with a command that always succeeds
+	// without output.
+	StubExternal ExternalPolicy = iota
+	// ForbidExternal stops the source with a SandboxError.
+	ForbidExternal
+)
+
+// EbuildHelper is a command implemented in Go available to the ebuilds
+// in the Sandbox, args[0] is the name of the command.
+type EbuildHelper func(hc interp.HandlerContext, args []string) error
+
+// Sandbox defines what an ebuild can do while its global scope is
+// sourced: only the eclasses can be read, the commands are never
+// executed on the host, unless Allowed, and the number of statements
+// and the size of the variables are limited.
+type Sandbox struct {
+	// Helpers are the commands implemented in Go.
+	Helpers map[string]EbuildHelper
+	// Stubs are the commands that always succeed without output.
+	Stubs []string
+	// Allowed are the commands executed from PATH.
+	Allowed []string
+	// External is the policy of the other commands.
+	External ExternalPolicy
+	// MaxSteps is the maximum number of statements executed, 0 disables it.
+	MaxSteps int64
+	// MaxVarsSize is the maximum size of the variables, 0 disables it.
+	// It's checked after every statement of the global scope.
+	MaxVarsSize int
+}
+
+// NewSandbox returns a Sandbox with the default helpers, stubs and
+// limits, that stubs the external commands.
+func NewSandbox() *Sandbox {
+	helpers := make(map[string]EbuildHelper)
+	for name, h := range DefaultEbuildHelpers {
+		helpers[name] = h
+	}
+	return &Sandbox{
+		Helpers:     helpers,
+		Stubs:       append([]string{}, DefaultEbuildStubs...),
+		Allowed:     []string{},
+		External:    StubExternal,
+		MaxSteps:    DefaultMaxSteps,
+		MaxVarsSize: DefaultMaxVarsSize,
+	}
+}
+
+// sandboxRun is the state of a source in the Sandbox.
+type sandboxRun struct {
+	*Sandbox
+	eclassDirs []string
+	cancel     context.CancelFunc
+
+	steps     int64
+	mutex     sync.Mutex
+	violation *SandboxError
+}
+
+func (s *Sandbox) newRun(eclassDirs []string, cancel context.CancelFunc) *sandboxRun {
+	return &sandboxRun{Sandbox: s, eclassDirs: eclassDirs, cancel: cancel}
+}
+
+// violate records the first violation and stops the source.
+func (r *sandboxRun) violate(e *SandboxError) error {
+	r.mutex.Lock()
+	if r.violation == nil {
+		r.violation = e
+	}
+	r.mutex.Unlock()
+	r.cancel()
+	return e
+}
+
+// getViolation returns the violation that stopped the source, if any.
+func (r *sandboxRun) getViolation() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.violation == nil {
+		return nil
+	}
+	return r.violation
+}
+
+// stepContext counts the steps of the source: the interpreter checks
+// the context before every statement and loop iteration.
+type stepContext struct {
+	context.Context
+	run *sandboxRun
+}
+
+func (c *stepContext) Err() error {
+	n := atomic.AddInt64(&c.run.steps, 1)
+	if c.run.MaxSteps > 0 && n > c.run.MaxSteps {
+		c.run.violate(&SandboxError{Violation: StepLimit, Detail: fmt.Sprintf("%d statements", c.run.MaxSteps)})
+	}
+	return c.Context.Err()
+}
+
+// execHandler runs the helpers, the stubs and the allowed commands,
+// the others are handled as defined by the External policy.
+func (r *sandboxRun) execHandler() interp.ExecHandlerFunc {
+	def := interp.DefaultExecHandler(2 * time.Second)
+	return func(ctx context.Context, args []string) error {
+		name := args[0]
+		if h, ok := r.Helpers[name]; ok {
+			return h(interp.HandlerCtx(ctx), args)
+		}
+		if contains(r.Allowed, name) {
+			return def(ctx, args)
+		}
+		if r.External == ForbidExternal && !contains(r.Stubs, name) {
+			return r.violate(&SandboxError{Violation: ForbiddenCommand, Detail: name})
+		}
+		Debug("Stub command", name)
+		return nil
+	}
+}
+
+// openHandler resolves the eclasses sourced by inherit from the
+// eclass directories, the other files can't be opened.
+func (r *sandboxRun) openHandler() interp.OpenHandlerFunc {
+	def := interp.DefaultOpenHandler()
+	return func(ctx context.Context, path string, flag int, perm os.FileMode) (io.ReadWriteCloser, error) {
+		switch {
+		case path == os.DevNull:
+			return def(ctx, path, flag, perm)
+		case strings.HasSuffix(path, EclassExt) && !strings.Contains(path, "/") && flag == os.O_RDONLY:
+			file, err := FindEclass(strings.TrimSuffix(path, EclassExt), r.eclassDirs)
+			if err != nil {
+				return nil, err
+			}
+			return def(ctx, file, flag, perm)
+		}
+		return nil, r.violate(&SandboxError{Violation: ForbiddenFile, Detail: path})
+	}
+}
+
+// varsSize returns the size in bytes of the variables of the runner.
+func varsSize(r *interp.Runner) int {
+	size := 0
+	for name, v := range r.Vars {
+		size += len(name) + len(v.Str)
+		for _, s := range v.List {
+			size += len(s)
+		}
+		for k, s := range v.Map {
+			size += len(k) + len(s)
+		}
+	}
+	return size
+}
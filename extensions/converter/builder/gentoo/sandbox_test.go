@@ -0,0 +1,191 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
+	. "github.com/mudler/luet/pkg/tree/builder/gentoo"
+	"mvdan.cc/sh/v3/expand"
+)
+
+var _ = Describe("Sandbox", func() {
+	var tmpdir string
+
+	gp := &_gentoo.GentooPackage{
+		Category: "app-misc",
+		Name:     "foo",
+		Version:  "1.2.3",
+		Slot:     "0",
+	}
+
+	// source sources the content as an ebuild in the sandbox
+	source := func(s *Sandbox, content string) (map[string]expand.Variable, error) {
+		ebuild := filepath.Join(tmpdir, "app-misc", "foo", "foo-1.2.3.ebuild")
+		Expect(os.MkdirAll(filepath.Dir(ebuild), os.ModePerm)).Should(BeNil())
+		Expect(ioutil.WriteFile(ebuild, []byte(content), 0644)).Should(BeNil())
+		return s.SourceFile(context.Background(), ebuild, gp)
+	}
+
+	violation := func(err error) SandboxViolation {
+		var serr *SandboxError
+		Expect(errors.As(err, &serr)).To(BeTrue(), "%v", err)
+		return serr.Violation
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = ioutil.TempDir("", "sandbox")
+		Expect(err).Should(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpdir)
+	})
+
+	Context("Commands", func() {
+		ebuild := "SLOT=0\nOS=\"$(uname -s)\"\ndie \"not supported\"\nDESCRIPTION=\"foo\"\n"
+
+		It("Stubs the external commands", func() {
+			vars, err := source(NewSandbox(), ebuild)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(vars["OS"].String()).To(Equal(""))
+			Expect(vars["DESCRIPTION"].String()).To(Equal("foo"))
+		})
+
+		It("Forbids the external commands", func() {
+			s := NewSandbox()
+			s.External = ForbidExternal
+			_, err := source(s, ebuild)
+			Expect(violation(err)).To(Equal(ForbiddenCommand))
+			Expect(err.Error()).To(Equal("forbidden command uname"))
+
+			// die is a stub
+			_, err = source(s, "SLOT=0\ndie \"not supported\"\n")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Runs the allowed commands", func() {
+			s := NewSandbox()
+			s.External = ForbidExternal
+			s.Allowed = []string{"cat"}
+			vars, err := source(s, "SLOT=0\nMY_PV=\"$(echo ${PV} | cat)\"\n")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(vars["MY_PV"].String()).To(Equal("1.2.3"))
+		})
+	})
+
+	Context("Files", func() {
+		It("Forbids the files that aren't eclasses", func() {
+			file := filepath.Join(tmpdir, "written")
+			_, err := source(NewSandbox(), "SLOT=0\necho foo > "+file+"\n")
+			Expect(violation(err)).To(Equal(ForbiddenFile))
+			_, err = os.Stat(file)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+
+			_, err = source(NewSandbox(), "SLOT=0\nX=\"$(< /etc/passwd)\"\n")
+			Expect(violation(err)).To(Equal(ForbiddenFile))
+
+			_, err = source(NewSandbox(), "SLOT=0\necho foo > /dev/null\n")
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Context("Limits", func() {
+		It("Stops the endless loops", func() {
+			s := NewSandbox()
+			s.MaxSteps = 1000
+			_, err := source(s, "SLOT=0\nwhile true; do :; done\nDESCRIPTION=foo\n")
+			Expect(violation(err)).To(Equal(StepLimit))
+		})
+
+		It("Limits the size of the variables", func() {
+			s := NewSandbox()
+			s.MaxVarsSize = 4096
+			ebuild := "SLOT=0\nX=0123456789\n"
+			for i := 0; i < 10; i++ {
+				ebuild += "X=\"${X}${X}\"\n"
+			}
+			_, err := source(s, ebuild)
+			Expect(violation(err)).To(Equal(MemoryLimit))
+
+			s.MaxVarsSize = 0
+			vars, err := source(s, ebuild)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(vars["X"].String())).To(Equal(10240))
+		})
+
+		It("Reports the ebuilds stopped by the sandbox", func() {
+			s := NewSandbox()
+			s.External = ForbidExternal
+			_, err := source(s, "SLOT=0\nX=\"$(uname)\"\n")
+			Expect(err).To(HaveOccurred())
+
+			gb := NewGentooBuilder(&SimpleEbuildParser{Sandbox: s}, 1, InMemory)
+			db, err := gb.Generate(tmpdir)
+			Expect(err).ToNot(HaveOccurred())
+			defer db.Clean()
+			Expect(len(db.World())).To(Equal(0))
+			Expect(gb.GetReport().Count(EbuildForbidden)).To(Equal(1))
+			Expect(gb.GetReport().Failures()).To(Equal(1))
+		})
+	})
+
+	Context("Helpers", func() {
+		It("Evaluates the helpers in Go", func() {
+			vars, err := source(NewSandbox(), `SLOT=0
+USE="gtk ssl"
+A="$(usex gtk)"
+B="$(usex qt5 yes no -y -n)"
+C="$(use_enable ssl) $(use_enable !ssl nossl) $(use_with gtk gtk3 3)"
+D="$(ver_cut 1-2) $(ver_cut 2-) $(ver_cut 4 1.2.3_rc4) $(ver_cut 1-4 1.2b_p3)"
+use gtk && E=1
+use qt5 || F=1
+G="$(use_with qt5) $(use_enable !gtk)"
+use_with qt5 > /dev/null && H=0
+`)
+			Expect(err).ToNot(HaveOccurred())
+			for name, value := range map[string]string{
+				"A": "yes",
+				"B": "no-n",
+				"C": "--enable-ssl --disable-nossl --with-gtk3=3",
+				"D": "1.2 2.3 rc 1.2b_p",
+				"E": "1",
+				"F": "1",
+				"G": "--without-qt5 --disable-gtk",
+				"H": "0",
+			} {
+				Expect(vars[name].String()).To(Equal(value), name)
+			}
+		})
+
+		It("Uses the helpers of the sandbox", func() {
+			s := NewSandbox()
+			delete(s.Helpers, "usex")
+			vars, err := source(s, "SLOT=0\nA=\"$(usex gtk)\"\n")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(vars["A"].String()).To(Equal(""))
+		})
+	})
+})
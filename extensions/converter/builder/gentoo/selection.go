@@ -0,0 +1,200 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
+	pkg "github.com/mudler/luet/pkg/package"
+	"github.com/mudler/luet/pkg/tree/builder/common"
+)
+
+// PackageSelector selects the ebuilds of the tree to convert. The
+// selectors are atoms (cat/pkg, >=cat/pkg-1.0), globs on category and
+// name (dev-lang/*, */python) or globs on the category alone (dev-*).
+type PackageSelector struct {
+	// Include are the selectors of the packages to convert,
+	// if empty every package is included.
+	Include []string
+	// Exclude are the selectors of the packages never converted.
+	Exclude []string
+	// Deps adds the transitive dependencies of the included packages,
+	// runtime and build, available in the tree. The whole tree is scanned
+	// and the packages not required are dropped afterwards.
+	Deps bool
+}
+
+// NewPackageSelector returns a selector that includes every package.
+func NewPackageSelector() *PackageSelector {
+	return &PackageSelector{Include: []string{}, Exclude: []string{}}
+}
+
+// normalizeSelector turns a glob on the category in a glob on
+// category and name.
+func normalizeSelector(s string) string {
+	s = strings.TrimSpace(s)
+	if s != "" && !strings.Contains(s, "/") {
+		return s + "/*"
+	}
+	return s
+}
+
+// AddInclude adds the selectors of the packages to convert.
+func (s *PackageSelector) AddInclude(selectors ...string) {
+	for _, sel := range selectors {
+		if sel = normalizeSelector(sel); sel != "" {
+			s.Include = append(s.Include, sel)
+		}
+	}
+}
+
+// AddExclude adds the selectors of the packages to skip.
+func (s *PackageSelector) AddExclude(selectors ...string) {
+	for _, sel := range selectors {
+		if sel = normalizeSelector(sel); sel != "" {
+			s.Exclude = append(s.Exclude, sel)
+		}
+	}
+}
+
+// LoadAtomsFile adds the selectors listed in a file, one per line.
+// The lines starting with ! are excluded, the others included.
+func (s *PackageSelector) LoadAtomsFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "!"):
+			s.AddExclude(strings.TrimPrefix(line, "!"))
+		default:
+			s.AddInclude(line)
+		}
+	}
+	return scanner.Err()
+}
+
+// IsEmpty returns true if the selector includes every package.
+func (s *PackageSelector) IsEmpty() bool {
+	return len(s.Include) == 0 && len(s.Exclude) == 0
+}
+
+// Select returns true if the ebuild package is selected,
+// or false and the reason.
+func (s *PackageSelector) Select(gp *_gentoo.GentooPackage) (bool, string) {
+	for _, e := range s.Exclude {
+		if MatchAtom(normalizeSelector(e), gp) {
+			return false, "excluded by " + e
+		}
+	}
+	if s.included(gp) || s.Deps {
+		return true, ""
+	}
+	return false, "not included"
+}
+
+// included returns true if the package matches an Include selector.
+func (s *PackageSelector) included(gp *_gentoo.GentooPackage) bool {
+	if len(s.Include) == 0 {
+		return true
+	}
+	for _, i := range s.Include {
+		if MatchAtom(normalizeSelector(i), gp) {
+			return true
+		}
+	}
+	return false
+}
+
+// SkipCategory returns true if no package of the category can be
+// selected, to avoid walking its directory.
+func (s *PackageSelector) SkipCategory(category string) bool {
+	// The selectors set without AddInclude or AddExclude
+	// may not be normalized
+	for _, e := range s.Exclude {
+		e = normalizeSelector(e)
+		if match, err := filepath.Match(e, category+"/*"); err == nil && match && strings.HasSuffix(e, "/*") {
+			return true
+		}
+	}
+	if len(s.Include) == 0 || s.Deps {
+		return false
+	}
+
+	for _, i := range s.Include {
+		cat := strings.TrimLeft(normalizeSelector(i), "<>=~!")
+		cat = cat[:strings.Index(cat, "/")]
+		if match, err := filepath.Match(cat, category); err == nil && match {
+			return false
+		}
+	}
+	return true
+}
+
+// Unrequired returns the packages of the database that aren't included
+// nor required, directly or not, by the included packages. The
+// dependencies are matched by category and name, so all the
+// versions of a dependency are kept.
+func (s *PackageSelector) Unrequired(db pkg.PackageDatabase) []pkg.Package {
+	byName := make(map[string][]pkg.Package)
+	queue := []pkg.Package{}
+	selected := make(map[string]bool)
+
+	for _, p := range db.World() {
+		byName[p.GetPackageName()] = append(byName[p.GetPackageName()], p)
+		gp, err := gentooPackage(p)
+		if err == nil && s.included(gp) {
+			queue = append(queue, p)
+			selected[p.HumanReadableString()] = true
+		}
+	}
+
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+
+		deps := p.GetRequires()
+		if dp, ok := p.(*pkg.DefaultPackage); ok {
+			deps = append(append([]*pkg.DefaultPackage{}, deps...), common.GetBuildRequires(dp)...)
+		}
+		for _, d := range deps {
+			for _, r := range byName[d.GetPackageName()] {
+				if !selected[r.HumanReadableString()] {
+					selected[r.HumanReadableString()] = true
+					queue = append(queue, r)
+				}
+			}
+		}
+	}
+
+	ans := []pkg.Package{}
+	for _, p := range db.World() {
+		if !selected[p.HumanReadableString()] {
+			ans = append(ans, p)
+		}
+	}
+	return ans
+}
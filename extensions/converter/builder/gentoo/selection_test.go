@@ -0,0 +1,158 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
+	. "github.com/mudler/luet/pkg/tree/builder/gentoo"
+)
+
+var _ = Describe("Package selector", func() {
+	var tmpdir string
+
+	write := func(file, content string) {
+		Expect(os.MkdirAll(filepath.Dir(file), os.ModePerm)).Should(BeNil())
+		Expect(ioutil.WriteFile(file, []byte(content), 0644)).Should(BeNil())
+	}
+
+	// generate converts the tree and returns the names of the packages
+	generate := func(s *PackageSelector, cache *EbuildCache) []string {
+		gb := NewGentooBuilder(&SimpleEbuildParser{}, 2, InMemory)
+		gb.Selector = s
+		gb.Cache = cache
+		db, err := gb.Generate(tmpdir)
+		Expect(err).Should(BeNil())
+		defer db.Clean()
+
+		ans := []string{}
+		for _, p := range db.World() {
+			ans = append(ans, p.GetCategory()+"/"+p.GetName())
+		}
+		sort.Strings(ans)
+		return ans
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = ioutil.TempDir("", "selector")
+		Expect(err).Should(BeNil())
+
+		write(filepath.Join(tmpdir, "dev-lang", "python", "python-3.8.ebuild"), "EAPI=7\nSLOT=0\nRDEPEND=\"dev-libs/libffi\"\n")
+		write(filepath.Join(tmpdir, "dev-libs", "libffi", "libffi-3.3.ebuild"), "EAPI=7\nSLOT=0\nDEPEND=\"sys-devel/gcc\"\n")
+		write(filepath.Join(tmpdir, "dev-libs", "bar", "bar-1.0.ebuild"), "EAPI=7\nSLOT=0\n")
+		write(filepath.Join(tmpdir, "sys-devel", "gcc", "gcc-10.ebuild"), "EAPI=7\nSLOT=0\n")
+		write(filepath.Join(tmpdir, "app-misc", "foo", "foo-1.0.ebuild"), "EAPI=7\nSLOT=0\nRDEPEND=\"dev-libs/bar\"\n")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpdir)
+	})
+
+	It("Selects by atoms and globs", func() {
+		s := NewPackageSelector()
+		s.AddInclude("dev-*", "app-misc/foo")
+		s.AddExclude(">=dev-libs/bar-1.0")
+		Expect(s.Include).Should(Equal([]string{"dev-*/*", "app-misc/foo"}))
+
+		for _, c := range []struct {
+			atom   string
+			ok     bool
+			reason string
+		}{
+			{"dev-lang/python-3.8", true, ""},
+			{"app-misc/foo-1.0", true, ""},
+			{"dev-libs/bar-1.0", false, "excluded by >=dev-libs/bar-1.0"},
+			{"sys-devel/gcc-10", false, "not included"},
+		} {
+			gp, err := _gentoo.ParsePackageStr(c.atom)
+			Expect(err).Should(BeNil())
+			ok, reason := s.Select(gp)
+			Expect(ok).Should(Equal(c.ok), c.atom)
+			Expect(reason).Should(Equal(c.reason), c.atom)
+		}
+
+		Expect(s.SkipCategory("dev-lang")).Should(BeFalse())
+		Expect(s.SkipCategory("app-misc")).Should(BeFalse())
+		Expect(s.SkipCategory("sys-devel")).Should(BeTrue())
+	})
+
+	It("Normalizes the selectors set directly", func() {
+		s := &PackageSelector{Include: []string{"dev-lang", ">=python"}, Exclude: []string{"sys-devel"}}
+		Expect(s.SkipCategory("dev-lang")).Should(BeFalse())
+		Expect(s.SkipCategory("app-misc")).Should(BeTrue())
+		Expect(s.SkipCategory("sys-devel")).Should(BeTrue())
+
+		gp, err := _gentoo.ParsePackageStr("dev-lang/python-3.8")
+		Expect(err).Should(BeNil())
+		ok, _ := s.Select(gp)
+		Expect(ok).Should(BeTrue())
+	})
+
+	It("Loads the atoms file", func() {
+		file := filepath.Join(tmpdir, "atoms")
+		write(file, "# selected\ndev-lang/python\n\n!dev-libs/bar\n")
+		s := NewPackageSelector()
+		Expect(s.LoadAtomsFile(file)).Should(BeNil())
+		Expect(s.Include).Should(Equal([]string{"dev-lang/python"}))
+		Expect(s.Exclude).Should(Equal([]string{"dev-libs/bar"}))
+	})
+
+	It("Converts only the selected packages", func() {
+		s := NewPackageSelector()
+		s.AddInclude("dev-lang/python")
+		Expect(generate(s, nil)).Should(Equal([]string{"dev-lang/python"}))
+
+		s.AddInclude("app-*")
+		Expect(generate(s, nil)).Should(Equal([]string{"app-misc/foo", "dev-lang/python"}))
+
+		s = NewPackageSelector()
+		s.AddExclude("dev-libs")
+		Expect(generate(s, nil)).Should(Equal([]string{"app-misc/foo", "dev-lang/python", "sys-devel/gcc"}))
+	})
+
+	It("Adds the transitive dependencies", func() {
+		s := NewPackageSelector()
+		s.AddInclude("dev-lang/python")
+		s.Deps = true
+		Expect(s.SkipCategory("sys-devel")).Should(BeFalse())
+		Expect(generate(s, nil)).Should(Equal([]string{"dev-lang/python", "dev-libs/libffi", "sys-devel/gcc"}))
+
+		s.AddExclude("sys-devel/*")
+		Expect(generate(s, nil)).Should(Equal([]string{"dev-lang/python", "dev-libs/libffi"}))
+	})
+
+	It("Keeps the cached packages not selected", func() {
+		cache, err := NewEbuildCache(filepath.Join(tmpdir, "cache.json"))
+		Expect(err).Should(BeNil())
+		Expect(len(generate(nil, cache))).Should(Equal(5))
+		Expect(cache.GetRemoved()).Should(BeEmpty())
+
+		s := NewPackageSelector()
+		s.AddInclude("dev-libs/libffi")
+		Expect(generate(s, cache)).Should(Equal([]string{"dev-libs/libffi"}))
+		Expect(cache.GetRemoved()).Should(BeEmpty())
+		Expect(len(cache.GetScanned())).Should(Equal(1))
+		Expect(cache.GetScanned()[0].GetName()).Should(Equal("libffi"))
+	})
+})
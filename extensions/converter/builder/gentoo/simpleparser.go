@@ -22,352 +22,259 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"os"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	. "github.com/mudler/luet/pkg/logger"
 
 	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
 	pkg "github.com/mudler/luet/pkg/package"
+	"github.com/mudler/luet/pkg/tree/builder/common"
 	"mvdan.cc/sh/v3/expand"
-	"mvdan.cc/sh/v3/shell"
 	"mvdan.cc/sh/v3/syntax"
 )
 
-const (
-	uriRegex = "(.*[.]tar[.].*|.*[.]zip|.*[.]run|.*[.]png|.*[.]rpm|.*[.]gz)"
-)
+// ErrEbuildTimeout is returned when the source of an ebuild takes too long.
+var ErrEbuildTimeout = errors.New("timeout on source of the ebuild")
+
+// DefaultEbuildTimeout is the maximum time spent on the source of an
+// ebuild, when the context has no deadline.
+const DefaultEbuildTimeout = 60 * time.Second
 
-// SimpleEbuildParser ignores USE flags and generates just 1-1 package
+// SimpleEbuildParser generates just 1-1 package. USE flags are ignored,
+// unless a Profile is set to evaluate the use conditionals.
 type SimpleEbuildParser struct {
-	World pkg.PackageDatabase
+	World   pkg.PackageDatabase
+	Profile *ConversionProfile
+	// SlotStrategy maps the SLOT of the packages and of the dependencies
+	SlotStrategy SlotStrategy
+	// Overlays are the extra trees whose eclasses are available
+	// to the scanned ebuilds, e.g. the Gentoo tree for an overlay.
+	Overlays []string
+	// Sandbox is used to source the ebuilds, if nil NewSandbox is used.
+	Sandbox *Sandbox
+
+	// mirrors of the scanned trees, see getMirrors
+	mirrors      map[string]Mirrors
+	mirrorsMutex sync.Mutex
+}
+
+// SetProfile sets the profile used to evaluate the use conditionals.
+func (ep *SimpleEbuildParser) SetProfile(p *ConversionProfile) {
+	ep.Profile = p
 }
 
-type GentooDependency struct {
-	Use          string
-	UseCondition _gentoo.PackageCond
-	SubDeps      []*GentooDependency
-	Dep          *_gentoo.GentooPackage
+// SourceFile sources the global scope of an ebuild in the default
+// Sandbox. The eclasses inherited are searched in the given eclass
+// directories.
+func SourceFile(ctx context.Context, path string, pkg *_gentoo.GentooPackage, eclassDirs ...string) (map[string]expand.Variable, error) {
+	return NewSandbox().SourceFile(ctx, path, pkg, eclassDirs...)
 }
 
-type GentooRDEPEND struct {
-	Dependencies []*GentooDependency
+// SourceFile sources the global scope of an ebuild in the sandbox.
+// It returns a *SandboxError if the ebuild hits a forbidden construct.
+func (s *Sandbox) SourceFile(ctx context.Context, path string, pkg *_gentoo.GentooPackage, eclassDirs ...string) (map[string]expand.Variable, error) {
+	return s.sourceFile(ctx, path, ebuildVars(pkg, nil), eclassDirs)
 }
 
-func NewGentooDependency(pkg, use string) (*GentooDependency, error) {
-	var err error
-	ans := &GentooDependency{
-		Use:     use,
-		SubDeps: make([]*GentooDependency, 0),
+// sourceFile sources the global scope of an ebuild after the prelude.
+func (s *Sandbox) sourceFile(ctx context.Context, path string, prelude string, eclassDirs []string) (map[string]expand.Variable, error) {
+	content, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open: %v", err)
 	}
+	defer content.Close()
 
-	if strings.HasPrefix(use, "!") {
-		ans.Use = ans.Use[1:]
-		ans.UseCondition = _gentoo.PkgCondNot
+	file, err := syntax.NewParser().Parse(content, path)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse: %v", err)
 	}
 
-	if pkg != "" {
-		ans.Dep, err = _gentoo.ParsePackageStr(pkg)
-		if err != nil {
-			return nil, err
-		}
+	return s.sourceNode(ctx, file, prelude, eclassDirs)
+}
 
-		// TODO: Fix this on parsing phase for handle correctly ${PV}
-		if strings.HasSuffix(ans.Dep.Name, "-") {
-			ans.Dep.Name = ans.Dep.Name[:len(ans.Dep.Name)-1]
+// ebuildVars returns the assignments of the variables defined by the
+// package manager for an ebuild (P, PN, PV, PR, PVR, PF, CATEGORY), and
+// of USE with the given flags, if not nil.
+func ebuildVars(gp *_gentoo.GentooPackage, use []string) string {
+	pvr := gp.Version + gp.VersionSuffix
+	pv, pr := pvr, "r0"
+	if i := strings.LastIndex(pvr, "-r"); i >= 0 {
+		if _, err := strconv.Atoi(pvr[i+2:]); err == nil {
+			pv, pr = pvr[:i], pvr[i+1:]
 		}
-
 	}
 
-	return ans, nil
-}
-
-func (d *GentooDependency) String() string {
-	if d.Dep != nil {
-		return fmt.Sprintf("%s", d.Dep)
-	} else {
-		return fmt.Sprintf("%s %d %s", d.Use, d.UseCondition, d.SubDeps)
+	vars := [][2]string{
+		{"CATEGORY", gp.Category},
+		{"PN", gp.Name},
+		{"PV", pv},
+		{"PR", pr},
+		{"PVR", pvr},
+		{"P", gp.Name + "-" + pv},
+		{"PF", gp.Name + "-" + pvr},
 	}
+	if use != nil {
+		vars = append(vars, [2]string{"USE", strings.Join(use, " ")})
+	}
+	var b strings.Builder
+	for _, v := range vars {
+		fmt.Fprintf(&b, "%s='%s'\n", v[0], v[1])
+	}
+	return b.String()
 }
 
-func (d *GentooDependency) GetDepsList() []*GentooDependency {
-	ans := make([]*GentooDependency, 0)
+// parseEbuildPath returns the Gentoo package of an ebuild from its path:
+// <tree>/<category>/<package>/<pf>.ebuild
+func parseEbuildPath(path string) (*_gentoo.GentooPackage, error) {
+	pkgstr := filepath.Base(path)
+	paths := strings.Split(filepath.Dir(path), "/")
+	pkgstr = paths[len(paths)-2] + "/" + strings.Replace(pkgstr, ".ebuild", "", -1)
 
-	if len(d.SubDeps) > 0 {
-		for _, d2 := range d.SubDeps {
-			list := d2.GetDepsList()
-			ans = append(ans, list...)
-		}
+	gp, err := _gentoo.ParsePackageStr(pkgstr)
+	if err != nil {
+		return nil, errors.New("Error on parsing package string")
 	}
+	return gp, nil
+}
 
-	if d.Dep != nil {
-		ans = append(ans, d)
-	}
+// ebuildAtom returns the exact atom (=cat/pkg-version) of an ebuild.
+func ebuildAtom(gp *_gentoo.GentooPackage) string {
+	return "=" + gp.GetPackageName() + "-" + gp.Version + gp.VersionSuffix
+}
 
-	return ans
+// ScanEbuild returns a list of packages (always one with SimpleEbuildParser) decoded from an ebuild.
+func (ep *SimpleEbuildParser) ScanEbuild(path string) (pkg.Packages, error) {
+	return ep.ScanEbuildContext(context.Background(), path)
 }
 
-func (d *GentooDependency) AddSubDependency(pkg, use string) (*GentooDependency, error) {
-	ans, err := NewGentooDependency(pkg, use)
+// ScanEbuildContext is ScanEbuild with a context, that stops the source
+// of the ebuild when canceled.
+func (ep *SimpleEbuildParser) ScanEbuildContext(ctx context.Context, path string) (pkg.Packages, error) {
+	gp, vars, err := ep.sourceEbuild(ctx, path)
 	if err != nil {
-		return nil, err
+		return pkg.Packages{}, err
 	}
 
-	d.SubDeps = append(d.SubDeps, ans)
-
-	return ans, nil
+	return pkg.Packages{ep.newPackage(gp, path, vars)}, nil
 }
 
-func (r *GentooRDEPEND) GetDependencies() []*GentooDependency {
-	ans := make([]*GentooDependency, 0)
+// sourceEbuild returns the Gentoo package and the metadata
+// variables of an ebuild.
+func (ep *SimpleEbuildParser) sourceEbuild(ctx context.Context, path string) (*_gentoo.GentooPackage, map[string]expand.Variable, error) {
+	Debug("Starting parsing of ebuild", path)
 
-	for _, d := range r.Dependencies {
-		list := d.GetDepsList()
-		ans = append(ans, list...)
+	gp, err := parseEbuildPath(path)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// the same dependency could be available in multiple use flags.
-	// It's needed avoid duplicate.
-	m := make(map[string]*GentooDependency, 0)
-
-	for _, p := range ans {
-		m[p.String()] = p
+	// Some bash files can hang indefinetly
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultEbuildTimeout)
+		defer cancel()
 	}
-
-	ans = make([]*GentooDependency, 0)
-	for _, p := range m {
-		ans = append(ans, p)
+	sandbox := ep.Sandbox
+	if sandbox == nil {
+		sandbox = NewSandbox()
 	}
-
-	return ans
-}
-
-func ParseRDEPEND(rdepend string) (*GentooRDEPEND, error) {
-	var lastdep []*GentooDependency = make([]*GentooDependency, 0)
-	var pendingDep = false
-	var orDep = false
-	var dep *GentooDependency
-	var err error
-
-	ans := &GentooRDEPEND{
-		Dependencies: make([]*GentooDependency, 0),
+	var use []string
+	if ep.Profile != nil {
+		use = ep.Profile.GetPackageUse(gp)
 	}
-
-	if rdepend != "" {
-		rdepends := strings.Split(rdepend, "\n")
-		for _, rr := range rdepends {
-			rr = strings.TrimSpace(rr)
-			if rr == "" {
-				continue
-			}
-
-			if strings.HasPrefix(rr, "|| (") {
-				orDep = true
-				continue
-			}
-
-			if orDep {
-				rr = strings.TrimSpace(rr)
-				if rr == ")" {
-					orDep = false
-				}
-				continue
-			}
-
-			if strings.Index(rr, "?") > 0 {
-				// use flag present
-
-				if pendingDep {
-					dep, err = lastdep[len(lastdep)-1].AddSubDependency("", rr[:strings.Index(rr, "?")])
-					if err != nil {
-						Debug("Ignoring subdependency ", rr[:strings.Index(rr, "?")])
-					}
-				} else {
-					dep, err = NewGentooDependency("", rr[:strings.Index(rr, "?")])
-					if err != nil {
-						Debug("Ignoring dep", rr)
-					} else {
-						ans.Dependencies = append(ans.Dependencies, dep)
-					}
-				}
-
-				if strings.Index(rr, ")") < 0 {
-					pendingDep = true
-					lastdep = append(lastdep, dep)
-				}
-
-				if strings.Index(rr, "|| (") >= 0 {
-					// Ignore dep in or
-					continue
-				}
-
-				fields := strings.Split(rr[strings.Index(rr, "?")+1:], " ")
-				for _, f := range fields {
-					f = strings.TrimSpace(f)
-					if f == ")" || f == "(" || f == "" {
-						continue
-					}
-
-					_, err = dep.AddSubDependency(f, "")
-					if err != nil {
-						Debug("Ignoring subdependency ", f)
-					}
-				}
-
-			} else if pendingDep {
-				fields := strings.Split(rr, " ")
-				for _, f := range fields {
-					f = strings.TrimSpace(f)
-					if f == ")" || f == "(" || f == "" {
-						continue
-					}
-					_, err = lastdep[len(lastdep)-1].AddSubDependency(f, "")
-					if err != nil {
-						return nil, err
-					}
-				}
-
-				if strings.Index(rr, ")") >= 0 {
-					lastdep = lastdep[:len(lastdep)-1]
-					if len(lastdep) == 0 {
-						pendingDep = false
-					}
-				}
-
-			} else {
-				rr = strings.TrimSpace(rr)
-				// Check if there multiple deps in single row
-
-				fields := strings.Split(rr, " ")
-				if len(fields) > 1 {
-					for _, rrr := range fields {
-						rrr = strings.TrimSpace(rrr)
-						if rrr == "" {
-							continue
-						}
-						dep, err := NewGentooDependency(rrr, "")
-						if err != nil {
-							Debug("Ignoring dep", rr)
-						} else {
-							ans.Dependencies = append(ans.Dependencies, dep)
-						}
-					}
-				} else {
-					dep, err := NewGentooDependency(rr, "")
-					if err != nil {
-						Debug("Ignoring dep", rr)
-					} else {
-						ans.Dependencies = append(ans.Dependencies, dep)
-					}
-				}
-			}
-
+	vars, err := sandbox.sourceFile(ctx, path, ebuildVars(gp, use), EclassDirs(path, ep.Overlays))
+	if err != nil {
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			err = ErrEbuildTimeout
+		case context.Canceled:
+			return nil, nil, ctx.Err()
 		}
-
+		Error("Error on source file ", gp.Name, ": ", err)
+		return nil, nil, err
 	}
 
-	return ans, nil
+	return gp, vars, nil
 }
 
-func SourceFile(ctx context.Context, path string, pkg *_gentoo.GentooPackage) (map[string]expand.Variable, error) {
-	content, err := ioutil.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("could not open: %v", err)
-	}
-	scontent := string(content)
-
-	// Add default Genoo Variables
-	ebuild := fmt.Sprintf("P=%s\n", pkg.GetP()) +
-		fmt.Sprintf("PN=%s\n", pkg.GetPN()) +
-		fmt.Sprintf("PV=%s\n", pkg.GetPV()) +
-		fmt.Sprintf("PVR=%s\n", pkg.GetPVR())
-
-	// Disable inherit
-	scontent = strings.ReplaceAll(scontent, "inherit", "#inherit")
-	// Disable function from eclass (TODO: check how fix better this)
-	scontent = strings.ReplaceAll(scontent, "need_apache", "#need_apache")
-	scontent = strings.ReplaceAll(scontent, "want_apache", "#want_apache")
-
-	regexFuncs := regexp.MustCompile(
-		"[a-zA-Z]+.*[_][a-z]+[(][)][\\s]{",
-	)
-	matches := regexFuncs.FindAllIndex([]byte(scontent), -1)
-	// Drop section after functions (src_*, *() {)
-	if len(matches) > 0 {
-		ebuild = ebuild + scontent[:matches[0][0]]
-	} else {
-		ebuild = ebuild + scontent
-	}
-
-	// [[ ${PV} == "9999" ]] is not supported. Workaround but we need a better solution.
-	regexDoubleBrakets := regexp.MustCompile(
-		//"[[][[].*",
-		"^[[][[].*",
-		//"^.*\[\[.*\]\]",
-	)
-	matchDB := regexDoubleBrakets.FindAllIndex([]byte(ebuild), -1)
-	if len(matchDB) > 0 {
-		ebuild = ebuild[:matchDB[0][0]] + "#" + ebuild[matchDB[0][0]:]
-	}
-
-	//fmt.Println("EBUILD ", ebuild)
-
-	file, err := syntax.NewParser().Parse(strings.NewReader(ebuild), path)
-	if err != nil {
-		return nil, fmt.Errorf("could not parse: %v", err)
-	}
-	return shell.SourceNode(ctx, file)
+// newPackage returns the package of an ebuild from its metadata variables.
+func (ep *SimpleEbuildParser) newPackage(gp *_gentoo.GentooPackage, path string, vars map[string]expand.Variable) *pkg.DefaultPackage {
+	return ep.newVariantPackage(gp, path, vars, nil)
 }
 
-// ScanEbuild returns a list of packages (always one with SimpleEbuildParser) decoded from an ebuild.
-func (ep *SimpleEbuildParser) ScanEbuild(path string) (pkg.Packages, error) {
-	Debug("Starting parsing of ebuild", path)
-
-	pkgstr := filepath.Base(path)
-	paths := strings.Split(filepath.Dir(path), "/")
-	pkgstr = paths[len(paths)-2] + "/" + strings.Replace(pkgstr, ".ebuild", "", -1)
-
-	gp, err := _gentoo.ParsePackageStr(pkgstr)
+// newVariantPackage returns the package of a variant of an ebuild, or
+// of the ebuild if the variant is nil.
+func (ep *SimpleEbuildParser) newVariantPackage(gp *_gentoo.GentooPackage, path string, vars map[string]expand.Variable, variant *Variant) *pkg.DefaultPackage {
+	version, err := TranslateVersion(gp.Version + gp.VersionSuffix)
 	if err != nil {
-		return pkg.Packages{}, errors.New("Error on parsing package string")
+		Warning("Error on translating the version of", path, err.Error())
+		version = gp.Version + gp.VersionSuffix
 	}
 
 	pack := &pkg.DefaultPackage{
 		Name:     gp.Name,
-		Version:  fmt.Sprintf("%s%s", gp.Version, gp.VersionSuffix),
+		Version:  version,
 		Category: gp.Category,
 		Uri:      make([]string, 0),
 	}
 
 	Debug("Prepare package ", pack.Category+"/"+pack.Name+"-"+pack.Version)
-
-	// Adding a timeout of 60secs, as with some bash files it can hang indefinetly
-	timeout, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
-	vars, err := SourceFile(timeout, path, gp)
-	if err != nil {
-		Error("Error on source file ", pack.Name, ": ", err)
-		return pkg.Packages{}, err
-	}
+	pack.AddAnnotation(GentooAtomAnnotation, ebuildAtom(gp))
 
 	// Retrieve slot
 	slot, ok := vars["SLOT"]
-	if ok && slot.String() != "0" {
-		pack.SetCategory(fmt.Sprintf("%s-%s", gp.Category, slot.String()))
+	if ok {
+		pack.AddAnnotation(GentooSlotAnnotation, slot.String())
+		pack.Category, pack.Name = ep.SlotStrategy.Map(gp.Category, gp.Name, slot.String())
+	}
+
+	keywords, ok := vars["KEYWORDS"]
+	if ok {
+		pack.AddAnnotation(GentooKeywordsAnnotation, strings.Join(strings.Fields(keywords.String()), " "))
 	}
 
-	// TODO: Handle this a bit better
+	inherited, ok := vars["INHERITED"]
+	if ok && strings.TrimSpace(inherited.String()) != "" {
+		pack.AddAnnotation(GentooEclassesAnnotation, strings.Join(strings.Fields(inherited.String()), " "))
+	}
+
+	var uses []string
 	iuse, ok := vars["IUSE"]
 	if ok {
-		uses := strings.Split(strings.TrimSpace(iuse.String()), " ")
+		uses = strings.Fields(iuse.String())
 		for _, u := range uses {
 			pack.AddUse(u)
 		}
 	}
 
+	// Without a profile every use conditional is evaluated as true
+	var useFlags map[string]bool
+	if ep.Profile != nil {
+		useFlags = ep.Profile.GetUseFlags(gp, uses)
+	}
+	if variant != nil {
+		if useFlags == nil {
+			useFlags = NewConversionProfile().GetUseFlags(gp, uses)
+		}
+		applyUse(useFlags, variant.Use)
+		pack.AddAnnotation(GentooVariantAnnotation, strings.Join(variant.Use, " "))
+
+		// The variants can replace the package in the dependencies
+		if variant.Suffix != "" {
+			pack.Provides = append(pack.Provides, &pkg.DefaultPackage{
+				Category: pack.Category,
+				Name:     pack.Name,
+				Version:  pack.Version,
+			})
+			pack.Name += "-" + variant.Suffix
+		}
+	}
+
 	// Retrieve package description
 	descr, ok := vars["DESCRIPTION"]
 	if ok {
@@ -380,68 +287,41 @@ func (ep *SimpleEbuildParser) ScanEbuild(path string) (pkg.Packages, error) {
 	}
 	uri, ok := vars["SRC_URI"]
 	if ok {
-		// TODO: handle mirror:
-		uris := strings.Split(uri.String(), "\n")
+		uris := ep.parseSrcURI(gp, path, uri.String(), useFlags)
 		for _, u := range uris {
-			u = strings.TrimSpace(u)
-
-			if u == "" {
-				continue
-			}
-			if match, _ := regexp.Match(uriRegex, []byte(u)); match {
-				if strings.Index(u, "(") >= 0 {
-					regexUri := regexp.MustCompile("(http|ftp|mirror).*[ ]")
-					matches := regexUri.FindAllIndex([]byte(u), -1)
-					if len(matches) > 0 {
-						u = u[matches[0][0]:matches[0][1]]
-					} else {
-						continue
-					}
-				}
-				pack.AddURI(u)
-				Debug("Add uri ", u)
-			} else {
-				Debug("Skip uri ", u)
-			}
+			pack.AddURI(u.URI)
+			Debug("Add uri ", u.URI)
+		}
+		if len(uris) > 0 {
+			pack.AddAnnotation(GentooDistfilesAnnotation, encodeDistfiles(uris))
 		}
 	}
 
-	rdepend, ok := vars["RDEPEND"]
-	if ok {
-		gRDEPEND, err := ParseRDEPEND(rdepend.String())
-		if err != nil {
-			Warning("Error on parsing RDEPEND for package ", pack.Category+"/"+pack.Name, err)
-			return pkg.Packages{pack}, nil
-			//	return pkg.Packages{}, err
-		}
+	var rebuild []string
+	pack.PackageRequires, pack.PackageConflicts, rebuild = ep.parseDependVars(gp, vars, RuntimeDependVars, useFlags)
 
-		pack.PackageConflicts = []*pkg.DefaultPackage{}
-		pack.PackageRequires = []*pkg.DefaultPackage{}
-
-		// TODO: See how handle use flags enabled.
-		// and if it's correct get list of deps directly.
-		for _, d := range gRDEPEND.GetDependencies() {
-
-			//TODO: Resolve to db or create a new one.
-			//TODO: handle SLOT too.
-			dep := &pkg.DefaultPackage{
-				Name:     d.Dep.Name,
-				Version:  d.Dep.Version + d.Dep.VersionSuffix,
-				Category: d.Dep.Category,
-			}
-			Debug(fmt.Sprintf("For package %s found dep: %s/%s %s",
-				gp, dep.Category, dep.Name, dep.Version))
-			if d.Dep.Condition == _gentoo.PkgCondNot {
-				pack.PackageConflicts = append(pack.PackageConflicts, dep)
-			} else {
-				pack.PackageRequires = append(pack.PackageRequires, dep)
-			}
+	buildRequires, buildConflicts, buildRebuild := ep.parseDependVars(gp, vars, BuildDependVars, useFlags)
+	common.SetBuildRequires(pack, buildRequires)
+	common.SetBuildConflicts(pack, buildConflicts)
 
+	if rebuild = mergeSlotRebuild(rebuild, buildRebuild); len(rebuild) > 0 {
+		pack.AddLabel(SlotRebuildLabel, strings.Join(rebuild, " "))
+	}
+
+	if gp.Category == VirtualCategory {
+		if providers := ep.virtualProviders(gp, vars, useFlags); len(providers) > 0 {
+			pack.AddAnnotation(GentooProvidersAnnotation, common.EncodeDeps(providers))
 		}
+	}
 
+	if metadata, err := LoadPackageMetadata(filepath.Join(filepath.Dir(path), PackageMetadataFile)); err == nil {
+		metadata.AddLabels(pack)
+	} else {
+		Debug("No metadata.xml for", path)
 	}
-	Debug("Finished processing ebuild", path, "deps ", len(pack.PackageRequires))
 
-	//TODO: Deps and conflicts
-	return pkg.Packages{pack}, nil
+	Debug("Finished processing ebuild", path, "deps ", len(pack.PackageRequires),
+		"build deps ", len(buildRequires))
+
+	return pack
 }
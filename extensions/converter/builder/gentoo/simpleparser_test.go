@@ -666,4 +666,88 @@ var _ = Describe("GentooBuilder", func() {
 		}
 	})
 
+	Context("Parse RDEPEND with any-of groups", func() {
+
+		rdepend := `
+	app-crypt/sbsigntools
+	|| ( sys-fs/fuse sys-apps/pmount )
+	gtk? (
+		|| (
+			x11-libs/gtk+:3
+			x11-libs/gtk+:2
+		)
+	)
+`
+		gr, err := ParseRDEPEND(rdepend)
+		It("Check error", func() {
+			Expect(err).Should(BeNil())
+		})
+
+		It("Check deps #", func() {
+			Expect(len(gr.Dependencies)).Should(Equal(3))
+			Expect(len(gr.GetDependencies())).Should(Equal(1))
+			Expect(len(gr.GetAnyOfDependencies())).Should(Equal(2))
+		})
+
+		It("Check any-of group", func() {
+			Expect(gr.Dependencies[1].Group).Should(Equal(GroupAnyOf))
+			Expect(len(gr.Dependencies[1].SubDeps)).Should(Equal(2))
+			Expect(gr.Dependencies[1].SubDeps[0].Dep.Name).Should(Equal("fuse"))
+			Expect(gr.Dependencies[1].SubDeps[1].Dep.Name).Should(Equal("pmount"))
+		})
+
+		It("Check any-of group in use conditional", func() {
+			Expect(gr.Dependencies[2].Use).Should(Equal("gtk"))
+			Expect(len(gr.Dependencies[2].SubDeps)).Should(Equal(1))
+			group := gr.Dependencies[2].SubDeps[0]
+			Expect(group.Group).Should(Equal(GroupAnyOf))
+			Expect(len(group.SubDeps)).Should(Equal(2))
+			Expect(group.SubDeps[0].Dep.Slot).Should(Equal("3"))
+			Expect(group.SubDeps[1].Dep.Slot).Should(Equal("2"))
+		})
+	})
+
+	Context("Parse RDEPEND with nested use conditionals in any-of groups", func() {
+
+		rdepend := `
+	|| ( gnome-keyring? ( app-crypt/pinentry-gnome ) app-crypt/pinentry-gtk2 )
+	|| (
+		qt5? (
+			app-crypt/pinentry-qt5
+			dev-qt/qtgui:5
+		)
+		!qt5? ( app-crypt/pinentry-base )
+	)
+`
+		gr, err := ParseRDEPEND(rdepend)
+		It("Check error", func() {
+			Expect(err).Should(BeNil())
+		})
+
+		It("Check deps #", func() {
+			Expect(len(gr.Dependencies)).Should(Equal(2))
+			Expect(len(gr.GetDependencies())).Should(Equal(0))
+		})
+
+		It("Check first group", func() {
+			group := gr.Dependencies[0]
+			Expect(group.Group).Should(Equal(GroupAnyOf))
+			Expect(len(group.SubDeps)).Should(Equal(2))
+			Expect(group.SubDeps[0].Use).Should(Equal("gnome-keyring"))
+			Expect(group.SubDeps[0].SubDeps[0].Dep.Name).Should(Equal("pinentry-gnome"))
+			Expect(group.SubDeps[1].Dep.Name).Should(Equal("pinentry-gtk2"))
+		})
+
+		It("Check second group", func() {
+			group := gr.Dependencies[1]
+			Expect(group.Group).Should(Equal(GroupAnyOf))
+			Expect(len(group.SubDeps)).Should(Equal(2))
+			Expect(group.SubDeps[0].Use).Should(Equal("qt5"))
+			Expect(len(group.SubDeps[0].SubDeps)).Should(Equal(2))
+			Expect(group.SubDeps[1].Use).Should(Equal("qt5"))
+			Expect(group.SubDeps[1].UseCondition).Should(BeEquivalentTo(_gentoo.PkgCondNot))
+			Expect(group.SubDeps[1].SubDeps[0].Dep.Name).Should(Equal("pinentry-base"))
+		})
+	})
+
 })
@@ -0,0 +1,79 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SlotRebuildLabel is the package label with the dependencies that need a
+// rebuild of the package when their sub-slot changes (slot operator :=),
+// as space separated cat/name:slot entries.
+const SlotRebuildLabel = "slot_rebuild"
+
+// SlotStrategy defines how the SLOT of an ebuild is mapped on the
+// category and the name of the luet package.
+type SlotStrategy int
+
+const (
+	// SlotInCategory appends the slot to the category (cat-SLOT/name),
+	// as done by migrate-entropy.
+	SlotInCategory SlotStrategy = iota
+	// SlotInName appends the slot to the name (cat/name-SLOT).
+	SlotInName
+	// SlotIgnored doesn't map the slot.
+	SlotIgnored
+)
+
+// ParseSlotStrategy returns the SlotStrategy from its name (category, name, none).
+func ParseSlotStrategy(s string) (SlotStrategy, error) {
+	switch s {
+	case "", "category":
+		return SlotInCategory, nil
+	case "name":
+		return SlotInName, nil
+	case "none":
+		return SlotIgnored, nil
+	}
+	return SlotInCategory, fmt.Errorf("invalid slot strategy %s", s)
+}
+
+// MainSlot returns the slot without the sub-slot and the slot operator.
+func MainSlot(slot string) string {
+	slot = strings.TrimRight(slot, "=*")
+	if i := strings.Index(slot, "/"); i >= 0 {
+		slot = slot[:i]
+	}
+	return slot
+}
+
+// Map returns the category and the name of the luet package
+// of a Gentoo package with the given slot.
+func (s SlotStrategy) Map(category, name, slot string) (string, string) {
+	slot = MainSlot(slot)
+	if slot == "" || slot == "0" {
+		return category, name
+	}
+
+	switch s {
+	case SlotInCategory:
+		return category + "-" + slot, name
+	case SlotInName:
+		return category, name + "-" + slot
+	}
+	return category, name
+}
@@ -0,0 +1,117 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mudler/luet/pkg/tree/builder/common"
+	. "github.com/mudler/luet/pkg/tree/builder/gentoo"
+)
+
+var _ = Describe("Slot", func() {
+
+	It("Maps the slot", func() {
+		for _, c := range []struct {
+			strategy       SlotStrategy
+			slot           string
+			category, name string
+		}{
+			{SlotInCategory, "0", "dev-lang", "python"},
+			{SlotInCategory, "", "dev-lang", "python"},
+			{SlotInCategory, "3.9", "dev-lang-3.9", "python"},
+			{SlotInCategory, "3.9/3.9.1", "dev-lang-3.9", "python"},
+			{SlotInCategory, "3.9=", "dev-lang-3.9", "python"},
+			{SlotInCategory, "0/1", "dev-lang", "python"},
+			{SlotInCategory, "*", "dev-lang", "python"},
+			{SlotInName, "3.9/3.9.1", "dev-lang", "python-3.9"},
+			{SlotIgnored, "3.9", "dev-lang", "python"},
+		} {
+			category, name := c.strategy.Map("dev-lang", "python", c.slot)
+			Expect(category).Should(Equal(c.category), "%v", c)
+			Expect(name).Should(Equal(c.name), "%v", c)
+		}
+	})
+
+	It("Parses the slot strategy", func() {
+		s, err := ParseSlotStrategy("name")
+		Expect(err).Should(BeNil())
+		Expect(s).Should(Equal(SlotInName))
+		_, err = ParseSlotStrategy("version")
+		Expect(err).ShouldNot(BeNil())
+	})
+
+	Context("Ebuilds", func() {
+		var tmpdir, ebuild string
+
+		BeforeEach(func() {
+			var err error
+			tmpdir, err = ioutil.TempDir("", "slot")
+			Expect(err).Should(BeNil())
+
+			ebuild = filepath.Join(tmpdir, "dev-python", "foo", "foo-1.0.ebuild")
+			Expect(os.MkdirAll(filepath.Dir(ebuild), os.ModePerm)).Should(BeNil())
+			Expect(ioutil.WriteFile(ebuild, []byte(`EAPI=7
+SLOT="3/3.1"
+RDEPEND="dev-lang/python:3.9= dev-libs/bar:2/2.1 >=dev-libs/baz-1.0:0="
+DEPEND="${RDEPEND} dev-util/qux:*"
+`), 0644)).Should(BeNil())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tmpdir)
+		})
+
+		It("Maps the package and the dependencies", func() {
+			parser := &SimpleEbuildParser{}
+			pkgs, err := parser.ScanEbuild(ebuild)
+			Expect(err).Should(BeNil())
+			Expect(len(pkgs)).Should(Equal(1))
+
+			p := pkgs[0]
+			Expect(p.GetCategory()).Should(Equal("dev-python-3"))
+			Expect(p.GetName()).Should(Equal("foo"))
+			Expect(p.GetAnnotations()[GentooSlotAnnotation]).Should(Equal("3/3.1"))
+
+			requires := []string{}
+			for _, r := range p.GetRequires() {
+				requires = append(requires, r.GetCategory()+"/"+r.GetName())
+			}
+			Expect(requires).Should(ConsistOf("dev-lang-3.9/python", "dev-libs-2/bar", "dev-libs/baz"))
+
+			buildRequires := []string{}
+			for _, r := range common.GetBuildRequires(p) {
+				buildRequires = append(buildRequires, r.GetCategory()+"/"+r.GetName())
+			}
+			Expect(buildRequires).Should(ContainElement("dev-util/qux"))
+
+			Expect(p.GetLabels()[SlotRebuildLabel]).Should(Equal("dev-lang/python:3.9 dev-libs/baz:0"))
+		})
+
+		It("Maps the slot on the name", func() {
+			parser := &SimpleEbuildParser{SlotStrategy: SlotInName}
+			pkgs, err := parser.ScanEbuild(ebuild)
+			Expect(err).Should(BeNil())
+			Expect(pkgs[0].GetCategory()).Should(Equal("dev-python"))
+			Expect(pkgs[0].GetName()).Should(Equal("foo-3"))
+		})
+	})
+})
@@ -0,0 +1,247 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	. "github.com/mudler/luet/pkg/logger"
+
+	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
+	pkg "github.com/mudler/luet/pkg/package"
+)
+
+const (
+	// ThirdPartyMirrorsFile is the path of the mirrors file in a Gentoo tree.
+	ThirdPartyMirrorsFile = "profiles/thirdpartymirrors"
+	// DefaultGentooMirror is used to resolve mirror://gentoo/ URIs.
+	DefaultGentooMirror = "https://distfiles.gentoo.org/distfiles"
+)
+
+// SrcURI is an entry of SRC_URI.
+type SrcURI struct {
+	URI string
+	// FileName is the name of the distfile: the target of the
+	// -> operator or the last element of the URI.
+	FileName string
+}
+
+// Mirrors maps the name of a mirror to the list of its URLs,
+// as defined in profiles/thirdpartymirrors.
+type Mirrors map[string][]string
+
+// LoadThirdPartyMirrors parses a thirdpartymirrors file.
+func LoadThirdPartyMirrors(file string) (Mirrors, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ans := make(Mirrors)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ans[fields[0]] = append(ans[fields[0]], fields[1:]...)
+	}
+
+	return ans, scanner.Err()
+}
+
+// Resolve expands a mirror:// URI with all the URLs of the mirror.
+// The other URIs and the URIs of unknown mirrors are returned as is.
+func (m Mirrors) Resolve(uri string) []string {
+	if !strings.HasPrefix(uri, "mirror://") {
+		return []string{uri}
+	}
+
+	s := strings.TrimPrefix(uri, "mirror://")
+	i := strings.Index(s, "/")
+	if i < 0 {
+		return []string{uri}
+	}
+	name, file := s[:i], s[i+1:]
+
+	bases, ok := m[name]
+	if !ok && name == "gentoo" {
+		bases = []string{DefaultGentooMirror}
+	}
+	if len(bases) == 0 {
+		Debug("Unknown mirror", name, "for uri", uri)
+		return []string{uri}
+	}
+
+	ans := make([]string, 0, len(bases))
+	for _, b := range bases {
+		ans = append(ans, strings.TrimSuffix(b, "/")+"/"+file)
+	}
+	return ans
+}
+
+// ParseSrcURI parses the SRC_URI variable. The use conditionals are
+// evaluated with the given USE flags, if use is nil they are all included.
+func ParseSrcURI(srcuri string, use map[string]bool) ([]*SrcURI, error) {
+	ans := []*SrcURI{}
+	// enabled state of the open groups
+	stack := []bool{true}
+	var last *SrcURI
+
+	tokens := tokenizeDepend(srcuri)
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		enabled := stack[len(stack)-1]
+
+		switch t.Type {
+		case tokenUse:
+			if i+1 >= len(tokens) || tokens[i+1].Type != tokenOpen {
+				return nil, fmt.Errorf("expected ( after %s?", t.Value)
+			}
+			i++
+			if use != nil {
+				flag := strings.TrimPrefix(t.Value, "!")
+				cond := use[flag]
+				if strings.HasPrefix(t.Value, "!") {
+					cond = !cond
+				}
+				enabled = enabled && cond
+			}
+			stack = append(stack, enabled)
+
+		case tokenOpen:
+			stack = append(stack, enabled)
+
+		case tokenClose:
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("unexpected ) at token %d", i)
+			}
+			stack = stack[:len(stack)-1]
+
+		case tokenGroup:
+			return nil, fmt.Errorf("unexpected %s in SRC_URI", t.Value)
+
+		default:
+			if t.Value == "->" {
+				if i+1 >= len(tokens) || tokens[i+1].Type != tokenAtom {
+					return nil, fmt.Errorf("missing file name after ->")
+				}
+				i++
+				if enabled && last != nil {
+					last.FileName = tokens[i].Value
+				}
+				continue
+			}
+
+			last = nil
+			if !enabled {
+				continue
+			}
+			last = &SrcURI{URI: t.Value, FileName: path.Base(t.Value)}
+			ans = append(ans, last)
+		}
+	}
+
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("missing )")
+	}
+
+	return ans, nil
+}
+
+// getMirrors returns the mirrors of the tree of the ebuild, loaded
+// from profiles/thirdpartymirrors only the first time.
+func (ep *SimpleEbuildParser) getMirrors(ebuild string) Mirrors {
+	root := treeRoot(ebuild)
+
+	ep.mirrorsMutex.Lock()
+	defer ep.mirrorsMutex.Unlock()
+
+	if ep.mirrors == nil {
+		ep.mirrors = make(map[string]Mirrors)
+	}
+	if m, ok := ep.mirrors[root]; ok {
+		return m
+	}
+
+	m, err := LoadThirdPartyMirrors(filepath.Join(root, ThirdPartyMirrorsFile))
+	if err != nil {
+		Debug("No mirrors available for tree", root, err.Error())
+		m = make(Mirrors)
+	}
+	ep.mirrors[root] = m
+
+	return m
+}
+
+// parseSrcURI returns the resolved list of URIs of the package,
+// with the name of their distfile.
+func (ep *SimpleEbuildParser) parseSrcURI(gp *_gentoo.GentooPackage, ebuild, srcuri string, use map[string]bool) []*SrcURI {
+	ans := []*SrcURI{}
+
+	uris, err := ParseSrcURI(srcuri, use)
+	if err != nil {
+		Warning("Error on parsing SRC_URI for package ", gp.Category+"/"+gp.Name, err)
+		return ans
+	}
+
+	mirrors := ep.getMirrors(ebuild)
+	for _, u := range uris {
+		if !strings.Contains(u.URI, "://") {
+			Debug("Skip uri ", u.URI)
+			continue
+		}
+		for _, r := range mirrors.Resolve(u.URI) {
+			ans = append(ans, &SrcURI{URI: r, FileName: u.FileName})
+		}
+	}
+
+	return ans
+}
+
+// encodeDistfiles serializes the URIs with the SRC_URI syntax
+// (uri -> filename), suitable for a package annotation.
+func encodeDistfiles(uris []*SrcURI) string {
+	entries := make([]string, 0, len(uris))
+	for _, u := range uris {
+		entries = append(entries, u.URI+" -> "+u.FileName)
+	}
+	return strings.Join(entries, " ")
+}
+
+// GetDistfiles returns the name of the distfile of every URI of a
+// converted ebuild, from GentooDistfilesAnnotation.
+func GetDistfiles(p pkg.Package) map[string]string {
+	ans := make(map[string]string)
+	uris, err := ParseSrcURI(p.GetAnnotations()[GentooDistfilesAnnotation], nil)
+	if err != nil {
+		return ans
+	}
+	for _, u := range uris {
+		ans[u.URI] = u.FileName
+	}
+	return ans
+}
@@ -0,0 +1,115 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/mudler/luet/pkg/tree/builder/gentoo"
+)
+
+var _ = Describe("SRC_URI parser", func() {
+
+	Context("Renames and use conditionals", func() {
+		srcuri := `https://example.org/v1.0.tar.gz -> foo-1.0.tar.gz
+	doc? ( mirror://sourceforge/foo/foo-doc-1.0.tar.xz )
+	!doc? ( https://example.org/nodoc.zip )`
+
+		It("Includes all the entries without use flags", func() {
+			uris, err := ParseSrcURI(srcuri, nil)
+			Expect(err).Should(BeNil())
+			Expect(len(uris)).Should(Equal(3))
+			Expect(uris[0].URI).Should(Equal("https://example.org/v1.0.tar.gz"))
+			Expect(uris[0].FileName).Should(Equal("foo-1.0.tar.gz"))
+			Expect(uris[1].FileName).Should(Equal("foo-doc-1.0.tar.xz"))
+		})
+
+		It("Evaluates the use conditionals", func() {
+			uris, err := ParseSrcURI(srcuri, map[string]bool{"doc": false})
+			Expect(err).Should(BeNil())
+			Expect(len(uris)).Should(Equal(2))
+			Expect(uris[1].URI).Should(Equal("https://example.org/nodoc.zip"))
+		})
+
+		It("Fails on unbalanced groups", func() {
+			_, err := ParseSrcURI("doc? ( https://example.org/a.zip", nil)
+			Expect(err).ShouldNot(BeNil())
+			_, err = ParseSrcURI("https://example.org/a.zip ->", nil)
+			Expect(err).ShouldNot(BeNil())
+		})
+	})
+
+	Context("Mirrors", func() {
+		mirrors := Mirrors{
+			"sourceforge": []string{"https://downloads.sourceforge.net/", "https://a.example.org"},
+		}
+
+		It("Expands the known mirrors", func() {
+			Expect(mirrors.Resolve("mirror://sourceforge/foo/foo.tar.gz")).Should(Equal([]string{
+				"https://downloads.sourceforge.net/foo/foo.tar.gz",
+				"https://a.example.org/foo/foo.tar.gz",
+			}))
+			Expect(mirrors.Resolve("mirror://gentoo/foo.tar.gz")).Should(Equal([]string{
+				DefaultGentooMirror + "/foo.tar.gz",
+			}))
+			Expect(mirrors.Resolve("mirror://unknown/foo.tar.gz")).Should(Equal([]string{
+				"mirror://unknown/foo.tar.gz",
+			}))
+		})
+
+		It("Resolves the uris of an ebuild with the mirrors of its tree", func() {
+			tmpdir, err := ioutil.TempDir("", "srcuri")
+			Expect(err).Should(BeNil())
+			defer os.RemoveAll(tmpdir)
+
+			Expect(os.MkdirAll(filepath.Join(tmpdir, "profiles"), os.ModePerm)).Should(BeNil())
+			Expect(ioutil.WriteFile(filepath.Join(tmpdir, ThirdPartyMirrorsFile), []byte(`# comment
+gnupg	https://gnupg.org/ftp/gcrypt https://mirror.example.org/gnupg
+`), 0644)).Should(BeNil())
+
+			ebuildDir := filepath.Join(tmpdir, "app-misc", "foo")
+			Expect(os.MkdirAll(ebuildDir, os.ModePerm)).Should(BeNil())
+			ebuild := filepath.Join(ebuildDir, "foo-1.0.ebuild")
+			Expect(ioutil.WriteFile(ebuild, []byte(`EAPI=7
+IUSE="doc"
+SRC_URI="mirror://gnupg/${PN}/${P}.tar.bz2
+	https://github.com/foo/foo/archive/v${PV}.tar.gz -> ${P}-gh.tar.gz
+	doc? ( https://example.org/${P}-doc.tar.gz )"
+SLOT="0"
+`), 0644)).Should(BeNil())
+
+			parser := &SimpleEbuildParser{Profile: &ConversionProfile{Use: []string{"-doc"}}}
+			pkgs, err := parser.ScanEbuild(ebuild)
+			Expect(err).Should(BeNil())
+			Expect(len(pkgs)).Should(Equal(1))
+			Expect(pkgs[0].GetURI()).Should(Equal([]string{
+				"https://gnupg.org/ftp/gcrypt/foo/foo-1.0.tar.bz2",
+				"https://mirror.example.org/gnupg/foo/foo-1.0.tar.bz2",
+				"https://github.com/foo/foo/archive/v1.0.tar.gz",
+			}))
+			Expect(GetDistfiles(pkgs[0])).Should(Equal(map[string]string{
+				"https://gnupg.org/ftp/gcrypt/foo/foo-1.0.tar.bz2":     "foo-1.0.tar.bz2",
+				"https://mirror.example.org/gnupg/foo/foo-1.0.tar.bz2": "foo-1.0.tar.bz2",
+				"https://github.com/foo/foo/archive/v1.0.tar.gz":       "foo-1.0-gh.tar.gz",
+			}))
+		})
+	})
+})
@@ -0,0 +1,200 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	. "github.com/mudler/luet/pkg/logger"
+
+	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
+	pkg "github.com/mudler/luet/pkg/package"
+)
+
+// MaxVariantFlags is the maximum number of flags of a VariantRule,
+// as every combination of them is a package.
+const MaxVariantFlags = 8
+
+// VariantRule expands the ebuilds matching Atom in a package for every
+// value of the UseExpand group available in IUSE (e.g. PYTHON_TARGETS)
+// and for every combination of the Flags.
+type VariantRule struct {
+	Atom string `yaml:"atom" json:"atom"`
+	// UseExpand is the USE_EXPAND group, Values restricts its values.
+	UseExpand string   `yaml:"use_expand,omitempty" json:"use_expand,omitempty"`
+	Values    []string `yaml:"values,omitempty" json:"values,omitempty"`
+	Flags     []string `yaml:"flags,omitempty" json:"flags,omitempty"`
+}
+
+// Variant is a combination of USE flags of an ebuild, converted
+// as a package.
+type Variant struct {
+	// Suffix is appended to the name of the package.
+	Suffix string
+	// Use are the flags enabled (flag) and disabled (-flag).
+	Use []string
+}
+
+// GetVariants returns the variants of the package with the first rule
+// that matches it, nil if there aren't rules or if the flags of the
+// rule aren't in IUSE.
+func (p *ConversionProfile) GetVariants(gp *_gentoo.GentooPackage, iuse []string) []Variant {
+	for _, r := range p.Variants {
+		if MatchAtom(r.Atom, gp) {
+			return r.variants(iuse)
+		}
+	}
+	return nil
+}
+
+func (r *VariantRule) variants(iuse []string) []Variant {
+	available := make(map[string]bool)
+	for _, u := range iuse {
+		available[strings.TrimLeft(u, "+-")] = true
+	}
+
+	// Start with a variant without flags and combine it with every value
+	// of the USE_EXPAND group and with every flag.
+	ans := []Variant{{}}
+
+	if r.UseExpand != "" {
+		prefix := strings.ToLower(r.UseExpand) + "_"
+		values := []string{}
+		for u := range available {
+			if !strings.HasPrefix(u, prefix) {
+				continue
+			}
+			v := strings.TrimPrefix(u, prefix)
+			if len(r.Values) == 0 || contains(r.Values, v) {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			return nil
+		}
+		sort.Strings(values)
+
+		expanded := []Variant{}
+		for _, v := range values {
+			use := []string{}
+			for _, other := range values {
+				if other == v {
+					use = append(use, prefix+other)
+				} else {
+					use = append(use, "-"+prefix+other)
+				}
+			}
+			expanded = append(expanded, Variant{Suffix: v, Use: use})
+		}
+		ans = expanded
+	}
+
+	flags := []string{}
+	for _, f := range r.Flags {
+		if available[f] {
+			flags = append(flags, f)
+		}
+	}
+	if len(flags) > MaxVariantFlags {
+		Warning("Too many flags in the variants of", r.Atom, ", only the first", MaxVariantFlags, "are used")
+		flags = flags[:MaxVariantFlags]
+	}
+	if len(flags) == 0 && r.UseExpand == "" {
+		return nil
+	}
+
+	for _, f := range flags {
+		combined := make([]Variant, 0, 2*len(ans))
+		for _, v := range ans {
+			combined = append(combined,
+				Variant{Suffix: v.Suffix, Use: append(append([]string{}, v.Use...), "-"+f)},
+				Variant{Suffix: joinSuffix(v.Suffix, f), Use: append(append([]string{}, v.Use...), f)})
+		}
+		ans = combined
+	}
+
+	return ans
+}
+
+func joinSuffix(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "-" + b
+}
+
+func contains(l []string, s string) bool {
+	for _, i := range l {
+		if i == s {
+			return true
+		}
+	}
+	return false
+}
+
+// VariantEbuildParser converts an ebuild in a package for every variant
+// defined by the Variants of the profile, e.g. dev-python/foo-python3_8
+// for the python3_8 value of PYTHON_TARGETS. Every variant provides the
+// package of the ebuild. The ebuilds without variants are converted as
+// with the SimpleEbuildParser.
+type VariantEbuildParser struct {
+	*SimpleEbuildParser
+}
+
+// NewVariantEbuildParser returns a VariantEbuildParser that uses the
+// given parser to source the ebuilds.
+func NewVariantEbuildParser(p *SimpleEbuildParser) *VariantEbuildParser {
+	if p == nil {
+		p = &SimpleEbuildParser{}
+	}
+	return &VariantEbuildParser{SimpleEbuildParser: p}
+}
+
+// ScanEbuild returns a package for every variant of the ebuild.
+func (ep *VariantEbuildParser) ScanEbuild(path string) (pkg.Packages, error) {
+	return ep.ScanEbuildContext(context.Background(), path)
+}
+
+// ScanEbuildContext is ScanEbuild with a context, that stops the source
+// of the ebuild when canceled.
+func (ep *VariantEbuildParser) ScanEbuildContext(ctx context.Context, path string) (pkg.Packages, error) {
+	gp, vars, err := ep.sourceEbuild(ctx, path)
+	if err != nil {
+		return pkg.Packages{}, err
+	}
+
+	var variants []Variant
+	if ep.Profile != nil {
+		iuse := []string{}
+		if v, ok := vars["IUSE"]; ok {
+			iuse = strings.Fields(v.String())
+		}
+		variants = ep.Profile.GetVariants(gp, iuse)
+	}
+	if len(variants) == 0 {
+		return pkg.Packages{ep.newPackage(gp, path, vars)}, nil
+	}
+
+	ans := pkg.Packages{}
+	for i := range variants {
+		ans = append(ans, ep.newVariantPackage(gp, path, vars, &variants[i]))
+	}
+	Debug("Converted", len(ans), "variants of", path)
+
+	return ans, nil
+}
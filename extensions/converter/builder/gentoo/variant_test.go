@@ -0,0 +1,181 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
+
+	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
+	pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/mudler/luet/pkg/tree/builder/gentoo"
+)
+
+var _ = Describe("Variants", func() {
+	gp := &_gentoo.GentooPackage{
+		Category: "dev-python",
+		Name:     "foo",
+		Version:  "1.0",
+		Slot:     "0",
+	}
+	iuse := []string{"python_targets_python3_9", "python_targets_python3_8", "+doc", "test"}
+
+	Context("Rules", func() {
+		It("Expands the USE_EXPAND groups and the flags", func() {
+			for _, c := range []struct {
+				rule     VariantRule
+				expected []Variant
+			}{
+				{
+					rule: VariantRule{Atom: "dev-python/*", UseExpand: "PYTHON_TARGETS"},
+					expected: []Variant{
+						{Suffix: "python3_8", Use: []string{"python_targets_python3_8", "-python_targets_python3_9"}},
+						{Suffix: "python3_9", Use: []string{"-python_targets_python3_8", "python_targets_python3_9"}},
+					},
+				},
+				{
+					rule: VariantRule{Atom: "dev-python/foo", UseExpand: "PYTHON_TARGETS", Values: []string{"python3_9"}},
+					expected: []Variant{
+						{Suffix: "python3_9", Use: []string{"python_targets_python3_9"}},
+					},
+				},
+				{
+					// X isn't in IUSE
+					rule: VariantRule{Atom: "dev-python/foo", Flags: []string{"doc", "X"}},
+					expected: []Variant{
+						{Suffix: "", Use: []string{"-doc"}},
+						{Suffix: "doc", Use: []string{"doc"}},
+					},
+				},
+				{
+					rule: VariantRule{Atom: "dev-python/foo", UseExpand: "PYTHON_TARGETS", Values: []string{"python3_8"}, Flags: []string{"doc", "test"}},
+					expected: []Variant{
+						{Suffix: "python3_8", Use: []string{"python_targets_python3_8", "-doc", "-test"}},
+						{Suffix: "python3_8-test", Use: []string{"python_targets_python3_8", "-doc", "test"}},
+						{Suffix: "python3_8-doc", Use: []string{"python_targets_python3_8", "doc", "-test"}},
+						{Suffix: "python3_8-doc-test", Use: []string{"python_targets_python3_8", "doc", "test"}},
+					},
+				},
+			} {
+				p := &ConversionProfile{Variants: []VariantRule{c.rule}}
+				Expect(p.GetVariants(gp, iuse)).To(Equal(c.expected))
+			}
+		})
+
+		It("Returns no variants without a matching rule", func() {
+			for _, rule := range []VariantRule{
+				{Atom: "dev-libs/*", UseExpand: "PYTHON_TARGETS"},
+				{Atom: "dev-python/foo", UseExpand: "RUBY_TARGETS"},
+				{Atom: "dev-python/foo", Flags: []string{"X"}},
+			} {
+				p := &ConversionProfile{Variants: []VariantRule{rule}}
+				Expect(p.GetVariants(gp, iuse)).To(BeNil())
+			}
+		})
+	})
+
+	Context("Parser", func() {
+		var tmpdir string
+
+		write := func(file, content string) {
+			Expect(os.MkdirAll(filepath.Dir(file), os.ModePerm)).Should(BeNil())
+			Expect(ioutil.WriteFile(file, []byte(content), 0644)).Should(BeNil())
+		}
+
+		BeforeEach(func() {
+			var err error
+			tmpdir, err = ioutil.TempDir("", "variants")
+			Expect(err).Should(BeNil())
+
+			write(filepath.Join(tmpdir, "dev-python", "foo", "foo-1.0.ebuild"), `EAPI=7
+SLOT=0
+IUSE="python_targets_python3_8 python_targets_python3_9"
+RDEPEND="python_targets_python3_8? ( >=dev-lang/python-3.8 )
+	python_targets_python3_9? ( >=dev-lang/python-3.9 )"
+`)
+			write(filepath.Join(tmpdir, "dev-lang", "python", "python-3.8.ebuild"), "EAPI=7\nSLOT=0\n")
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tmpdir)
+		})
+
+		profile := &ConversionProfile{
+			Variants: []VariantRule{{Atom: "dev-python/*", UseExpand: "PYTHON_TARGETS"}},
+		}
+
+		It("Converts a package for every variant", func() {
+			parser := NewVariantEbuildParser(&SimpleEbuildParser{Profile: profile})
+			pkgs, err := parser.ScanEbuild(filepath.Join(tmpdir, "dev-python", "foo", "foo-1.0.ebuild"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(pkgs)).To(Equal(2))
+
+			for i, v := range []string{"3.8", "3.9"} {
+				p := pkgs[i]
+				suffix := "python" + v[:1] + "_" + v[2:]
+				Expect(p.GetName()).To(Equal("foo-" + suffix))
+				Expect(p.GetProvides()).To(Equal([]*pkg.DefaultPackage{
+					{Category: "dev-python", Name: "foo", Version: luetVersion("1.0")},
+				}))
+				Expect(len(p.GetRequires())).To(Equal(1))
+				Expect(p.GetRequires()[0].GetVersion()).To(Equal(">=" + luetVersion(v)))
+				Expect(p.GetAnnotations()[GentooVariantAnnotation]).To(ContainSubstring("python_targets_" + suffix))
+			}
+
+			// The packages without variants are converted as they are
+			pkgs, err = parser.ScanEbuild(filepath.Join(tmpdir, "dev-lang", "python", "python-3.8.ebuild"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(pkgs)).To(Equal(1))
+			Expect(pkgs[0].GetName()).To(Equal("python"))
+		})
+
+		It("Keeps the variants in the tree and in the build specs", func() {
+			gb := NewGentooBuilder(NewVariantEbuildParser(nil), 2, InMemory)
+			gb.Profile = profile
+			gb.Filter = NewPackageFilter()
+			gb.Filter.BestVersion = true
+			db, err := gb.Generate(tmpdir)
+			Expect(err).Should(BeNil())
+			defer db.Clean()
+
+			names := []string{}
+			for _, p := range db.World() {
+				names = append(names, p.GetCategory()+"/"+p.GetName())
+			}
+			sort.Strings(names)
+			Expect(names).To(Equal([]string{"dev-lang/python", "dev-python/foo-python3_8", "dev-python/foo-python3_9"}))
+
+			p, err := db.FindPackage(&pkg.DefaultPackage{Category: "dev-python", Name: "foo-python3_9", Version: luetVersion("1.0")})
+			Expect(err).Should(BeNil())
+
+			gen, err := NewBuildSpecGenerator("")
+			Expect(err).ToNot(HaveOccurred())
+			data, err := gen.Generate(p)
+			Expect(err).ToNot(HaveOccurred())
+
+			spec := &buildSpec{}
+			Expect(yaml.Unmarshal(data, spec)).ToNot(HaveOccurred())
+			Expect(spec.Prelude).To(ContainElement(
+				`echo "=dev-python/foo-1.0 -python_targets_python3_8 python_targets_python3_9" > /etc/portage/package.use/luet`))
+		})
+	})
+})
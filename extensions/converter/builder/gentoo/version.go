@@ -0,0 +1,398 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
+)
+
+// versionRegex matches a version as defined by the PMS:
+// components, letter, suffixes and revision.
+var versionRegex = regexp.MustCompile(
+	`^([0-9]+(?:\.[0-9]+)*)([a-z]?)((?:_(?:alpha|beta|pre|rc|p)[0-9]*)*)(?:-r([0-9]+))?$`)
+
+var suffixRegex = regexp.MustCompile(`_(alpha|beta|pre|rc|p)([0-9]*)`)
+
+// suffixOrder is the order of the version suffixes, the missing suffix
+// is between rc and p.
+var suffixOrder = map[string]int{"alpha": 0, "beta": 1, "pre": 2, "rc": 3, "p": 5}
+
+// maxLuetComponents is the maximum number of components of a Gentoo
+// version that can be translated, the luet version has two more.
+const maxLuetComponents = 4
+
+// maxLuetSuffixes is the maximum number of suffixes of a Gentoo version
+// that can be translated.
+const maxLuetSuffixes = 2
+
+// suffixWidths are the digits of the numbers of the suffixes in the
+// luet version.
+var suffixWidths = []int{8, 6}
+
+// VersionSuffix is a suffix of a Gentoo version, e.g. _rc1.
+type VersionSuffix struct {
+	Name   string
+	Number string
+}
+
+// GentooVersion is a version of a Gentoo package, e.g. 1.2.3b_rc1_p2-r3.
+type GentooVersion struct {
+	Components []string
+	Letter     string
+	Suffixes   []VersionSuffix
+	Revision   string
+}
+
+// ParseGentooVersion parses and validates a Gentoo version.
+func ParseGentooVersion(v string) (*GentooVersion, error) {
+	m := versionRegex.FindStringSubmatch(v)
+	if m == nil {
+		return nil, fmt.Errorf("invalid version %s", v)
+	}
+
+	ans := &GentooVersion{
+		Components: strings.Split(m[1], "."),
+		Letter:     m[2],
+		Revision:   m[4],
+	}
+	for _, s := range suffixRegex.FindAllStringSubmatch(m[3], -1) {
+		ans.Suffixes = append(ans.Suffixes, VersionSuffix{Name: s[1], Number: s[2]})
+	}
+
+	return ans, nil
+}
+
+func (v *GentooVersion) String() string {
+	ans := strings.Join(v.Components, ".") + v.Letter
+	for _, s := range v.Suffixes {
+		ans += "_" + s.Name + s.Number
+	}
+	if v.Revision != "" {
+		ans += "-r" + v.Revision
+	}
+	return ans
+}
+
+// compareNumbers compares two strings of digits.
+func compareNumbers(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// CompareVersions compares two Gentoo versions with the algorithm of the
+// PMS and returns -1, 0 or 1.
+func CompareVersions(a, b *GentooVersion) int {
+	if c := compareNumbers(a.Components[0], b.Components[0]); c != 0 {
+		return c
+	}
+
+	for i := 1; i < len(a.Components) && i < len(b.Components); i++ {
+		ca, cb := a.Components[i], b.Components[i]
+		var c int
+		if strings.HasPrefix(ca, "0") || strings.HasPrefix(cb, "0") {
+			c = strings.Compare(strings.TrimRight(ca, "0"), strings.TrimRight(cb, "0"))
+		} else {
+			c = compareNumbers(ca, cb)
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	if len(a.Components) != len(b.Components) {
+		if len(a.Components) < len(b.Components) {
+			return -1
+		}
+		return 1
+	}
+
+	if c := strings.Compare(a.Letter, b.Letter); c != 0 {
+		return c
+	}
+
+	for i := 0; i < len(a.Suffixes) || i < len(b.Suffixes); i++ {
+		// A missing suffix is lower than _p and higher than the others
+		oa, ob := 4, 4
+		na, nb := "", ""
+		if i < len(a.Suffixes) {
+			oa, na = suffixOrder[a.Suffixes[i].Name], a.Suffixes[i].Number
+		}
+		if i < len(b.Suffixes) {
+			ob, nb = suffixOrder[b.Suffixes[i].Name], b.Suffixes[i].Number
+		}
+		if oa != ob {
+			if oa < ob {
+				return -1
+			}
+			return 1
+		}
+		if c := compareNumbers(na, nb); c != 0 {
+			return c
+		}
+	}
+
+	return compareNumbers(a.Revision, b.Revision)
+}
+
+// LuetVersion returns the luet version of a Gentoo version.
+//
+// luet compares only the numeric components of a version, so the whole
+// Gentoo version is encoded as six numbers: the first four are the
+// components, the fifth the letter and the suffixes and the sixth the
+// revision, omitted when zero. The fifth one is the concatenation of the
+// letter (two digits, a is 1), the kind of the first suffix (one digit),
+// its number (eight digits), the kind of the second suffix and its number
+// (six digits). The kinds are numbered in the Gentoo order and a missing
+// suffix is between rc and p, e.g. 1.2b_rc1-r3 is translated to
+// 1.2.0.0.24000000015000000.3.
+// In this way luet sorts and selects the versions as Gentoo does, with the
+// exception of components with leading zeros, that are compared as
+// numbers, and of trailing zero components, e.g. 1.0 and 1.0.0 have the
+// same luet version.
+func (v *GentooVersion) LuetVersion() (string, error) {
+	components, err := v.luetComponents()
+	if err != nil {
+		return "", err
+	}
+	if v.Revision != "" && compareNumbers(v.Revision, "0") != 0 {
+		revision, err := strconv.ParseInt(v.Revision, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid revision of version %s", v)
+		}
+		components = append(components, strconv.FormatInt(revision, 10))
+	}
+	return strings.Join(components, "."), nil
+}
+
+// luetComponents returns the numbers of the luet version without
+// the revision.
+func (v *GentooVersion) luetComponents() ([]string, error) {
+	if len(v.Components) > maxLuetComponents {
+		return nil, fmt.Errorf("version %s has more than %d components", v, maxLuetComponents)
+	}
+	if len(v.Suffixes) > maxLuetSuffixes {
+		return nil, fmt.Errorf("version %s has more than %d suffixes", v, maxLuetSuffixes)
+	}
+
+	ans := []string{}
+	for _, c := range v.Components {
+		n, err := strconv.ParseInt(c, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid component %s of version %s", c, v)
+		}
+		ans = append(ans, strconv.FormatInt(n, 10))
+	}
+	for len(ans) < maxLuetComponents {
+		ans = append(ans, "0")
+	}
+
+	var letter int64
+	if v.Letter != "" {
+		letter = int64(v.Letter[0]-'a') + 1
+	}
+	suffixes := letter
+	for i, width := range suffixWidths {
+		// The missing suffix is between rc and p
+		kind, number := int64(4), int64(0)
+		if i < len(v.Suffixes) {
+			kind = int64(suffixOrder[v.Suffixes[i].Name])
+			if v.Suffixes[i].Number != "" {
+				n, err := strconv.ParseInt(v.Suffixes[i].Number, 10, 64)
+				if err != nil || len(strconv.FormatInt(n, 10)) > width {
+					return nil, fmt.Errorf("suffix %s of version %s is too long",
+						v.Suffixes[i].Name+v.Suffixes[i].Number, v)
+				}
+				number = n
+			}
+		}
+		suffixes = (suffixes*10+kind+1)*pow10(width) + number
+	}
+	ans = append(ans, strconv.FormatInt(suffixes, 10))
+
+	return ans, nil
+}
+
+func pow10(n int) int64 {
+	ans := int64(1)
+	for i := 0; i < n; i++ {
+		ans *= 10
+	}
+	return ans
+}
+
+// TranslateVersion validates a Gentoo version and returns the luet version.
+func TranslateVersion(v string) (string, error) {
+	gv, err := ParseGentooVersion(v)
+	if err != nil {
+		return "", err
+	}
+	return gv.LuetVersion()
+}
+
+// LuetSelector returns the luet version selector of a Gentoo atom.
+// The operators are mapped on the luet ones, with the exception of
+// ~ (any revision): luet compares the revision as the other numbers
+// of the version, so it is translated as =<version without revision>*,
+// that matches the versions up to the next letter or suffix.
+// The Gentoo =<version>* matches the components of the version, see
+// MatchVersionSelector, use LuetSelectors for the requirements.
+func LuetSelector(gp *_gentoo.GentooPackage) (string, error) {
+	selectors, err := LuetSelectors(gp)
+	if err != nil {
+		return "", err
+	}
+	if len(selectors) == 1 {
+		return selectors[0], nil
+	}
+	return MatchVersionSelector(gp.Version + gp.VersionSuffix)
+}
+
+// MatchVersionSelector returns the luet selector of =<version>*: luet
+// can't select a range of versions with a single selector, so with less
+// than three components only the versions with the next components equal
+// to 0 are matched, e.g. 1.2 matches 1.2.0.4 but not 1.2.3.
+func MatchVersionSelector(v string) (string, error) {
+	selectors, err := MatchVersionSelectors(v)
+	if err != nil {
+		return "", err
+	}
+	if len(selectors) == 1 {
+		return selectors[0], nil
+	}
+
+	gv, err := ParseGentooVersion(v)
+	if err != nil {
+		return "", err
+	}
+	components := append([]string{}, gv.Components...)
+	for len(components) < 3 {
+		components = append(components, "0")
+	}
+	selectors, err = MatchVersionSelectors(strings.Join(components, "."))
+	if err != nil {
+		return "", err
+	}
+	return selectors[0], nil
+}
+
+// LuetSelectors returns the luet version selectors of a Gentoo atom, a
+// version is selected if it is admitted by all the selectors. There is
+// more than a selector only for the ranges of =<version>*, see
+// MatchVersionSelectors.
+func LuetSelectors(gp *_gentoo.GentooPackage) ([]string, error) {
+	v := gp.Version + gp.VersionSuffix
+	if v == "" {
+		return []string{""}, nil
+	}
+	if gp.Condition == _gentoo.PkgCondMatchVersion {
+		return MatchVersionSelectors(v)
+	}
+
+	gv, err := ParseGentooVersion(v)
+	if err != nil {
+		return nil, err
+	}
+	version, err := gv.LuetVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	switch gp.Condition {
+	case _gentoo.PkgCondGreater:
+		return []string{">" + version}, nil
+	case _gentoo.PkgCondGreaterEqual:
+		return []string{">=" + version}, nil
+	case _gentoo.PkgCondLess:
+		return []string{"<" + version}, nil
+	case _gentoo.PkgCondLessEqual:
+		return []string{"<=" + version}, nil
+	case _gentoo.PkgCondAnyRevision:
+		components, err := gv.luetComponents()
+		if err != nil {
+			return nil, err
+		}
+		return []string{"=" + strings.Join(components, ".") + "*"}, nil
+	case _gentoo.PkgCondInvalid, _gentoo.PkgCondEqual:
+		return []string{version}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported operator %s", gp.Condition)
+}
+
+// MatchVersionSelectors returns the luet selectors of the versions that
+// start with the components of a Gentoo version, as =<version>* does,
+// e.g. 1.2 matches 1.2_rc1, 1.2-r1, 1.2.3 and 1.2.3.4 but not 1.20.
+// luet =<version>* increments the last of at least three components to
+// get the end of the range, so with one or two components the range is
+// translated as two selectors: >=<version> and <<next version>. With a
+// letter or suffixes only the versions with the same letter and suffixes,
+// numbers included, are matched with any revision, e.g. 1.2_rc matches
+// 1.2_rc-r1 but not 1.2_rc1.
+func MatchVersionSelectors(v string) ([]string, error) {
+	gv, err := ParseGentooVersion(v)
+	if err != nil {
+		return nil, err
+	}
+	if gv.Revision != "" {
+		return nil, fmt.Errorf("invalid version %s with revision", v)
+	}
+
+	if gv.Letter != "" || len(gv.Suffixes) > 0 {
+		components, err := gv.luetComponents()
+		if err != nil {
+			return nil, err
+		}
+		return []string{"=" + strings.Join(components, ".") + "*"}, nil
+	}
+	if len(gv.Components) > maxLuetComponents {
+		return nil, fmt.Errorf("version %s has more than %d components", v, maxLuetComponents)
+	}
+
+	numbers := []int64{}
+	for _, c := range gv.Components {
+		n, err := strconv.ParseInt(c, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid component %s of version %s", c, v)
+		}
+		numbers = append(numbers, n)
+	}
+	if len(numbers) >= 3 {
+		return []string{"=" + joinNumbers(numbers) + "*"}, nil
+	}
+
+	next := append([]int64{}, numbers...)
+	next[len(next)-1]++
+	return []string{">=" + joinNumbers(numbers), "<" + joinNumbers(next)}, nil
+}
+
+func joinNumbers(numbers []int64) string {
+	ans := []string{}
+	for _, n := range numbers {
+		ans = append(ans, strconv.FormatInt(n, 10))
+	}
+	return strings.Join(ans, ".")
+}
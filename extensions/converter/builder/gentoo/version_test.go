@@ -0,0 +1,305 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
+	version "github.com/mudler/luet/pkg/versioner"
+
+	. "github.com/mudler/luet/pkg/tree/builder/gentoo"
+)
+
+// luetVersion returns the luet version of a Gentoo version.
+func luetVersion(v string) string {
+	l, err := TranslateVersion(v)
+	Expect(err).Should(BeNil(), v)
+	return l
+}
+
+var _ = Describe("Version", func() {
+
+	// Sorted as Gentoo does
+	sorted := []string{
+		"0.9",
+		"1.0_alpha",
+		"1.0_alpha1",
+		"1.0_alpha2_p1",
+		"1.0_beta",
+		"1.0_pre1",
+		"1.0_rc1",
+		"1.0_rc2",
+		"1.0_rc10",
+		"1.0",
+		"1.0-r1",
+		"1.0-r2",
+		"1.0-r10",
+		"1.0_p1",
+		"1.0_p2",
+		"1.0a",
+		"1.0b_p1",
+		"1.0.0",
+		"1.0.1",
+		"1.01",
+		"1.1",
+		"1.2",
+		"1.10",
+		"2.0_alpha",
+		"2.0",
+	}
+
+	parse := func(v string) *GentooVersion {
+		gv, err := ParseGentooVersion(v)
+		Expect(err).Should(BeNil(), v)
+		return gv
+	}
+
+	translate := luetVersion
+
+	It("Compares the versions", func() {
+		for i := range sorted {
+			for j := range sorted {
+				expected := 0
+				if i < j {
+					expected = -1
+				} else if i > j {
+					expected = 1
+				}
+				Expect(CompareVersions(parse(sorted[i]), parse(sorted[j]))).Should(Equal(expected),
+					"%s %s", sorted[i], sorted[j])
+			}
+		}
+
+		for _, c := range [][]string{
+			{"1.0", "1.0-r0"},
+			{"1.0_rc", "1.0_rc0"},
+			{"01.0", "1.0"},
+		} {
+			Expect(CompareVersions(parse(c[0]), parse(c[1]))).Should(Equal(0), "%v", c)
+		}
+	})
+
+	It("Validates the versions", func() {
+		for _, v := range []string{"", "1.0-r", "1.0_foo", "a1.0", "1.0ab", "1..0", "1.0-r1_p1"} {
+			_, err := ParseGentooVersion(v)
+			Expect(err).ShouldNot(BeNil(), v)
+		}
+
+		for _, v := range []string{"1.2.3.4.5", "1.0_alpha_beta_rc", "1.0_p123456789", "1.0_rc1_p1234567"} {
+			_, err := TranslateVersion(v)
+			Expect(err).ShouldNot(BeNil(), v)
+		}
+	})
+
+	It("Translates the versions", func() {
+		for _, c := range []struct{ gentoo, luet string }{
+			{"1.0", "1.0.0.0.5000000005000000"},
+			{"1.0-r0", "1.0.0.0.5000000005000000"},
+			{"1.0-r1", "1.0.0.0.5000000005000000.1"},
+			{"1.0_p1", "1.0.0.0.6000000015000000"},
+			{"1.1.1k", "1.1.1.0.115000000005000000"},
+			{"1.0_rc", "1.0.0.0.4000000005000000"},
+			{"1.0_rc1", "1.0.0.0.4000000015000000"},
+			{"1.2b_rc1_p2-r3", "1.2.0.0.24000000016000002.3"},
+			{"2020.01.05_p20200101", "2020.1.5.0.6202001015000000"},
+		} {
+			Expect(translate(c.gentoo)).Should(Equal(c.luet), c.gentoo)
+		}
+	})
+
+	It("Is parsed by luet", func() {
+		for _, v := range sorted {
+			l := translate(v)
+			gv := parse(v)
+
+			s, err := version.ParseVersion(l)
+			Expect(err).Should(BeNil())
+			Expect(s.Condition).Should(BeEquivalentTo(version.PkgCondEqual), l)
+			Expect(s.Version).Should(Equal(l))
+			Expect(s.Version).Should(HavePrefix(gv.Components[0]), l)
+			Expect(version.DefaultVersioner().ValidateSelector(l, l)).Should(BeTrue(), l)
+		}
+	})
+
+	It("Is sorted by luet as Gentoo does", func() {
+		v := version.DefaultVersioner()
+
+		// luet compares the components with leading zeros as numbers
+		// and ignores the trailing zero components
+		luetSorted := []string{}
+		for _, s := range sorted {
+			if s != "1.01" && s != "1.0.0" {
+				luetSorted = append(luetSorted, s)
+			}
+		}
+
+		translated := []string{}
+		for _, s := range luetSorted {
+			translated = append(translated, translate(s))
+		}
+		for i := range translated {
+			shuffled := append([]string{}, translated[i:]...)
+			shuffled = append(shuffled, translated[:i]...)
+			Expect(v.Sort(shuffled)).Should(Equal(translated))
+		}
+
+		for i := range luetSorted {
+			for j := range luetSorted {
+				a, b := translate(luetSorted[i]), translate(luetSorted[j])
+				Expect(v.ValidateSelector(a, "<"+b)).Should(Equal(i < j), "%s %s", a, b)
+				Expect(v.ValidateSelector(a, "<="+b)).Should(Equal(i <= j), "%s %s", a, b)
+				Expect(v.ValidateSelector(a, ">"+b)).Should(Equal(i > j), "%s %s", a, b)
+				Expect(v.ValidateSelector(a, ">="+b)).Should(Equal(i >= j), "%s %s", a, b)
+				Expect(v.ValidateSelector(a, b)).Should(Equal(i == j), "%s %s", a, b)
+			}
+		}
+
+		Expect(translate("1.0")).Should(Equal(translate("1.0.0")))
+		Expect(translate("1.01")).Should(Equal(translate("1.1")))
+	})
+
+	It("Translates the operators", func() {
+		for _, c := range []struct{ atom, selector string }{
+			{"dev-libs/foo", ""},
+			{"=dev-libs/foo-1.0-r1", "1.0.0.0.5000000005000000.1"},
+			{">=dev-libs/foo-1.0_rc1-r2", ">=1.0.0.0.4000000015000000.2"},
+			{">dev-libs/foo-1.0", ">1.0.0.0.5000000005000000"},
+			{"<dev-libs/foo-2.0", "<2.0.0.0.5000000005000000"},
+			{"<=dev-libs/foo-2.0_p1", "<=2.0.0.0.6000000015000000"},
+			{"~dev-libs/foo-1.0", "=1.0.0.0.5000000005000000*"},
+			{"~dev-libs/foo-1.0_rc1", "=1.0.0.0.4000000015000000*"},
+			{"=dev-libs/foo-1.2.3*", "=1.2.3*"},
+			{"=dev-libs/foo-1.2*", "=1.2.0*"},
+		} {
+			gp, err := _gentoo.ParsePackageStr(c.atom)
+			Expect(err).Should(BeNil())
+			s, err := LuetSelector(gp)
+			Expect(err).Should(BeNil(), c.atom)
+			Expect(s).Should(Equal(c.selector), c.atom)
+		}
+
+		selector := func(atom string) string {
+			gp, err := _gentoo.ParsePackageStr(atom)
+			Expect(err).Should(BeNil())
+			s, err := LuetSelector(gp)
+			Expect(err).Should(BeNil(), atom)
+			return s
+		}
+
+		v := version.DefaultVersioner()
+		for _, c := range []struct {
+			version, atom string
+			admit         bool
+		}{
+			{"1.0-r2", ">=dev-libs/foo-1.0-r2", true},
+			{"1.0-r3", ">=dev-libs/foo-1.0-r2", true},
+			{"1.0-r1", ">=dev-libs/foo-1.0-r2", false},
+			{"1.0", ">=dev-libs/foo-1.0-r2", false},
+			{"1.0_rc2", ">=dev-libs/foo-1.0_rc2", true},
+			{"1.0_rc1", ">=dev-libs/foo-1.0_rc2", false},
+			{"1.0_rc10", ">dev-libs/foo-1.0_rc2", true},
+			{"1.0", "<dev-libs/foo-1.0_p1", true},
+			{"1.0_p1", "<dev-libs/foo-1.0_p1", false},
+			{"1.0_p2", ">dev-libs/foo-1.0_p1", true},
+			{"1.0a", ">dev-libs/foo-1.0_p1", true},
+			{"1.0-r1", "=dev-libs/foo-1.0-r1", true},
+			{"1.0", "=dev-libs/foo-1.0-r1", false},
+			{"1.0", "~dev-libs/foo-1.0", true},
+			{"1.0-r3", "~dev-libs/foo-1.0", true},
+			{"1.0_p1", "~dev-libs/foo-1.0", false},
+			{"1.0_rc1", "~dev-libs/foo-1.0", false},
+			{"1.1", "~dev-libs/foo-1.0", false},
+			{"1.0_rc1-r1", "~dev-libs/foo-1.0_rc1", true},
+			{"1.0_rc2", "~dev-libs/foo-1.0_rc1", false},
+			{"1.2_p1", ">=dev-libs/foo-1.1", true},
+		} {
+			Expect(v.ValidateSelector(translate(c.version), selector(c.atom))).Should(
+				Equal(c.admit), "%s %s", c.version, c.atom)
+		}
+	})
+
+	It("Translates the version ranges", func() {
+		for _, c := range []struct {
+			atom      string
+			selectors []string
+		}{
+			{"dev-libs/foo", []string{""}},
+			{">=dev-libs/foo-1.2", []string{">=" + translate("1.2")}},
+			{"=dev-libs/foo-1*", []string{">=1", "<2"}},
+			{"=dev-libs/foo-1.2*", []string{">=1.2", "<1.3"}},
+			{"=dev-libs/foo-1.2.3*", []string{"=1.2.3*"}},
+			{"=dev-libs/foo-1.2.3.4*", []string{"=1.2.3.4*"}},
+			{"=dev-libs/foo-1.2_rc*", []string{"=1.2.0.0.4000000005000000*"}},
+		} {
+			gp, err := _gentoo.ParsePackageStr(c.atom)
+			Expect(err).Should(BeNil())
+			s, err := LuetSelectors(gp)
+			Expect(err).Should(BeNil(), c.atom)
+			Expect(s).Should(Equal(c.selectors), c.atom)
+		}
+
+		// A version is selected if all the selectors admit it
+		v := version.DefaultVersioner()
+		admit := func(version, atom string) bool {
+			gp, err := _gentoo.ParsePackageStr(atom)
+			Expect(err).Should(BeNil())
+			selectors, err := LuetSelectors(gp)
+			Expect(err).Should(BeNil(), atom)
+			for _, s := range selectors {
+				if !v.ValidateSelector(translate(version), s) {
+					return false
+				}
+			}
+			return true
+		}
+
+		for _, c := range []struct {
+			version, atom string
+			admit         bool
+		}{
+			{"1.2", "=dev-libs/foo-1.2*", true},
+			{"1.2-r1", "=dev-libs/foo-1.2*", true},
+			{"1.2_rc1", "=dev-libs/foo-1.2*", true},
+			{"1.2.3", "=dev-libs/foo-1.2*", true},
+			{"1.2.3-r1", "=dev-libs/foo-1.2*", true},
+			{"1.2.3.4", "=dev-libs/foo-1.2*", true},
+			{"1.2.3.4_p1", "=dev-libs/foo-1.2*", true},
+			{"1.20", "=dev-libs/foo-1.2*", false},
+			{"1.3", "=dev-libs/foo-1.2*", false},
+			{"1.1.9", "=dev-libs/foo-1.2*", false},
+			{"1.0-r3", "=dev-libs/foo-1.0*", true},
+			{"1.0_rc1", "=dev-libs/foo-1.0*", true},
+			{"1.1", "=dev-libs/foo-1.0*", false},
+			{"1.9.9", "=dev-libs/foo-1*", true},
+			{"2.0", "=dev-libs/foo-1*", false},
+			{"0.9", "=dev-libs/foo-1*", false},
+			{"1.2.3", "=dev-libs/foo-1.2.3*", true},
+			{"1.2.3.4-r2", "=dev-libs/foo-1.2.3*", true},
+			{"1.2.30", "=dev-libs/foo-1.2.3*", false},
+			{"1.2.4", "=dev-libs/foo-1.2.3*", false},
+			{"1.2.3.4", "=dev-libs/foo-1.2.3.4*", true},
+			{"1.2.3.5", "=dev-libs/foo-1.2.3.4*", false},
+			{"1.2_rc-r1", "=dev-libs/foo-1.2_rc*", true},
+			{"1.2", "=dev-libs/foo-1.2_rc*", false},
+			// Unlike Gentoo, the numbers of the suffixes must be equal
+			{"1.2_rc1", "=dev-libs/foo-1.2_rc*", false},
+		} {
+			Expect(admit(c.version, c.atom)).Should(Equal(c.admit), "%s %s", c.version, c.atom)
+		}
+	})
+})
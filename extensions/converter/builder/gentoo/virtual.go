@@ -0,0 +1,333 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	. "github.com/mudler/luet/pkg/logger"
+
+	_gentoo "github.com/Sabayon/pkgs-checker/pkg/gentoo"
+	pkg "github.com/mudler/luet/pkg/package"
+	"github.com/mudler/luet/pkg/tree/builder/common"
+	version "github.com/mudler/luet/pkg/versioner"
+	"mvdan.cc/sh/v3/expand"
+)
+
+// VirtualCategory is the category of the Gentoo virtual packages.
+const VirtualCategory = "virtual"
+
+// maxVirtualChain is the maximum number of virtuals followed to find
+// the provider of a virtual provided by another virtual.
+const maxVirtualChain = 8
+
+// virtualProviders returns the providers of a virtual package: the
+// alternatives of the any-of groups of RDEPEND, in order, or its
+// dependencies if there aren't any-of groups.
+func (ep *SimpleEbuildParser) virtualProviders(gp *_gentoo.GentooPackage, vars map[string]expand.Variable, use map[string]bool) []*pkg.DefaultPackage {
+	v, ok := vars["RDEPEND"]
+	if !ok {
+		return nil
+	}
+	gdeps, err := ParseRDEPEND(v.String())
+	if err != nil {
+		return nil
+	}
+	if use != nil {
+		gdeps = gdeps.FilterUse(use)
+	}
+
+	alternatives := []*GentooDependency{}
+	groups := gdeps.GetAnyOfDependencies()
+	if len(groups) == 0 {
+		alternatives = gdeps.GetDependencies()
+	}
+	for _, g := range groups {
+		alternatives = append(alternatives, anyOfAlternatives(g)...)
+	}
+
+	ans := []*pkg.DefaultPackage{}
+	seen := make(map[string]bool)
+	for _, d := range alternatives {
+		if d.IsBlocker() || seen[d.String()] {
+			continue
+		}
+		seen[d.String()] = true
+		ans = append(ans, ep.luetDependency(gp, d))
+	}
+	return ans
+}
+
+// anyOfAlternatives returns the first package of every alternative
+// of an any-of group.
+func anyOfAlternatives(group *GentooDependency) []*GentooDependency {
+	ans := []*GentooDependency{}
+	for _, alt := range group.SubDeps {
+		found := false
+		for _, d := range alt.GetDepsList() {
+			if !d.IsBlocker() {
+				ans = append(ans, d)
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		for _, nested := range alt.GetAnyOfList() {
+			ans = append(ans, anyOfAlternatives(nested)...)
+		}
+	}
+	return ans
+}
+
+// IsVirtual returns true if p is the conversion of a virtual/* ebuild.
+func IsVirtual(p pkg.Package) bool {
+	return strings.HasPrefix(p.GetAnnotations()[GentooAtomAnnotation], "="+VirtualCategory+"/")
+}
+
+// VirtualResolver replaces the virtual/* packages of a converted tree
+// with their providers: the provider of every virtual provides it and
+// the dependencies on the virtual are moved to the provider.
+type VirtualResolver struct {
+	// Preferred maps a virtual (virtual/jdk) to the category/name of the
+	// package to use as provider, when it's one of its alternatives.
+	// Otherwise the first alternative available is used.
+	Preferred map[string]string
+}
+
+// ResolvedVirtual is a virtual package replaced by its provider.
+type ResolvedVirtual struct {
+	Virtual  pkg.Package
+	Provider *pkg.DefaultPackage
+}
+
+// NewVirtualResolver returns a resolver without preferred providers.
+func NewVirtualResolver() *VirtualResolver {
+	return &VirtualResolver{Preferred: make(map[string]string)}
+}
+
+// AddPreferred adds a virtual/name=category/name preferred provider.
+func (r *VirtualResolver) AddPreferred(entry string) error {
+	i := strings.Index(entry, "=")
+	if i < 0 {
+		return fmt.Errorf("invalid provider %s, expected virtual/name=category/name", entry)
+	}
+	virtual, provider := strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+	if !strings.HasPrefix(virtual, VirtualCategory+"/") || !strings.Contains(provider, "/") {
+		return fmt.Errorf("invalid provider %s, expected virtual/name=category/name", entry)
+	}
+	r.Preferred[virtual] = provider
+	return nil
+}
+
+// provider returns the provider of a virtual between the alternatives
+// available in the tree, nil if there isn't any.
+func (r *VirtualResolver) provider(v pkg.Package, byName map[string][]pkg.Package) *pkg.DefaultPackage {
+	available := []*pkg.DefaultPackage{}
+	for _, c := range common.DecodeDeps(v.GetAnnotations()[GentooProvidersAnnotation]) {
+		if len(byName[c.GetCategory()+"/"+c.GetName()]) > 0 {
+			available = append(available, c)
+		}
+	}
+
+	if gp, err := gentooPackage(v); err == nil {
+		if preferred, ok := r.Preferred[gp.Category+"/"+gp.Name]; ok {
+			for _, c := range available {
+				if c.GetCategory()+"/"+c.GetName() == preferred {
+					return c
+				}
+			}
+			Warning(preferred, "isn't an available provider of", v.HumanReadableString())
+		}
+	}
+
+	if len(available) == 0 {
+		return nil
+	}
+	return available[0]
+}
+
+// Resolve removes from the database the virtual packages with a
+// provider available, adds them to the provides of the provider and
+// moves the dependencies and the conflicts on them to the provider. The
+// virtuals without providers are left as they are.
+func (r *VirtualResolver) Resolve(db pkg.PackageDatabase) ([]ResolvedVirtual, error) {
+	world := db.World()
+	byName := make(map[string][]pkg.Package)
+	for _, p := range world {
+		key := p.GetCategory() + "/" + p.GetName()
+		byName[key] = append(byName[key], p)
+	}
+
+	ans := []ResolvedVirtual{}
+	resolved := make(map[string][]*ResolvedVirtual)
+	for _, p := range world {
+		if !IsVirtual(p) {
+			continue
+		}
+		provider := r.provider(p, byName)
+		if provider == nil {
+			Debug("No provider available for", p.HumanReadableString())
+			continue
+		}
+		ans = append(ans, ResolvedVirtual{Virtual: p, Provider: provider})
+	}
+	for i := range ans {
+		key := ans[i].Virtual.GetCategory() + "/" + ans[i].Virtual.GetName()
+		resolved[key] = append(resolved[key], &ans[i])
+	}
+
+	// The highest version of a virtual is used for the dependencies
+	// without a version that matches.
+	v := version.DefaultVersioner()
+	for _, l := range resolved {
+		sort.SliceStable(l, func(i, j int) bool {
+			a, b := l[i].Virtual.GetVersion(), l[j].Virtual.GetVersion()
+			return a != b && v.Sort([]string{a, b})[0] == b
+		})
+	}
+
+	// Providers can be virtuals too
+	for i := range ans {
+		for n := 0; n < maxVirtualChain; n++ {
+			next := match(resolved[ans[i].Provider.GetCategory()+"/"+ans[i].Provider.GetName()], ans[i].Provider)
+			if next == nil || next == &ans[i] {
+				break
+			}
+			ans[i].Provider = next.Provider
+		}
+	}
+
+	updated := make(map[string]pkg.Package)
+	removed := make(map[string]bool)
+	for _, res := range ans {
+		removed[res.Virtual.HumanReadableString()] = true
+	}
+
+	for _, res := range ans {
+		for _, p := range byName[res.Provider.GetCategory()+"/"+res.Provider.GetName()] {
+			dp, ok := p.(*pkg.DefaultPackage)
+			if !ok || removed[p.HumanReadableString()] || !matchVersion(p, res.Provider.GetVersion()) {
+				continue
+			}
+			if addProvides(dp, res.Virtual) {
+				updated[p.HumanReadableString()] = p
+			}
+		}
+	}
+
+	rewrite := func(deps []*pkg.DefaultPackage) ([]*pkg.DefaultPackage, bool) {
+		changed := false
+		ans := make([]*pkg.DefaultPackage, 0, len(deps))
+		seen := make(map[string]bool)
+		for _, d := range deps {
+			if res := match(resolved[d.GetCategory()+"/"+d.GetName()], d); res != nil {
+				d = &pkg.DefaultPackage{
+					Name:     res.Provider.GetName(),
+					Category: res.Provider.GetCategory(),
+					Version:  res.Provider.GetVersion(),
+				}
+				changed = true
+			}
+			if key := d.HumanReadableString(); !seen[key] {
+				seen[key] = true
+				ans = append(ans, d)
+			}
+		}
+		return ans, changed
+	}
+
+	for _, p := range world {
+		dp, ok := p.(*pkg.DefaultPackage)
+		if !ok || removed[p.HumanReadableString()] {
+			continue
+		}
+		if requires, changed := rewrite(dp.PackageRequires); changed {
+			dp.PackageRequires = requires
+			updated[p.HumanReadableString()] = p
+		}
+		if requires, changed := rewrite(common.GetBuildRequires(dp)); changed {
+			common.SetBuildRequires(dp, requires)
+			updated[p.HumanReadableString()] = p
+		}
+		if conflicts, changed := rewrite(dp.PackageConflicts); changed {
+			dp.PackageConflicts = conflicts
+			updated[p.HumanReadableString()] = p
+		}
+		if conflicts, changed := rewrite(common.GetBuildConflicts(dp)); changed {
+			common.SetBuildConflicts(dp, conflicts)
+			updated[p.HumanReadableString()] = p
+		}
+	}
+
+	for _, p := range updated {
+		if err := db.UpdatePackage(p); err != nil {
+			return ans, err
+		}
+	}
+	for _, res := range ans {
+		if err := db.RemovePackage(res.Virtual); err != nil {
+			return ans, err
+		}
+	}
+
+	return ans, nil
+}
+
+// match returns the highest version of the resolved virtuals that
+// matches the version selector of the dependency, or the highest
+// version if there isn't any.
+func match(l []*ResolvedVirtual, d *pkg.DefaultPackage) *ResolvedVirtual {
+	if len(l) == 0 {
+		return nil
+	}
+	for _, res := range l {
+		if matchVersion(res.Virtual, d.GetVersion()) {
+			return res
+		}
+	}
+	return l[0]
+}
+
+// matchVersion returns true if the version of p matches the selector,
+// an empty selector matches every version.
+func matchVersion(p pkg.Package, selector string) bool {
+	if selector == "" {
+		return true
+	}
+	ok, err := p.VersionMatchSelector(selector, nil)
+	return err == nil && ok
+}
+
+// addProvides adds the virtual to the provides of p, it returns
+// false if it's already provided.
+func addProvides(p *pkg.DefaultPackage, virtual pkg.Package) bool {
+	for _, prov := range p.Provides {
+		if prov.GetCategory() == virtual.GetCategory() && prov.GetName() == virtual.GetName() &&
+			prov.GetVersion() == virtual.GetVersion() {
+			return false
+		}
+	}
+	p.Provides = append(p.Provides, &pkg.DefaultPackage{
+		Name:     virtual.GetName(),
+		Category: virtual.GetCategory(),
+		Version:  virtual.GetVersion(),
+	})
+	return true
+}
@@ -0,0 +1,174 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gentoo_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	pkg "github.com/mudler/luet/pkg/package"
+	"github.com/mudler/luet/pkg/tree/builder/common"
+	. "github.com/mudler/luet/pkg/tree/builder/gentoo"
+)
+
+var _ = Describe("Virtuals", func() {
+	var tmpdir string
+
+	write := func(file, content string) {
+		Expect(os.MkdirAll(filepath.Dir(file), os.ModePerm)).Should(BeNil())
+		Expect(ioutil.WriteFile(file, []byte(content), 0644)).Should(BeNil())
+	}
+
+	// generate converts the tree resolving the virtuals
+	generate := func(r *VirtualResolver) (*GentooBuilder, pkg.PackageDatabase) {
+		gb := NewGentooBuilder(&SimpleEbuildParser{}, 2, InMemory)
+		gb.Virtuals = r
+		db, err := gb.Generate(tmpdir)
+		Expect(err).Should(BeNil())
+		return gb, db
+	}
+
+	find := func(db pkg.PackageDatabase, category, name, version string) pkg.Package {
+		p, err := db.FindPackage(&pkg.DefaultPackage{Category: category, Name: name, Version: luetVersion(version)})
+		Expect(err).Should(BeNil())
+		return p
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpdir, err = ioutil.TempDir("", "virtuals")
+		Expect(err).Should(BeNil())
+
+		write(filepath.Join(tmpdir, "virtual", "jdk", "jdk-11.ebuild"), "EAPI=7\nSLOT=0\nRDEPEND=\"|| ( dev-java/openjdk-bin dev-java/openjdk )\"\n")
+		write(filepath.Join(tmpdir, "virtual", "libc", "libc-1.ebuild"), "EAPI=7\nSLOT=0\nRDEPEND=\"sys-libs/glibc\"\n")
+		write(filepath.Join(tmpdir, "virtual", "editor", "editor-0.ebuild"), "EAPI=7\nSLOT=0\nRDEPEND=\"|| ( app-editors/nano app-editors/vim )\"\n")
+		write(filepath.Join(tmpdir, "dev-java", "openjdk-bin", "openjdk-bin-11.0.ebuild"), "EAPI=7\nSLOT=0\n")
+		write(filepath.Join(tmpdir, "dev-java", "openjdk", "openjdk-11.0.ebuild"), "EAPI=7\nSLOT=0\n")
+		write(filepath.Join(tmpdir, "sys-libs", "glibc", "glibc-2.32.ebuild"), "EAPI=7\nSLOT=0\n")
+		write(filepath.Join(tmpdir, "app-misc", "foo", "foo-1.0.ebuild"),
+			"EAPI=7\nSLOT=0\nRDEPEND=\">=virtual/jdk-11 virtual/libc virtual/editor\"\nDEPEND=\"virtual/jdk\"\n")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpdir)
+	})
+
+	It("Stores the alternatives of the virtuals", func() {
+		parser := &SimpleEbuildParser{}
+		pkgs, err := parser.ScanEbuild(filepath.Join(tmpdir, "virtual", "jdk", "jdk-11.ebuild"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(IsVirtual(pkgs[0])).To(BeTrue())
+		Expect(pkgs[0].GetAnnotations()[GentooProvidersAnnotation]).To(Equal("dev-java/openjdk-bin dev-java/openjdk"))
+
+		pkgs, err = parser.ScanEbuild(filepath.Join(tmpdir, "virtual", "libc", "libc-1.ebuild"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pkgs[0].GetAnnotations()[GentooProvidersAnnotation]).To(Equal("sys-libs/glibc"))
+
+		pkgs, err = parser.ScanEbuild(filepath.Join(tmpdir, "app-misc", "foo", "foo-1.0.ebuild"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(IsVirtual(pkgs[0])).To(BeFalse())
+		Expect(pkgs[0].GetAnnotations()).ToNot(HaveKey(GentooProvidersAnnotation))
+	})
+
+	It("Replaces the virtuals with the first provider available", func() {
+		gb, db := generate(NewVirtualResolver())
+		defer db.Clean()
+
+		names := []string{}
+		for _, p := range db.World() {
+			names = append(names, p.GetCategory()+"/"+p.GetName())
+		}
+		sort.Strings(names)
+		// app-editors/* isn't in the tree, so virtual/editor is kept
+		Expect(names).To(Equal([]string{
+			"app-misc/foo", "dev-java/openjdk", "dev-java/openjdk-bin", "sys-libs/glibc", "virtual/editor",
+		}))
+
+		Expect(find(db, "dev-java", "openjdk-bin", "11.0").GetProvides()).To(Equal([]*pkg.DefaultPackage{
+			{Category: "virtual", Name: "jdk", Version: luetVersion("11")},
+		}))
+		Expect(find(db, "dev-java", "openjdk", "11.0").GetProvides()).To(BeEmpty())
+		Expect(find(db, "sys-libs", "glibc", "2.32").GetProvides()).To(Equal([]*pkg.DefaultPackage{
+			{Category: "virtual", Name: "libc", Version: luetVersion("1")},
+		}))
+
+		foo := find(db, "app-misc", "foo", "1.0")
+		Expect(foo.GetRequires()).To(Equal([]*pkg.DefaultPackage{
+			{Category: "dev-java", Name: "openjdk-bin"},
+			{Category: "sys-libs", Name: "glibc"},
+			{Category: "virtual", Name: "editor"},
+		}))
+		Expect(common.GetBuildRequires(foo)).To(Equal([]*pkg.DefaultPackage{
+			{Category: "dev-java", Name: "openjdk-bin"},
+		}))
+
+		skipped := []string{}
+		for _, r := range gb.GetReport().Results {
+			if r.Status == EbuildSkipped {
+				skipped = append(skipped, filepath.Base(r.Ebuild)+": "+r.Message)
+			}
+		}
+		sort.Strings(skipped)
+		Expect(skipped).To(Equal([]string{
+			"jdk-11.ebuild: virtual provided by dev-java/openjdk-bin",
+			"libc-1.ebuild: virtual provided by sys-libs/glibc",
+		}))
+	})
+
+	It("Replaces the virtuals in the conflicts", func() {
+		write(filepath.Join(tmpdir, "app-misc", "bar", "bar-1.0.ebuild"),
+			"EAPI=7\nSLOT=0\nRDEPEND=\"!virtual/jdk\"\nDEPEND=\"!virtual/libc !virtual/editor\"\n")
+		_, db := generate(NewVirtualResolver())
+		defer db.Clean()
+
+		bar := find(db, "app-misc", "bar", "1.0")
+		Expect(bar.GetConflicts()).To(Equal([]*pkg.DefaultPackage{
+			{Category: "dev-java", Name: "openjdk-bin"},
+		}))
+		Expect(common.GetBuildConflicts(bar)).To(Equal([]*pkg.DefaultPackage{
+			{Category: "sys-libs", Name: "glibc"},
+			{Category: "virtual", Name: "editor"},
+		}))
+	})
+
+	It("Uses the preferred providers", func() {
+		r := NewVirtualResolver()
+		Expect(r.AddPreferred("virtual/jdk=dev-java/openjdk")).To(Succeed())
+		// Not an alternative of the virtual, the first one is used
+		Expect(r.AddPreferred("virtual/libc=sys-libs/musl")).To(Succeed())
+		_, db := generate(r)
+		defer db.Clean()
+
+		Expect(find(db, "dev-java", "openjdk", "11.0").GetProvides()).To(HaveLen(1))
+		Expect(find(db, "dev-java", "openjdk-bin", "11.0").GetProvides()).To(BeEmpty())
+		Expect(find(db, "app-misc", "foo", "1.0").GetRequires()).To(ContainElement(
+			&pkg.DefaultPackage{Category: "dev-java", Name: "openjdk"}))
+		Expect(find(db, "sys-libs", "glibc", "2.32").GetProvides()).To(HaveLen(1))
+	})
+
+	It("Validates the preferred providers", func() {
+		r := NewVirtualResolver()
+		for _, entry := range []string{"virtual/jdk", "dev-java/jdk=dev-java/openjdk", "virtual/jdk=openjdk"} {
+			Expect(r.AddPreferred(entry)).ToNot(Succeed())
+		}
+		Expect(r.Preferred).To(BeEmpty())
+	})
+})
@@ -0,0 +1,227 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+// Package rpm imports the packages of the primary.xml metadata
+// of a RPM repository.
+package rpm
+
+import (
+	"compress/gzip"
+	"encoding/xml"
+	"io"
+	"os"
+	"strings"
+
+	. "github.com/mudler/luet/pkg/logger"
+
+	pkg "github.com/mudler/luet/pkg/package"
+	"github.com/mudler/luet/pkg/tree/builder/shell"
+)
+
+const (
+	// DefaultCategory is the category of the imported packages.
+	DefaultCategory = "rpm"
+	// VersionAnnotation is the package annotation with the RPM version.
+	VersionAnnotation = "rpm_version"
+	// GroupLabel is the package label with the group of the package.
+	GroupLabel = "group"
+)
+
+// Metadata is the content of a primary.xml file.
+type Metadata struct {
+	Packages []Package `xml:"package"`
+}
+
+// Package is a package of the metadata.
+type Package struct {
+	Type        string  `xml:"type,attr"`
+	Name        string  `xml:"name"`
+	Arch        string  `xml:"arch"`
+	Version     Version `xml:"version"`
+	Summary     string  `xml:"summary"`
+	Description string  `xml:"description"`
+	URL         string  `xml:"url"`
+	Location    struct {
+		Href string `xml:"href,attr"`
+	} `xml:"location"`
+	Format Format `xml:"format"`
+}
+
+// Format holds the rpm specific fields of a package.
+type Format struct {
+	License   string  `xml:"license"`
+	Group     string  `xml:"group"`
+	Provides  []Entry `xml:"provides>entry"`
+	Requires  []Entry `xml:"requires>entry"`
+	Conflicts []Entry `xml:"conflicts>entry"`
+	Obsoletes []Entry `xml:"obsoletes>entry"`
+}
+
+// Version is the version of a package or of an entry.
+type Version struct {
+	Epoch string `xml:"epoch,attr"`
+	Ver   string `xml:"ver,attr"`
+	Rel   string `xml:"rel,attr"`
+}
+
+// Entry is a relation of a package: provides, requires, conflicts
+// or obsoletes.
+type Entry struct {
+	Name  string `xml:"name,attr"`
+	Flags string `xml:"flags,attr"`
+	Version
+}
+
+// RPMBuilder imports the packages of a primary.xml file, also compressed
+// with gzip. The source packages are skipped.
+type RPMBuilder struct {
+	// Category of the imported packages.
+	Category string
+	// BaseURL is the url of the repository, used to set the URIs
+	// of the packages.
+	BaseURL string
+}
+
+func NewRPMBuilder() *RPMBuilder {
+	return &RPMBuilder{Category: DefaultCategory}
+}
+
+func (b *RPMBuilder) Generate(file string) (pkg.PackageDatabase, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	pkgs, err := b.ParsePrimary(r)
+	if err != nil {
+		return nil, err
+	}
+
+	db := pkg.NewInMemoryDatabase(false)
+	for _, p := range pkgs {
+		if _, err := db.FindPackage(p); err == nil {
+			Debug("Skip duplicated package", p.HumanReadableString())
+			continue
+		}
+		if _, err := db.CreatePackage(p); err != nil {
+			return db, err
+		}
+	}
+
+	return db, nil
+}
+
+// ParsePrimary returns the packages of a primary.xml file.
+func (b *RPMBuilder) ParsePrimary(r io.Reader) (pkg.Packages, error) {
+	var m Metadata
+	if err := xml.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+
+	ans := pkg.Packages{}
+	for _, rp := range m.Packages {
+		if rp.Type != "" && rp.Type != "rpm" || rp.Arch == "src" {
+			continue
+		}
+		p, err := b.newPackage(rp)
+		if err != nil {
+			Warning("Skip package", rp.Name, err.Error())
+			continue
+		}
+		ans = append(ans, p)
+	}
+	return ans, nil
+}
+
+func (b *RPMBuilder) newPackage(rp Package) (*pkg.DefaultPackage, error) {
+	version, err := rp.Version.LuetVersion()
+	if err != nil {
+		return nil, err
+	}
+	p := &pkg.DefaultPackage{
+		Name:     rp.Name,
+		Category: b.Category,
+		Version:  version,
+		License:  rp.Format.License,
+		Uri:      []string{},
+	}
+	p.AddAnnotation(VersionAnnotation, rp.Version.String())
+	p.SetDescription(strings.TrimSpace(rp.Summary))
+	if rp.URL != "" {
+		p.AddLabel(shell.HomepageLabel, rp.URL)
+	}
+	if rp.Format.Group != "" {
+		p.AddLabel(GroupLabel, rp.Format.Group)
+	}
+	if b.BaseURL != "" && rp.Location.Href != "" {
+		p.AddURI(strings.TrimSuffix(b.BaseURL, "/") + "/" + rp.Location.Href)
+	}
+
+	p.PackageRequires = b.relations(rp.Format.Requires)
+	p.PackageConflicts = b.relations(append(rp.Format.Conflicts, rp.Format.Obsoletes...))
+	// A package always provides itself
+	provides := []Entry{}
+	for _, e := range rp.Format.Provides {
+		if e.Name != rp.Name {
+			provides = append(provides, e)
+		}
+	}
+	p.Provides = b.relations(provides)
+
+	return p, nil
+}
+
+// relations returns the packages of the entries. The file dependencies
+// and the entries with parentheses, as the rich dependencies, rpmlib
+// features and capabilities like pkgconfig(foo), are skipped.
+func (b *RPMBuilder) relations(entries []Entry) []*pkg.DefaultPackage {
+	ans := []*pkg.DefaultPackage{}
+	seen := make(map[string]bool)
+
+	for _, e := range entries {
+		if strings.Contains(e.Name, "(") || strings.HasPrefix(e.Name, "/") {
+			Debug("Skip relation", e.Name)
+			continue
+		}
+
+		dep := &pkg.DefaultPackage{Name: e.Name, Category: b.Category}
+		if e.Ver != "" {
+			selector, err := e.Version.LuetSelector(e.Flags)
+			if err != nil {
+				Debug("Skip version of relation", e.Name, err.Error())
+			}
+			dep.Version = selector
+		}
+
+		if seen[dep.Name+dep.Version] {
+			continue
+		}
+		seen[dep.Name+dep.Version] = true
+		ans = append(ans, dep)
+	}
+
+	return ans
+}
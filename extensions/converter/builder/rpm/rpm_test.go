@@ -0,0 +1,138 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package rpm_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	pkg "github.com/mudler/luet/pkg/package"
+	. "github.com/mudler/luet/pkg/tree/builder/rpm"
+	"github.com/mudler/luet/pkg/tree/builder/shell"
+)
+
+const primary = `<?xml version="1.0" encoding="UTF-8"?>
+<metadata xmlns="http://linux.duke.edu/metadata/common" xmlns:rpm="http://linux.duke.edu/metadata/rpm" packages="3">
+<package type="rpm">
+  <name>foo</name>
+  <arch>x86_64</arch>
+  <version epoch="2" ver="1.2~rc1" rel="3.fc33"/>
+  <summary>The foo tool</summary>
+  <description>A longer description of foo.</description>
+  <url>https://example.com/foo</url>
+  <location href="Packages/f/foo-1.2~rc1-3.fc33.x86_64.rpm"/>
+  <format>
+    <rpm:license>MIT</rpm:license>
+    <rpm:group>Applications/System</rpm:group>
+    <rpm:provides>
+      <rpm:entry name="foo" flags="EQ" epoch="2" ver="1.2~rc1" rel="3.fc33"/>
+      <rpm:entry name="foo-tool" flags="EQ" epoch="0" ver="1.2"/>
+      <rpm:entry name="pkgconfig(foo)" flags="EQ" epoch="0" ver="1.2"/>
+    </rpm:provides>
+    <rpm:requires>
+      <rpm:entry name="libbar" flags="GE" epoch="0" ver="2.0" rel="1"/>
+      <rpm:entry name="libbaz" flags="LT" epoch="0" ver="3"/>
+      <rpm:entry name="glibc"/>
+      <rpm:entry name="glibc"/>
+      <rpm:entry name="/bin/sh"/>
+      <rpm:entry name="libc.so.6()(64bit)"/>
+      <rpm:entry name="rpmlib(CompressedFileNames)" flags="LE" epoch="0" ver="3.0.4" rel="1"/>
+      <rpm:entry name="(python3 if foo-python)"/>
+    </rpm:requires>
+    <rpm:conflicts>
+      <rpm:entry name="foo-legacy" flags="LE" epoch="0" ver="1.0"/>
+    </rpm:conflicts>
+    <rpm:obsoletes>
+      <rpm:entry name="foo-old"/>
+    </rpm:obsoletes>
+  </format>
+</package>
+<package type="rpm">
+  <name>libbar</name>
+  <arch>x86_64</arch>
+  <version epoch="0" ver="2.1" rel="1"/>
+  <summary>The bar library</summary>
+  <location href="Packages/l/libbar-2.1-1.x86_64.rpm"/>
+  <format/>
+</package>
+<package type="rpm">
+  <name>foo</name>
+  <arch>src</arch>
+  <version epoch="2" ver="1.2~rc1" rel="3.fc33"/>
+  <summary>The foo tool</summary>
+  <format/>
+</package>
+</metadata>
+`
+
+var _ = Describe("RPM importer", func() {
+	Context("Primary metadata", func() {
+		var tmpdir string
+
+		BeforeEach(func() {
+			var err error
+			tmpdir, err = ioutil.TempDir("", "rpm")
+			Expect(err).Should(BeNil())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tmpdir)
+		})
+
+		It("Imports the binary packages", func() {
+			file := filepath.Join(tmpdir, "primary.xml")
+			Expect(ioutil.WriteFile(file, []byte(primary), 0644)).Should(BeNil())
+
+			b := NewRPMBuilder()
+			b.BaseURL = "https://example.com/repo/"
+			db, err := b.Generate(file)
+			Expect(err).Should(BeNil())
+			Expect(len(db.World())).To(Equal(2))
+
+			p, err := db.FindPackage(&pkg.DefaultPackage{Name: "foo", Category: "rpm", Version: luetVersion("2", "1.2~rc1", "3.fc33")})
+			Expect(err).Should(BeNil())
+			Expect(p.GetDescription()).To(Equal("The foo tool"))
+			Expect(p.GetLicense()).To(Equal("MIT"))
+			Expect(p.GetLabels()[shell.HomepageLabel]).To(Equal("https://example.com/foo"))
+			Expect(p.GetLabels()[GroupLabel]).To(Equal("Applications/System"))
+			Expect(p.GetAnnotations()[VersionAnnotation]).To(Equal("2:1.2~rc1-3.fc33"))
+			Expect(p.GetURI()).To(Equal([]string{"https://example.com/repo/Packages/f/foo-1.2~rc1-3.fc33.x86_64.rpm"}))
+			Expect(p.GetRequires()).To(Equal([]*pkg.DefaultPackage{
+				{Name: "libbar", Category: "rpm", Version: selector("GE", "2.0", "1")},
+				{Name: "libbaz", Category: "rpm", Version: selector("LT", "3", "")},
+				{Name: "glibc", Category: "rpm"},
+			}))
+			Expect(p.GetConflicts()).To(Equal([]*pkg.DefaultPackage{
+				{Name: "foo-legacy", Category: "rpm", Version: selector("LE", "1.0", "")},
+				{Name: "foo-old", Category: "rpm"},
+			}))
+			Expect(p.(*pkg.DefaultPackage).Provides).To(Equal([]*pkg.DefaultPackage{
+				{Name: "foo-tool", Category: "rpm", Version: selector("EQ", "1.2", "")},
+			}))
+		})
+
+		It("Fails on invalid metadata", func() {
+			b := NewRPMBuilder()
+			_, err := b.ParsePrimary(strings.NewReader("<metadata><package>"))
+			Expect(err).ShouldNot(BeNil())
+		})
+	})
+})
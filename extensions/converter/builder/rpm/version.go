@@ -0,0 +1,356 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package rpm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxComponents is the number of components of the version stored
+// as numbers of the luet version.
+const maxComponents = 3
+
+var (
+	// versionWidths are the digits of the segments of the version
+	// that follow the components.
+	versionWidths = []int{8, 8}
+	// releaseWidths are the digits of the segments of the release.
+	releaseWidths = []int{5, 5, 5}
+)
+
+// segmentKind is the kind of a segment, in the rpm order: a segment
+// after ~ is lower than the end of the version, a segment after ^ is
+// higher than the end and lower than the other segments, and the
+// numeric segments are higher than the alphabetic ones.
+type segmentKind int
+
+const (
+	tildeEnd segmentKind = iota
+	tildeAlpha
+	tildeNumeric
+	endSegment
+	caretEnd
+	caretAlpha
+	caretNumeric
+	alphaSegment
+	numericSegment
+)
+
+// segment is an alphanumeric segment of a version, with the
+// ~ or ^ before it.
+type segment struct {
+	Kind  segmentKind
+	Value string
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// splitSegments splits a version in its segments, the other characters
+// are separators.
+func splitSegments(s string) []segment {
+	ans := []segment{}
+	modifier := byte(0)
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '~' || c == '^':
+			if modifier != 0 {
+				ans = append(ans, newSegment(modifier, ""))
+			}
+			modifier = c
+			i++
+			continue
+		case !isDigit(c) && !isAlpha(c):
+			i++
+			continue
+		}
+
+		j := i
+		for j < len(s) && isDigit(s[j]) == isDigit(c) && (isDigit(s[j]) || isAlpha(s[j])) {
+			j++
+		}
+		ans = append(ans, newSegment(modifier, s[i:j]))
+		modifier = 0
+		i = j
+	}
+	if modifier != 0 {
+		ans = append(ans, newSegment(modifier, ""))
+	}
+	return ans
+}
+
+// newSegment returns the segment with the given value,
+// after ~, ^ or nothing (0).
+func newSegment(modifier byte, value string) segment {
+	kind := endSegment
+	switch {
+	case value == "":
+		kind = map[byte]segmentKind{'~': tildeEnd, '^': caretEnd}[modifier]
+	case isDigit(value[0]):
+		kind = map[byte]segmentKind{'~': tildeNumeric, '^': caretNumeric, 0: numericSegment}[modifier]
+	default:
+		kind = map[byte]segmentKind{'~': tildeAlpha, '^': caretAlpha, 0: alphaSegment}[modifier]
+	}
+	return segment{Kind: kind, Value: value}
+}
+
+// compareSegments compares the segments of two versions or releases,
+// as rpmvercmp does.
+func compareSegments(a, b string) int {
+	sa, sb := splitSegments(a), splitSegments(b)
+	for i := 0; i < len(sa) || i < len(sb); i++ {
+		ea, eb := segment{Kind: endSegment}, segment{Kind: endSegment}
+		if i < len(sa) {
+			ea = sa[i]
+		}
+		if i < len(sb) {
+			eb = sb[i]
+		}
+		if ea.Kind != eb.Kind {
+			if ea.Kind < eb.Kind {
+				return -1
+			}
+			return 1
+		}
+
+		var c int
+		if ea.Kind == numericSegment || ea.Kind == tildeNumeric || ea.Kind == caretNumeric {
+			c = compareNumbers(ea.Value, eb.Value)
+		} else {
+			c = strings.Compare(ea.Value, eb.Value)
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareNumbers compares two strings of digits, empty is 0.
+func compareNumbers(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// CompareVersions compares two rpm versions as rpm does and returns
+// -1, 0 or 1. The release is compared only if both have it.
+func CompareVersions(a, b Version) int {
+	if c := compareNumbers(a.Epoch, b.Epoch); c != 0 {
+		return c
+	}
+	if c := compareSegments(a.Ver, b.Ver); c != 0 {
+		return c
+	}
+	if a.Rel == "" || b.Rel == "" {
+		return 0
+	}
+	return compareSegments(a.Rel, b.Rel)
+}
+
+// parseNumber returns the value of a string of digits, if it has at
+// most width digits.
+func parseNumber(s string, width int) (int64, error) {
+	s = strings.TrimLeft(s, "0")
+	if s == "" {
+		return 0, nil
+	}
+	if len(s) > width {
+		return 0, fmt.Errorf("number %s is too long", s)
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// alphaCode returns the code of the first width/2 letters of an
+// alphabetic segment, two digits for every letter in ASCII order.
+func alphaCode(s string, width int) int64 {
+	var ans int64
+	for i := 0; i < width/2; i++ {
+		var code int64
+		switch {
+		case i >= len(s):
+		case s[i] >= 'A' && s[i] <= 'Z':
+			code = int64(s[i]-'A') + 1
+		default:
+			code = int64(s[i]-'a') + 27
+		}
+		ans = ans*100 + code
+	}
+	return ans * pow10(width%2)
+}
+
+// packSegments returns a number with the kind and the value of the
+// segments, one segment for every width. The missing segments are
+// the end of the version.
+func packSegments(segments []segment, widths []int) (int64, error) {
+	var ans int64
+	for i, width := range widths {
+		s := segment{Kind: endSegment}
+		if i < len(segments) {
+			s = segments[i]
+		}
+		var value int64
+		switch s.Kind {
+		case numericSegment, tildeNumeric, caretNumeric:
+			n, err := parseNumber(s.Value, width)
+			if err != nil {
+				return 0, err
+			}
+			value = n
+		case alphaSegment, tildeAlpha, caretAlpha:
+			value = alphaCode(s.Value, width)
+		}
+		ans = (ans*10+int64(s.Kind))*pow10(width) + value
+	}
+	return ans, nil
+}
+
+func pow10(n int) int64 {
+	ans := int64(1)
+	for i := 0; i < n; i++ {
+		ans *= 10
+	}
+	return ans
+}
+
+// luetComponents returns the numbers of the luet version without
+// the release.
+func (v Version) luetComponents() ([]string, error) {
+	epoch, err := parseNumber(v.Epoch, 9)
+	if err != nil {
+		return nil, fmt.Errorf("invalid epoch of version %s", v)
+	}
+	ans := []string{strconv.FormatInt(epoch, 10)}
+
+	// the components are the numeric segments at the start
+	segments := splitSegments(v.Ver)
+	i := 0
+	for ; i < len(segments) && i < maxComponents && segments[i].Kind == numericSegment; i++ {
+		n, err := parseNumber(segments[i].Value, 18)
+		if err != nil {
+			return nil, fmt.Errorf("invalid component of version %s: %v", v, err)
+		}
+		ans = append(ans, strconv.FormatInt(n, 10))
+	}
+	for len(ans) <= maxComponents {
+		ans = append(ans, "0")
+	}
+
+	rest, err := packSegments(segments[i:], versionWidths)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version %s: %v", v, err)
+	}
+	return append(ans, strconv.FormatInt(rest, 10)), nil
+}
+
+// LuetVersion returns the luet version of an rpm version.
+//
+// luet compares only the numeric components of a version, so the rpm
+// version is encoded as six numbers: the epoch, three components of the
+// version, the rest of the version and the release. The components are
+// the numeric segments at the start of the version. The rest of the
+// version is encoded as two segments and the release as three: a
+// segment is the kind (after ~, the end, after ^, alphabetic, numeric)
+// followed by its number or by the code of its first letters. In this
+// way luet sorts the versions as rpm does, with the exception of the
+// letters and the segments not encoded and of the missing components,
+// that are equal to 0, e.g. 1.2 and 1.2.0 have the same luet version.
+func (v Version) LuetVersion() (string, error) {
+	ans, err := v.luetComponents()
+	if err != nil {
+		return "", err
+	}
+	release, err := packSegments(splitSegments(v.Rel), releaseWidths)
+	if err != nil {
+		return "", fmt.Errorf("invalid release of version %s: %v", v, err)
+	}
+	return strings.Join(append(ans, strconv.FormatInt(release, 10)), "."), nil
+}
+
+// LuetSelector returns the luet selector of a relation with the
+// given flags (EQ, LT, LE, GT, GE). rpm ignores the release of the
+// packages if the relation has no release, so the selector matches
+// all the releases of the version: the next version is the version
+// with the number of the rest incremented.
+func (v Version) LuetSelector(flags string) (string, error) {
+	if v.Rel != "" {
+		version, err := v.LuetVersion()
+		if err != nil {
+			return "", err
+		}
+		switch flags {
+		case "EQ":
+			return version, nil
+		case "LT":
+			return "<" + version, nil
+		case "LE":
+			return "<=" + version, nil
+		case "GT":
+			return ">" + version, nil
+		case "GE":
+			return ">=" + version, nil
+		}
+		return "", fmt.Errorf("unsupported flags %s", flags)
+	}
+
+	components, err := v.luetComponents()
+	if err != nil {
+		return "", err
+	}
+	version := strings.Join(components, ".")
+	rest, _ := strconv.ParseInt(components[len(components)-1], 10, 64)
+	components[len(components)-1] = strconv.FormatInt(rest+1, 10)
+	next := strings.Join(components, ".")
+
+	switch flags {
+	case "EQ":
+		return "=" + version + "*", nil
+	case "LT":
+		return "<" + version, nil
+	case "LE":
+		return "<" + next, nil
+	case "GT":
+		return ">=" + next, nil
+	case "GE":
+		return ">=" + version, nil
+	}
+	return "", fmt.Errorf("unsupported flags %s", flags)
+}
+
+func (v Version) String() string {
+	ans := v.Ver
+	if v.Epoch != "" && v.Epoch != "0" {
+		ans = v.Epoch + ":" + ans
+	}
+	if v.Rel != "" {
+		ans += "-" + v.Rel
+	}
+	return ans
+}
@@ -0,0 +1,204 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package rpm_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	version "github.com/mudler/luet/pkg/versioner"
+
+	. "github.com/mudler/luet/pkg/tree/builder/rpm"
+)
+
+// parse returns the rpm version of a [epoch:]version[-release] string.
+func parse(v string) Version {
+	ans := Version{}
+	if i := strings.Index(v, ":"); i >= 0 {
+		ans.Epoch, v = v[:i], v[i+1:]
+	}
+	if i := strings.LastIndex(v, "-"); i >= 0 {
+		ans.Rel, v = v[i+1:], v[:i]
+	}
+	ans.Ver = v
+	return ans
+}
+
+// luetVersion returns the luet version of an rpm version.
+func luetVersion(epoch, ver, rel string) string {
+	l, err := Version{Epoch: epoch, Ver: ver, Rel: rel}.LuetVersion()
+	Expect(err).Should(BeNil(), ver)
+	return l
+}
+
+// selector returns the luet selector of a relation without epoch.
+func selector(flags, ver, rel string) string {
+	s, err := Version{Ver: ver, Rel: rel}.LuetSelector(flags)
+	Expect(err).Should(BeNil(), ver)
+	return s
+}
+
+var _ = Describe("Version", func() {
+
+	// Sorted as rpm does
+	sorted := []string{
+		"0.9-1",
+		"1.0~rc1-1",
+		"1.0~rc2-1",
+		"1.0~rc10-1",
+		"1.0-0.1",
+		"1.0-1~beta",
+		"1.0-1",
+		"1.0-1.fc33",
+		"1.0-2",
+		"1.0-10",
+		"1.0^20200101git-1",
+		"1.0a-1",
+		"1.0b-1",
+		"1.0.0-1",
+		"1.0.1-1",
+		"1.1-1",
+		"1.2-1",
+		"1.10-1",
+		"2.0~rc1-1",
+		"2.0-1",
+		"1:0.9-1",
+	}
+
+	translate := func(v string) string {
+		l, err := parse(v).LuetVersion()
+		Expect(err).Should(BeNil(), v)
+		return l
+	}
+
+	It("Compares the versions", func() {
+		for i := range sorted {
+			for j := range sorted {
+				expected := 0
+				if i < j {
+					expected = -1
+				} else if i > j {
+					expected = 1
+				}
+				Expect(CompareVersions(parse(sorted[i]), parse(sorted[j]))).Should(Equal(expected),
+					"%s %s", sorted[i], sorted[j])
+			}
+		}
+
+		for _, c := range [][]string{
+			{"1.0-1", "0:1.0-1"},
+			{"1.01-1", "1.1-1"},
+			{"1.0_1-1", "1.0.1-1"},
+			{"1.0-1", "1.0"},
+		} {
+			Expect(CompareVersions(parse(c[0]), parse(c[1]))).Should(Equal(0), "%v", c)
+		}
+	})
+
+	It("Validates the versions", func() {
+		for _, v := range []string{"x:1.0-1", "1.0a123456789-1", "1.0-123456"} {
+			_, err := parse(v).LuetVersion()
+			Expect(err).ShouldNot(BeNil(), v)
+		}
+
+		_, err := parse("1.0").LuetSelector("XX")
+		Expect(err).ShouldNot(BeNil())
+	})
+
+	It("Translates the versions", func() {
+		for _, c := range []struct{ rpm, luet string }{
+			{"1.0-1", "0.1.0.0.300000000300000000.800001300000300000"},
+			{"1:1.2~rc1-3.fc33", "1.1.2.0.144290000800000001.800003732290800033"},
+			{"1.2^20200101git-1", "0.1.2.0.620200101733354600.800001300000300000"},
+		} {
+			Expect(translate(c.rpm)).Should(Equal(c.luet), c.rpm)
+		}
+	})
+
+	It("Is parsed by luet", func() {
+		for _, v := range sorted {
+			l := translate(v)
+
+			s, err := version.ParseVersion(l)
+			Expect(err).Should(BeNil())
+			Expect(s.Condition).Should(BeEquivalentTo(version.PkgCondEqual), l)
+			Expect(s.Version).Should(Equal(l))
+			Expect(version.DefaultVersioner().ValidateSelector(l, l)).Should(BeTrue(), l)
+		}
+	})
+
+	It("Is sorted by luet as rpm does", func() {
+		v := version.DefaultVersioner()
+
+		// luet ignores the trailing zero components
+		luetSorted := []string{}
+		for _, s := range sorted {
+			if s != "1.0.0-1" {
+				luetSorted = append(luetSorted, s)
+			}
+		}
+
+		translated := []string{}
+		for _, s := range luetSorted {
+			translated = append(translated, translate(s))
+		}
+		for i := range translated {
+			shuffled := append([]string{}, translated[i:]...)
+			shuffled = append(shuffled, translated[:i]...)
+			Expect(v.Sort(shuffled)).Should(Equal(translated))
+		}
+
+		for i := range luetSorted {
+			for j := range luetSorted {
+				a, b := translate(luetSorted[i]), translate(luetSorted[j])
+				Expect(v.ValidateSelector(a, "<"+b)).Should(Equal(i < j), "%s %s", a, b)
+				Expect(v.ValidateSelector(a, "<="+b)).Should(Equal(i <= j), "%s %s", a, b)
+				Expect(v.ValidateSelector(a, ">"+b)).Should(Equal(i > j), "%s %s", a, b)
+				Expect(v.ValidateSelector(a, ">="+b)).Should(Equal(i >= j), "%s %s", a, b)
+				Expect(v.ValidateSelector(a, b)).Should(Equal(i == j), "%s %s", a, b)
+			}
+		}
+
+		Expect(translate("1.0-1")).Should(Equal(translate("1.0.0-1")))
+	})
+
+	It("Selects the versions as rpm does", func() {
+		v := version.DefaultVersioner()
+		for _, flags := range []string{"EQ", "LT", "LE", "GT", "GE"} {
+			for _, a := range sorted {
+				for _, b := range sorted {
+					if a == "1.0.0-1" || b == "1.0.0-1" {
+						continue
+					}
+					// The relations without release match all the releases
+					for _, r := range []Version{parse(b), {Epoch: parse(b).Epoch, Ver: parse(b).Ver}} {
+						s, err := r.LuetSelector(flags)
+						Expect(err).Should(BeNil())
+
+						c := CompareVersions(parse(a), r)
+						expected := map[string]bool{
+							"EQ": c == 0, "LT": c < 0, "LE": c <= 0, "GT": c > 0, "GE": c >= 0,
+						}[flags]
+						Expect(v.ValidateSelector(translate(a), s)).Should(Equal(expected),
+							"%s %s %s", a, flags, r)
+					}
+				}
+			}
+		}
+	})
+})
@@ -0,0 +1,153 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package shell
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/mudler/luet/pkg/logger"
+
+	pkg "github.com/mudler/luet/pkg/package"
+	"github.com/mudler/luet/pkg/tree/builder/common"
+)
+
+// RecipeParser returns the packages of a build recipe.
+type RecipeParser interface {
+	ScanRecipe(ctx context.Context, path string) (pkg.Packages, error)
+}
+
+// Builder generates a luet tree from the build recipes of a tree,
+// e.g. the APKBUILD files of aports.
+type Builder struct {
+	// RecipeFile is the name of the recipe files, e.g. APKBUILD
+	RecipeFile string
+	Parser     RecipeParser
+	// Timeout is the maximum time spent on every recipe, if zero
+	// DefaultTimeout is used.
+	Timeout time.Duration
+	// OnProgress, if set, is called after every recipe scanned.
+	OnProgress common.ProgressFunc
+}
+
+func NewBuilder(recipeFile string, parser RecipeParser) *Builder {
+	return &Builder{RecipeFile: recipeFile, Parser: parser}
+}
+
+// Generate parses all the recipes in dir. The recipes that can't be
+// parsed are skipped.
+func (b *Builder) Generate(dir string) (pkg.PackageDatabase, error) {
+	return b.GenerateContext(context.Background(), dir)
+}
+
+// GenerateContext is Generate with a context: when it is canceled the
+// walk is stopped and its error is returned.
+func (b *Builder) GenerateContext(ctx context.Context, dir string) (pkg.PackageDatabase, error) {
+	recipes := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !info.IsDir() && info.Name() == b.RecipeFile {
+			recipes = append(recipes, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs := pkg.Packages{}
+	progress := common.NewProgressTracker(len(recipes), b.OnProgress)
+	for _, path := range recipes {
+		Info("parsing", path)
+		p, err := b.scan(ctx, path)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			Error(path, ":", err.Error())
+		} else {
+			pkgs = append(pkgs, p...)
+		}
+		progress.Done(err != nil)
+	}
+
+	ResolveCategories(pkgs)
+
+	db := pkg.NewInMemoryDatabase(false)
+	for _, p := range pkgs {
+		if _, err := db.FindPackage(p); err == nil {
+			continue
+		}
+		if _, err := db.CreatePackage(p); err != nil {
+			return db, err
+		}
+	}
+
+	return db, nil
+}
+
+// scan parses a recipe within the Timeout.
+func (b *Builder) scan(ctx context.Context, path string) (pkg.Packages, error) {
+	if b.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.Timeout)
+		defer cancel()
+	}
+	return b.Parser.ScanRecipe(ctx, path)
+}
+
+// ResolveCategories sets the category of the dependencies, as the
+// recipes refer them only by name. The dependencies not found are
+// assigned to the category of the package.
+func ResolveCategories(pkgs pkg.Packages) {
+	categories := make(map[string]string)
+	for _, p := range pkgs {
+		categories[p.GetName()] = p.GetCategory()
+	}
+
+	resolve := func(p pkg.Package, deps []*pkg.DefaultPackage) {
+		for _, d := range deps {
+			if d.Category != "" {
+				continue
+			}
+			if c, ok := categories[d.Name]; ok {
+				d.Category = c
+			} else {
+				d.Category = p.GetCategory()
+			}
+		}
+	}
+
+	for _, p := range pkgs {
+		resolve(p, p.GetRequires())
+		resolve(p, p.GetConflicts())
+
+		if dp, ok := p.(*pkg.DefaultPackage); ok {
+			deps := common.GetBuildRequires(dp)
+			if len(deps) > 0 {
+				resolve(p, deps)
+				common.SetBuildRequires(dp, deps)
+			}
+		}
+	}
+}
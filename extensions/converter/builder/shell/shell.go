@@ -0,0 +1,199 @@
+// Copyright © 2019 Ettore Di Giacinto <mudler@gentoo.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+// Package shell sources the shell based build recipes (APKBUILD, PKGBUILD)
+// to read their metadata variables.
+package shell
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	. "github.com/mudler/luet/pkg/logger"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+const (
+	// DefaultTimeout is the maximum time spent on sourcing a file,
+	// when the context has no deadline.
+	DefaultTimeout = 10 * time.Second
+	// DefaultMaxSteps is the maximum number of statements, loop
+	// iterations included, executed on the source of a file.
+	DefaultMaxSteps = 1000000
+	// HomepageLabel is the package label with the upstream url.
+	HomepageLabel = "homepage"
+)
+
+// stepContext counts the statements executed: the interpreter checks
+// the context before every statement and loop iteration, it's canceled
+// after DefaultMaxSteps.
+type stepContext struct {
+	context.Context
+	cancel   context.CancelFunc
+	steps    int64
+	exceeded int32
+}
+
+func (c *stepContext) Err() error {
+	if atomic.AddInt64(&c.steps, 1) > DefaultMaxSteps {
+		atomic.StoreInt32(&c.exceeded, 1)
+		c.cancel()
+	}
+	return c.Context.Err()
+}
+
+// execHandler stubs the external commands.
+func execHandler(ctx context.Context, args []string) error {
+	Debug("Stub command", args[0])
+	return nil
+}
+
+// openHandler allows only /dev/null, the other files
+// can't be read nor written.
+func openHandler(ctx context.Context, path string, flag int, perm os.FileMode) (io.ReadWriteCloser, error) {
+	if path == os.DevNull {
+		return interp.DefaultOpenHandler()(ctx, path, flag, perm)
+	}
+	Debug("Forbidden access to", path)
+	return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrPermission}
+}
+
+// SourceFile runs the shell file and returns its global variables.
+// The functions are only defined, the external commands are not
+// executed and the files can't be opened. The source is stopped after
+// DefaultTimeout or DefaultMaxSteps statements. env is a list of
+// KEY=VALUE variables set before sourcing.
+func SourceFile(ctx context.Context, path string, env ...string) (map[string]expand.Variable, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open: %v", err)
+	}
+	defer file.Close()
+
+	node, err := syntax.NewParser().Parse(file, path)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse: %v", err)
+	}
+
+	var stderr bytes.Buffer
+	r, err := interp.New(
+		interp.Env(expand.ListEnviron(env...)),
+		interp.StdIO(nil, ioutil.Discard, &stderr),
+		interp.OpenHandler(openHandler),
+		interp.ExecHandler(execHandler),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+	sctx := &stepContext{}
+	sctx.Context, sctx.cancel = context.WithCancel(ctx)
+	defer sctx.cancel()
+
+	err = r.Run(sctx, node)
+	if stderr.Len() > 0 {
+		Debug("Errors on source", path, stderr.String())
+	}
+	if atomic.LoadInt32(&sctx.exceeded) == 1 {
+		return nil, fmt.Errorf("steps limit of %d statements exceeded on source of %s", DefaultMaxSteps, path)
+	}
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return nil, fmt.Errorf("timeout on source of %s", path)
+	case context.Canceled:
+		return nil, ctx.Err()
+	}
+	// The exit status of the last command is not relevant.
+	if _, ok := interp.IsExitStatus(err); err != nil && !ok {
+		return nil, fmt.Errorf("could not run: %v", err)
+	}
+
+	vars := make(map[string]expand.Variable)
+	for name, v := range r.Vars {
+		switch name {
+		// internal shell vars
+		case "PWD", "UID", "HOME", "PATH", "IFS", "OPTIND":
+			continue
+		}
+		if v.IsSet() {
+			vars[name] = v
+		}
+	}
+
+	return vars, nil
+}
+
+// String returns the value of a variable, empty if it's not set.
+func String(vars map[string]expand.Variable, name string) string {
+	v, ok := vars[name]
+	if !ok {
+		return ""
+	}
+	if v.Kind == expand.Indexed {
+		return strings.Join(v.List, " ")
+	}
+	return strings.TrimSpace(v.String())
+}
+
+// List returns the values of an array or of a whitespace separated variable.
+func List(vars map[string]expand.Variable, name string) []string {
+	v, ok := vars[name]
+	if !ok {
+		return []string{}
+	}
+
+	ans := []string{}
+	values := v.List
+	if v.Kind != expand.Indexed {
+		values = strings.Fields(v.String())
+	}
+	for _, s := range values {
+		if s = strings.TrimSpace(s); s != "" {
+			ans = append(ans, s)
+		}
+	}
+	return ans
+}
+
+// SourceURIs returns the remote URIs of a list of sources, in the form
+// [name::]uri, skipping the local files. The VCS prefix of the
+// URIs (git+https://) is kept.
+func SourceURIs(sources []string) []string {
+	ans := []string{}
+	for _, s := range sources {
+		if i := strings.Index(s, "::"); i >= 0 {
+			s = s[i+2:]
+		}
+		if strings.Contains(s, "://") {
+			ans = append(ans, s)
+		}
+	}
+	return ans
+}
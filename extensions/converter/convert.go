@@ -15,87 +15,489 @@
 package cmd
 
 import (
-	"io/ioutil"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
 	. "github.com/mudler/luet/pkg/config"
 	. "github.com/mudler/luet/pkg/logger"
 	pkg "github.com/mudler/luet/pkg/package"
 	tree "github.com/mudler/luet/pkg/tree"
 
+	"github.com/mudler/luet/pkg/tree/builder/alpine"
+	"github.com/mudler/luet/pkg/tree/builder/arch"
+	"github.com/mudler/luet/pkg/tree/builder/common"
+	"github.com/mudler/luet/pkg/tree/builder/debian"
 	"github.com/mudler/luet/pkg/tree/builder/gentoo"
+	"github.com/mudler/luet/pkg/tree/builder/rpm"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
+// progressInterval is the minimum interval between the progress messages.
+const progressInterval = 5 * time.Second
+
+// contextParser is implemented by the builders that can be interrupted.
+type contextParser interface {
+	GenerateContext(context.Context, string) (pkg.PackageDatabase, error)
+}
+
 var convertCmd = &cobra.Command{
-	Use:   "convert [portage-tree] [luet-tree]",
+	Use:   "convert [source-tree|index] [luet-tree]",
 	Short: "convert other package manager tree into luet",
 	Long:  `Parses external PM and produces a luet parsable tree`,
 	PreRun: func(cmd *cobra.Command, args []string) {
 		viper.BindPFlag("type", cmd.Flags().Lookup("type"))
 		viper.BindPFlag("database", cmd.Flags().Lookup("database"))
+		viper.BindPFlag("database-path", cmd.Flags().Lookup("database-path"))
+		viper.BindPFlag("resume", cmd.Flags().Lookup("resume"))
+		viper.BindPFlag("from-database", cmd.Flags().Lookup("from-database"))
+		viper.BindPFlag("profile", cmd.Flags().Lookup("profile"))
+		viper.BindPFlag("overlay", cmd.Flags().Lookup("overlay"))
+		viper.BindPFlag("md5-cache", cmd.Flags().Lookup("md5-cache"))
+		viper.BindPFlag("slot-strategy", cmd.Flags().Lookup("slot-strategy"))
+		viper.BindPFlag("arch", cmd.Flags().Lookup("arch"))
+		viper.BindPFlag("mirror", cmd.Flags().Lookup("mirror"))
+		viper.BindPFlag("timeout", cmd.Flags().Lookup("timeout"))
+		viper.BindPFlag("stability", cmd.Flags().Lookup("stability"))
+		viper.BindPFlag("mask-file", cmd.Flags().Lookup("mask-file"))
+		viper.BindPFlag("best-version", cmd.Flags().Lookup("best-version"))
+		viper.BindPFlag("include", cmd.Flags().Lookup("include"))
+		viper.BindPFlag("exclude", cmd.Flags().Lookup("exclude"))
+		viper.BindPFlag("atoms-file", cmd.Flags().Lookup("atoms-file"))
+		viper.BindPFlag("with-deps", cmd.Flags().Lookup("with-deps"))
+		viper.BindPFlag("virtuals", cmd.Flags().Lookup("virtuals"))
+		viper.BindPFlag("sandbox-strict", cmd.Flags().Lookup("sandbox-strict"))
+		viper.BindPFlag("sandbox-allow", cmd.Flags().Lookup("sandbox-allow"))
+		viper.BindPFlag("provider", cmd.Flags().Lookup("provider"))
+		viper.BindPFlag("cache", cmd.Flags().Lookup("cache"))
+		viper.BindPFlag("report", cmd.Flags().Lookup("report"))
+		viper.BindPFlag("report-format", cmd.Flags().Lookup("report-format"))
+		viper.BindPFlag("max-failures", cmd.Flags().Lookup("max-failures"))
+		viper.BindPFlag("layout", cmd.Flags().Lookup("layout"))
+		viper.BindPFlag("diff", cmd.Flags().Lookup("diff"))
+		viper.BindPFlag("diff-format", cmd.Flags().Lookup("diff-format"))
+		viper.BindPFlag("diff-output", cmd.Flags().Lookup("diff-output"))
+		viper.BindPFlag("build-specs", cmd.Flags().Lookup("build-specs"))
+		viper.BindPFlag("build-template", cmd.Flags().Lookup("build-template"))
+		viper.BindPFlag("build-image", cmd.Flags().Lookup("build-image"))
+		viper.BindPFlag("build-keywords", cmd.Flags().Lookup("build-keywords"))
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 
 		t := viper.GetString("type")
 		databaseType := viper.GetString("database")
-		var db pkg.PackageDatabase
+		profileFile := viper.GetString("profile")
+		overlays := viper.GetStringSlice("overlay")
 
-		if len(args) != 2 {
+		// The luet tree is not needed to compare the converted packages
+		diffTree := viper.GetString("diff")
+		if len(args) != 2 && !(diffTree != "" && len(args) == 1) {
 			Fatal("Incorrect number of arguments")
 		}
 
 		input := args[0]
-		output := args[1]
+		output := ""
+		if len(args) == 2 {
+			output = args[1]
+		}
 		Info("Converting trees from " + input + " [" + t + "]")
 
-		var builder tree.Parser
-		switch t {
-		case "gentoo":
-			builder = gentoo.NewGentooBuilder(
-				&gentoo.SimpleEbuildParser{},
-				LuetCfg.GetGeneral().Concurrency,
-				gentoo.InMemory)
-		default: // dup
-			builder = gentoo.NewGentooBuilder(
-				&gentoo.SimpleEbuildParser{},
-				LuetCfg.GetGeneral().Concurrency,
-				gentoo.InMemory)
+		var profile *gentoo.ConversionProfile
+		if profileFile != "" {
+			var err error
+			profile, err = gentoo.LoadConversionProfile(profileFile)
+			if err != nil {
+				Fatal("Error on loading profile " + profileFile + ": " + err.Error())
+			}
 		}
 
+		var dbType gentoo.MemoryDB
 		switch databaseType {
 		case "memory":
-			db = pkg.NewInMemoryDatabase(false)
+			dbType = gentoo.InMemory
 		case "boltdb":
-			tmpdir, err := ioutil.TempDir("", "package")
+			dbType = gentoo.BoltDB
+		default:
+			Fatal("Invalid database type " + databaseType)
+		}
+		dbPath := viper.GetString("database-path")
+		if dbPath != "" && dbType != gentoo.BoltDB {
+			Fatal("A database path is supported only with the boltdb database")
+		}
+		if (viper.GetBool("resume") || viper.GetBool("from-database")) && dbPath == "" {
+			Fatal("A boltdb database path is needed to resume a conversion or to load the packages")
+		}
+
+		layout, err := gentoo.ParseTreeLayout(viper.GetString("layout"))
+		if err != nil {
+			Fatal(err.Error())
+		}
+
+		switch viper.GetString("diff-format") {
+		case "text", "json":
+		default:
+			Fatal("Invalid diff format " + viper.GetString("diff-format"))
+		}
+
+		switch viper.GetString("report-format") {
+		case "json", "junit":
+		default:
+			Fatal("Invalid report format " + viper.GetString("report-format"))
+		}
+
+		filter := gentoo.NewPackageFilter()
+		filter.Arch = viper.GetString("arch")
+		filter.BestVersion = viper.GetBool("best-version")
+		stability, err := gentoo.ParseStability(viper.GetString("stability"))
+		if err != nil {
+			Fatal(err.Error())
+		}
+		filter.Stability = stability
+		if stability != gentoo.StabilityAny && filter.Arch == "" {
+			Fatal("An arch is needed to filter the packages by stability")
+		}
+		treeMask := filepath.Join(input, gentoo.PackageMaskFile)
+		if _, err := os.Stat(treeMask); err == nil {
+			if err := filter.LoadMaskFile(treeMask); err != nil {
+				Fatal("Error on loading mask file " + treeMask + ": " + err.Error())
+			}
+		}
+		if maskFile := viper.GetString("mask-file"); maskFile != "" {
+			if err := filter.LoadMaskFile(maskFile); err != nil {
+				Fatal("Error on loading mask file " + maskFile + ": " + err.Error())
+			}
+		}
+
+		selector := gentoo.NewPackageSelector()
+		selector.AddInclude(viper.GetStringSlice("include")...)
+		selector.AddExclude(viper.GetStringSlice("exclude")...)
+		if atomsFile := viper.GetString("atoms-file"); atomsFile != "" {
+			if err := selector.LoadAtomsFile(atomsFile); err != nil {
+				Fatal("Error on loading atoms file " + atomsFile + ": " + err.Error())
+			}
+		}
+		selector.Deps = viper.GetBool("with-deps")
+
+		var virtuals *gentoo.VirtualResolver
+		if viper.GetBool("virtuals") {
+			virtuals = gentoo.NewVirtualResolver()
+			if profile != nil {
+				for v, p := range profile.Providers {
+					virtuals.Preferred[v] = p
+				}
+			}
+			for _, entry := range viper.GetStringSlice("provider") {
+				if err := virtuals.AddPreferred(entry); err != nil {
+					Fatal(err.Error())
+				}
+			}
+		}
+
+		var cache *gentoo.EbuildCache
+		if cacheFile := viper.GetString("cache"); cacheFile != "" {
+			cache, err = gentoo.NewEbuildCache(cacheFile)
 			if err != nil {
-				Fatal(err)
+				Fatal("Error on loading cache " + cacheFile + ": " + err.Error())
 			}
-			db = pkg.NewBoltDatabase(tmpdir)
 		}
-		defer db.Clean()
 
-		packageTree, err := builder.Generate(input)
+		slotStrategy, err := gentoo.ParseSlotStrategy(viper.GetString("slot-strategy"))
 		if err != nil {
-			Fatal("Error: " + err.Error())
+			Fatal(err.Error())
+		}
+
+		sandbox := gentoo.NewSandbox()
+		sandbox.Allowed = viper.GetStringSlice("sandbox-allow")
+		if viper.GetBool("sandbox-strict") {
+			sandbox.External = gentoo.ForbidExternal
+		}
+
+		simpleParser := &gentoo.SimpleEbuildParser{Overlays: overlays, SlotStrategy: slotStrategy, Sandbox: sandbox}
+		var parser gentoo.EbuildParser = simpleParser
+		if profile != nil && len(profile.Variants) > 0 {
+			if viper.GetBool("md5-cache") {
+				Warning("The md5-cache is ignored with the variants of the profile")
+			}
+			parser = gentoo.NewVariantEbuildParser(simpleParser)
+		} else if viper.GetBool("md5-cache") {
+			parser = gentoo.NewCacheEbuildParser(simpleParser)
+		}
+
+		timeout := viper.GetDuration("timeout")
+
+		var builder tree.Parser
+		switch t {
+		case "gentoo":
+			gb := gentoo.NewGentooBuilder(
+				parser,
+				LuetCfg.GetGeneral().Concurrency,
+				dbType)
+			gb.DBPath = dbPath
+			gb.Resume = viper.GetBool("resume")
+			gb.Profile = profile
+			gb.Filter = filter
+			if !selector.IsEmpty() {
+				gb.Selector = selector
+			}
+			gb.Virtuals = virtuals
+			gb.Cache = cache
+			gb.Timeout = timeout
+			gb.OnProgress = renderProgress()
+			builder = gb
+		case "alpine":
+			ab := alpine.NewAlpineBuilder()
+			ab.Timeout = timeout
+			ab.OnProgress = renderProgress()
+			builder = ab
+		case "arch":
+			ab := arch.NewArchBuilder()
+			ab.Timeout = timeout
+			ab.OnProgress = renderProgress()
+			builder = ab
+		case "debian":
+			db := debian.NewDebianBuilder()
+			db.Mirror = viper.GetString("mirror")
+			builder = db
+		case "rpm":
+			rb := rpm.NewRPMBuilder()
+			rb.BaseURL = viper.GetString("mirror")
+			builder = rb
+		default:
+			Fatal("Invalid type " + t)
+		}
+
+		var packageTree pkg.PackageDatabase
+		fromDatabase := viper.GetBool("from-database")
+		if fromDatabase {
+			if _, err := os.Stat(dbPath); err != nil {
+				Fatal("Error on opening database: " + err.Error())
+			}
+			Info("Loading packages from " + dbPath)
+			packageTree = pkg.NewBoltDatabase(dbPath)
+		} else {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			interrupt := make(chan os.Signal, 1)
+			signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				<-interrupt
+				Warning("Interrupted, stopping the conversion")
+				cancel()
+			}()
+
+			if cp, ok := builder.(contextParser); ok {
+				packageTree, err = cp.GenerateContext(ctx, input)
+			} else {
+				packageTree, err = builder.Generate(input)
+			}
+			signal.Stop(interrupt)
+			if err == context.Canceled {
+				if dbPath != "" {
+					Fatal("Conversion interrupted, resume it with --resume")
+				}
+				Fatal("Conversion interrupted")
+			}
+			if err != nil {
+				Fatal("Error: " + err.Error())
+			}
 		}
 
-		defer packageTree.Clean()
+		// The persistent database is kept for the next runs
+		if dbPath == "" {
+			defer packageTree.Clean()
+		}
 		Info("Tree generated")
 
-		generalRecipe := tree.NewGeneralRecipe(packageTree)
+		failures := 0
+		if gb, ok := builder.(*gentoo.GentooBuilder); ok && !fromDatabase {
+			skipped := gb.GetSkipped()
+			if len(skipped) > 0 {
+				Info(fmt.Sprintf("%d ebuilds skipped:", len(skipped)))
+				for _, s := range skipped {
+					Info(fmt.Sprintf("  %s: %s", s.Package, s.Reason))
+				}
+			}
+
+			report := gb.GetReport()
+			failures = report.Failures()
+			Info(fmt.Sprintf("Ebuilds: %d ok, %d parse errors, %d timeouts, %d panics, %d forbidden, %d skipped",
+				report.Count(gentoo.EbuildOk), report.Count(gentoo.EbuildParseError),
+				report.Count(gentoo.EbuildTimeout), report.Count(gentoo.EbuildPanic),
+				report.Count(gentoo.EbuildForbidden), report.Count(gentoo.EbuildSkipped)))
+
+			if reportFile := viper.GetString("report"); reportFile != "" {
+				if err := writeReport(report, reportFile, viper.GetString("report-format")); err != nil {
+					Fatal("Error on writing report: " + err.Error())
+				}
+				Info("Report written to " + reportFile)
+			}
+		}
+
+		if diffTree != "" {
+			Info("Comparing generated tree with " + diffTree)
+			if err := writeDiff(packageTree, diffTree, viper.GetString("diff-output"), viper.GetString("diff-format")); err != nil {
+				Fatal("Error on comparing trees: " + err.Error())
+			}
+			checkFailures(failures)
+			return
+		}
+
 		Info("Saving generated tree to " + output)
 
-		err = generalRecipe.Save(output)
+		err = gentoo.SaveTree(packageTree, output, layout)
 		if err != nil {
 			Fatal("Error: " + err.Error())
 		}
+
+		if cache != nil {
+			// Drop the definitions of the packages not in the tree anymore:
+			// the ones of the ebuilds removed or changed since the last run
+			// and the ones filtered after the scan, e.g. by --best-version
+			// or by the resolution of the virtuals. The collections are
+			// already written without them.
+			collections := layout.Collections(packageTree)
+			for _, p := range append(cache.GetScanned(), cache.GetRemoved()...) {
+				dir := layout.Dir(p)
+				if _, ok := collections[dir]; ok {
+					continue
+				}
+				if _, err := os.Stat(filepath.Join(output, dir)); err != nil {
+					continue
+				}
+				Info("Removing definition of " + p.HumanReadableString())
+				os.RemoveAll(filepath.Join(output, dir))
+			}
+			if err := cache.Save(); err != nil {
+				Fatal("Error on saving cache: " + err.Error())
+			}
+		}
+
+		if viper.GetBool("build-specs") && t == "gentoo" {
+			var gen *gentoo.BuildSpecGenerator
+			if buildTemplate := viper.GetString("build-template"); buildTemplate != "" {
+				gen, err = gentoo.NewBuildSpecGeneratorFromFile(buildTemplate)
+			} else {
+				gen, err = gentoo.NewBuildSpecGenerator("")
+			}
+			if err != nil {
+				Fatal("Error on loading build spec template: " + err.Error())
+			}
+			gen.Image = viper.GetString("build-image")
+			gen.Keywords = viper.GetString("build-keywords")
+			gen.Profile = profile
+
+			Info("Saving build specs to " + output)
+			err = gen.SaveLayout(packageTree, output, layout)
+			if err != nil {
+				Fatal("Error: " + err.Error())
+			}
+		}
+
+		checkFailures(failures)
 	},
 }
 
+// checkFailures exits with error when more ebuilds than the allowed
+// ones failed.
+func checkFailures(failures int) {
+	if maxFailures := viper.GetInt("max-failures"); maxFailures >= 0 && failures > maxFailures {
+		Error(fmt.Sprintf("%d ebuilds failed, more than the %d allowed", failures, maxFailures))
+		os.Exit(1)
+	}
+}
+
+// writeDiff compares the packages with the ones of the luet tree and
+// writes the differences to file, or to the standard output.
+func writeDiff(db pkg.PackageDatabase, luetTree, file, format string) error {
+	existing := tree.NewGeneralRecipe(pkg.NewInMemoryDatabase(false))
+	if err := existing.Load(luetTree); err != nil {
+		return err
+	}
+	diff := gentoo.DiffTrees(existing.GetDatabase(), db)
+
+	w := os.Stdout
+	if file != "" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if format == "json" {
+		return diff.WriteJSON(w)
+	}
+	return diff.WriteText(w)
+}
+
+func writeReport(report *gentoo.ConversionReport, file, format string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if format == "junit" {
+		return report.WriteJUnit(f)
+	}
+	return report.WriteJSON(f)
+}
+
+// renderProgress returns a callback that logs the progress of the
+// conversion at most every progressInterval, and when it completes.
+func renderProgress() common.ProgressFunc {
+	var last time.Time
+	return func(p common.Progress) {
+		if p.Scanned < p.Total && time.Since(last) < progressInterval {
+			return
+		}
+		last = time.Now()
+		Info(p.String())
+	}
+}
+
 func init() {
-	convertCmd.Flags().String("type", "gentoo", "source type")
+	convertCmd.Flags().String("type", "gentoo", "source type (gentoo,alpine,arch,debian,rpm)")
 	convertCmd.Flags().String("database", "memory", "database used for solving (memory,boltdb)")
+	convertCmd.Flags().String("database-path", "", "file of the boltdb database, kept after the conversion (default temporary)")
+	convertCmd.Flags().Bool("resume", false, "resume the conversion, skipping the ebuilds already in the database")
+	convertCmd.Flags().Bool("from-database", false, "generate the luet tree from the packages of the database, without parsing the ebuilds")
+	convertCmd.Flags().String("profile", "", "conversion profile with the USE flags to enable (YAML)")
+	convertCmd.Flags().StringSlice("overlay", []string{}, "extra trees with the eclasses available to the ebuilds (e.g. the Gentoo tree)")
+	convertCmd.Flags().Bool("md5-cache", false, "read the metadata of the ebuilds from metadata/md5-cache when available")
+	convertCmd.Flags().String("slot-strategy", "category", "how the SLOT is mapped on the packages (category,name,none)")
+	convertCmd.Flags().String("arch", "", "arch of the keywords accepted, e.g. amd64")
+	convertCmd.Flags().Duration("timeout", 0, "maximum time spent on every ebuild or recipe (default 60s for ebuilds, 10s for recipes)")
+	convertCmd.Flags().String("mirror", "", "base url of the packages of the debian and rpm indexes")
+	convertCmd.Flags().String("stability", "any", "stability of the packages converted (stable,testing,any)")
+	convertCmd.Flags().String("mask-file", "", "package.mask file with the atoms to skip, in addition to the one of the tree")
+	convertCmd.Flags().Bool("best-version", false, "convert only the highest version of every slot")
+	convertCmd.Flags().StringSlice("include", []string{}, "packages to convert: atoms (cat/pkg), globs (dev-lang/*) or categories (dev-*)")
+	convertCmd.Flags().StringSlice("exclude", []string{}, "packages to skip: atoms, globs or categories")
+	convertCmd.Flags().String("atoms-file", "", "file with the packages to convert, one per line (! to exclude)")
+	convertCmd.Flags().Bool("with-deps", false, "convert also the dependencies of the included packages available in the tree")
+	convertCmd.Flags().Bool("sandbox-strict", false, "fail the ebuilds that run external commands in the global scope, instead of stubbing them")
+	convertCmd.Flags().StringSlice("sandbox-allow", []string{}, "external commands that the ebuilds can run in the global scope, e.g. sed,tr")
+	convertCmd.Flags().Bool("virtuals", false, "replace the virtual/* packages with their providers")
+	convertCmd.Flags().StringSlice("provider", []string{}, "preferred provider of a virtual, e.g. virtual/jdk=dev-java/openjdk-bin")
+	convertCmd.Flags().String("cache", "", "cache file of the scanned ebuilds, to convert only the ebuilds changed")
+	convertCmd.Flags().String("report", "", "file where the result of every ebuild is written")
+	convertCmd.Flags().String("report-format", "json", "format of the report (json,junit)")
+	convertCmd.Flags().Int("max-failures", -1, "exit with error when more ebuilds fail (-1 disables the check)")
+	convertCmd.Flags().String("diff", "", "compare the converted packages with an existing luet tree instead of saving them")
+	convertCmd.Flags().String("diff-format", "text", "format of the differences (text,json)")
+	convertCmd.Flags().String("diff-output", "", "file where the differences are written (default stdout)")
+	convertCmd.Flags().String("layout", "versions", "layout of the luet tree: a definition per version, or a collection per package (versions,collections,overlay)")
+	convertCmd.Flags().Bool("build-specs", false, "generate the build.yaml of every package")
+	convertCmd.Flags().String("build-template", "", "template of the generated build.yaml (default builtin)")
+	convertCmd.Flags().String("build-image", gentoo.DefaultBuildImage, "image used to build the packages without build requires")
+	convertCmd.Flags().String("build-keywords", "", "keywords accepted on build, e.g. ~amd64")
 
 	RootCmd.AddCommand(convertCmd)
 }
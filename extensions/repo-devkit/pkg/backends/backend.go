@@ -0,0 +1,92 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+package backends
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+)
+
+// BackendFactory creates a backend handler from the URL selected by the
+// user. The backend options are read from the specs backends section.
+type BackendFactory func(s *specs.LuetRDConfig, u *url.URL) (specs.RepoBackendHandler, error)
+
+var backendFactories = make(map[string]BackendFactory, 0)
+
+// RegisterBackend registers the factory of the backend handler used for
+// the URLs with the input scheme.
+func RegisterBackend(scheme string, f BackendFactory) {
+	if _, ok := backendFactories[scheme]; ok {
+		panic(fmt.Sprintf("Backend with scheme %s already registered", scheme))
+	}
+	backendFactories[scheme] = f
+}
+
+// GetSchemes returns the sorted list of the registered schemes.
+func GetSchemes() []string {
+	ans := []string{}
+	for scheme := range backendFactories {
+		ans = append(ans, scheme)
+	}
+	sort.Strings(ans)
+	return ans
+}
+
+// NewBackend creates the backend handler of the input URL. A string without
+// a scheme is considered a local path.
+func NewBackend(s *specs.LuetRDConfig, backendUrl string) (specs.RepoBackendHandler, error) {
+	if backendUrl == "" {
+		return nil, errors.New("Invalid backend url")
+	}
+
+	var u *url.URL
+	var err error
+
+	if strings.Contains(backendUrl, "://") {
+		u, err = url.Parse(backendUrl)
+		if err != nil {
+			return nil, errors.New(
+				fmt.Sprintf("Error on parse backend url %s: %s", backendUrl, err.Error()))
+		}
+	} else {
+		u = &url.URL{Scheme: "file", Path: backendUrl}
+	}
+
+	f, ok := backendFactories[u.Scheme]
+	if !ok {
+		return nil, errors.New(
+			fmt.Sprintf("Invalid backend scheme %s. Availables: %s",
+				u.Scheme, strings.Join(GetSchemes(), ", ")))
+	}
+
+	return f(s, u)
+}
+
+// GetInstance returns the name of the backend instance selected with
+// the instance parameter of the URL.
+func GetInstance(u *url.URL) string {
+	if instance := u.Query().Get("instance"); instance != "" {
+		return instance
+	}
+	return specs.DefaultBackendInstance
+}
@@ -0,0 +1,112 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+package backends_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	backends "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/backends"
+	"github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+)
+
+func TestNewBackend(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "repo-devkit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// The relative paths are resolved from the working directory.
+	if err := os.MkdirAll(filepath.Join(tmpdir, "rel", "path"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(tmpdir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// A fake S3 server with all the buckets.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	s := specs.NewLuetRDConfig()
+	s.GetBackends().SetMinio(specs.DefaultBackendInstance, &specs.LuetRDCMinio{
+		Endpoint:   strings.TrimPrefix(server.URL, "http://"),
+		KeyId:      "id",
+		Secret:     "secret",
+		Region:     "us-east-1",
+		DisableSsl: true,
+	})
+
+	for _, c := range []struct {
+		url    string
+		path   string
+		bucket string
+		prefix string
+		err    string
+	}{
+		{url: tmpdir, path: tmpdir},
+		{url: "file://" + tmpdir, path: tmpdir},
+		{url: "file://rel/path", path: "rel/path"},
+		{url: "file://missing/path", err: "Error on retrieve stat of the path missing/path"},
+		{url: "s3://bucket", bucket: "bucket", prefix: ""},
+		{url: "s3://bucket/repo/amd64/", bucket: "bucket", prefix: "repo/amd64/"},
+		{url: "minio://bucket//repo", bucket: "bucket", prefix: "repo/"},
+		{url: "s3://bucket/repo?instance=unknown", err: "No minio backend instance with name unknown"},
+		{url: "ftp://host/repo", err: "Invalid backend scheme ftp"},
+		{url: "", err: "Invalid backend url"},
+	} {
+		b, err := backends.NewBackend(s, c.url)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: expected error %q, got %v", c.url, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", c.url, err.Error())
+			continue
+		}
+
+		switch h := b.(type) {
+		case *backends.BackendLocal:
+			if h.Path != c.path {
+				t.Errorf("%s: expected path %s, got %s", c.url, c.path, h.Path)
+			}
+		case *backends.BackendMinio:
+			if h.Bucket != c.bucket || h.Prefix != c.prefix {
+				t.Errorf("%s: expected bucket %s and prefix %q, got %s and %q",
+					c.url, c.bucket, c.prefix, h.Bucket, h.Prefix)
+			}
+		default:
+			t.Errorf("%s: unexpected backend %T", c.url, b)
+		}
+	}
+}
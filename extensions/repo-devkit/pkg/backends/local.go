@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
 
@@ -34,6 +35,14 @@ type BackendLocal struct {
 	Path  string
 }
 
+func init() {
+	RegisterBackend("file",
+		func(s *specs.LuetRDConfig, u *url.URL) (specs.RepoBackendHandler, error) {
+			// Host is not empty with relative paths like file://repo/amd64
+			return NewBackendLocal(s, u.Host+u.Path)
+		})
+}
+
 func NewBackendLocal(specs *specs.LuetRDConfig, path string) (*BackendLocal, error) {
 	if path == "" {
 		return nil, errors.New("Invalid path")
@@ -53,7 +62,8 @@ func NewBackendLocal(specs *specs.LuetRDConfig, path string) (*BackendLocal, err
 	}
 
 	ans := &BackendLocal{
-		Path: path,
+		Specs: specs,
+		Path:  path,
 	}
 
 	return ans, nil
@@ -23,7 +23,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
+	"strings"
 
 	"github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
 
@@ -33,81 +35,83 @@ import (
 )
 
 type BackendMinio struct {
-	Specs        *specs.LuetRDConfig
-	ArtefactPath string
+	Specs  *specs.LuetRDConfig
+	Prefix string
 
 	MinioClient *minio.Client
 	Bucket      string
 }
 
-func NewBackendMinio(specs *specs.LuetRDConfig, path string, opts map[string]string) (*BackendMinio, error) {
+func init() {
+	newBackend := func(s *specs.LuetRDConfig, u *url.URL) (specs.RepoBackendHandler, error) {
+		return NewBackendMinio(s, GetInstance(u), u.Host, u.Path)
+	}
+	RegisterBackend("s3", newBackend)
+	RegisterBackend("minio", newBackend)
+}
 
-	if path != "" {
-		_, err := os.Stat(path)
-		if err != nil {
-			return nil, errors.New(
-				fmt.Sprintf(
-					"Error on retrieve stat of the path %s: %s",
-					path, err.Error(),
-				))
-		}
+func NewBackendMinio(s *specs.LuetRDConfig, instance, bucket, prefix string) (*BackendMinio, error) {
+	opts, err := s.GetBackends().GetMinio(instance)
+	if err != nil {
+		return nil, err
+	}
 
-		if os.IsNotExist(err) {
-			return nil, errors.New("The path doesn't exist!")
-		}
+	if bucket == "" {
+		bucket = os.Getenv("MINIO_BUCKET")
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = os.Getenv("MINIO_URL")
+	}
+	if opts.KeyId == "" {
+		opts.KeyId = os.Getenv("MINIO_ID")
+	}
+	if opts.Secret == "" {
+		opts.Secret = os.Getenv("MINIO_SECRET")
 	}
 
-	if _, ok := opts["minio-bucket"]; !ok {
+	if bucket == "" {
 		return nil, errors.New("Minio bucket is mandatory")
 	}
 
-	if _, ok := opts["minio-endpoint"]; !ok {
+	if opts.Endpoint == "" {
 		return nil, errors.New("Minio endpoint is mandatory")
 	}
 
-	if _, ok := opts["minio-keyid"]; !ok {
+	if opts.KeyId == "" {
 		return nil, errors.New("Minio key ID is mandatory")
 	}
 
-	if _, ok := opts["minio-secret"]; !ok {
+	if opts.Secret == "" {
 		return nil, errors.New("Minio secret Access key is mandatory")
 	}
 
-	ans := &BackendMinio{
-		Specs:        specs,
-		ArtefactPath: path,
-		Bucket:       opts["minio-bucket"],
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
 	}
 
-	minioRegion := ""
-	minioSsl := true
-	if _, ok := opts["minio-region"]; ok {
-		minioRegion = opts["minio-region"]
-	}
-
-	if _, ok := opts["minio-ssl"]; ok {
-		if opts["minio-ssl"] == "false" {
-			minioSsl = false
-		}
+	ans := &BackendMinio{
+		Specs:  s,
+		Prefix: prefix,
+		Bucket: bucket,
 	}
 
 	var mClient *minio.Client
-	var err error
 
 	mOpts := &minio.Options{
 		Creds: credentials.NewStaticV4(
-			opts["minio-keyid"],
-			opts["minio-secret"],
+			opts.KeyId,
+			opts.Secret,
 			"",
 		),
-		Secure: minioSsl,
+		Secure: !opts.DisableSsl,
 	}
-	if minioRegion != "" {
-		mOpts.Region = minioRegion
+	if opts.Region != "" {
+		mOpts.Region = opts.Region
 	}
 
 	mClient, err = minio.New(
-		opts["minio-endpoint"],
+		opts.Endpoint,
 		mOpts,
 	)
 	if err != nil {
@@ -134,7 +138,7 @@ func (b *BackendMinio) GetFilesList() ([]string, error) {
 	ans := []string{}
 	opts := minio.ListObjectsOptions{
 		Recursive: true,
-		Prefix:    "",
+		Prefix:    b.Prefix,
 	}
 
 	// List all objects from a bucket-name with a matching prefix.
@@ -143,7 +147,7 @@ func (b *BackendMinio) GetFilesList() ([]string, error) {
 			return ans, errors.New("Error on retrieve list of objects: " + object.Err.Error())
 		}
 
-		ans = append(ans, object.Key)
+		ans = append(ans, strings.TrimPrefix(object.Key, b.Prefix))
 	}
 
 	return ans, nil
@@ -153,7 +157,7 @@ func (b *BackendMinio) GetMetadata(file string) (*artifact.PackageArtifact, erro
 	var outBuffer bytes.Buffer
 
 	object, err := b.MinioClient.GetObject(
-		context.Background(), b.Bucket, file, minio.GetObjectOptions{},
+		context.Background(), b.Bucket, b.Prefix+file, minio.GetObjectOptions{},
 	)
 	if err != nil {
 		return nil, err
@@ -173,5 +177,5 @@ func (b *BackendMinio) CleanFile(file string) error {
 		GovernanceBypass: true,
 	}
 	return b.MinioClient.RemoveObject(context.Background(),
-		b.Bucket, file, opts)
+		b.Bucket, b.Prefix+file, opts)
 }
@@ -21,7 +21,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"os"
+	"net/url"
 	"path"
 	"strings"
 
@@ -38,15 +38,21 @@ import (
 )
 
 type BackendMottainai struct {
-	Specs        *specs.LuetRDConfig
-	ArtefactPath string
+	Specs *specs.LuetRDConfig
 
 	Config          *setting.Config
 	MottainaiClient client.HttpClient
 	Namespace       string
 }
 
-func setupMottainaiCliConfig(opts map[string]string) (*setting.Config, error) {
+func init() {
+	RegisterBackend("mottainai",
+		func(s *specs.LuetRDConfig, u *url.URL) (specs.RepoBackendHandler, error) {
+			return NewBackendMottainai(s, GetInstance(u), u.Host)
+		})
+}
+
+func setupMottainaiCliConfig(opts *specs.LuetRDCMottainai) (*setting.Config, error) {
 	var err error
 
 	config := setting.NewConfig(nil)
@@ -78,7 +84,7 @@ func setupMottainaiCliConfig(opts map[string]string) (*setting.Config, error) {
 	}
 
 	configured := false
-	if pName, ok := opts["mottainai-profile"]; ok {
+	if opts.Profile != "" {
 
 		var conf common.ProfileConf
 		var profile *common.Profile
@@ -87,7 +93,7 @@ func setupMottainaiCliConfig(opts map[string]string) (*setting.Config, error) {
 			return nil, err
 		}
 
-		profile, err = conf.GetProfile(pName)
+		profile, err = conf.GetProfile(opts.Profile)
 
 		if profile != nil {
 			config.Viper.Set("master", profile.GetMaster())
@@ -98,7 +104,7 @@ func setupMottainaiCliConfig(opts map[string]string) (*setting.Config, error) {
 			return nil,
 				errors.New(
 					fmt.Sprintf(
-						"No profile with name %s. I use default value.\n", pName),
+						"No profile with name %s. I use default value.\n", opts.Profile),
 				)
 		}
 
@@ -107,36 +113,26 @@ func setupMottainaiCliConfig(opts map[string]string) (*setting.Config, error) {
 	}
 
 	if !configured {
-		if master, ok := opts["mottainai-master"]; ok {
-			config.Viper.Set("master", master)
+		if opts.Master != "" {
+			config.Viper.Set("master", opts.Master)
 		}
 
-		if apikey, ok := opts["mottainai-apikey"]; ok {
-			config.Viper.Set("apikey", apikey)
+		if opts.ApiKey != "" {
+			config.Viper.Set("apikey", opts.ApiKey)
 		}
 	}
 
 	return config, nil
 }
 
-func NewBackendMottainai(specs *specs.LuetRDConfig, path string, opts map[string]string) (*BackendMottainai, error) {
-	if path != "" {
-		_, err := os.Stat(path)
-		if err != nil {
-			return nil, errors.New(
-				fmt.Sprintf(
-					"Error on retrieve stat of the path %s: %s",
-					path, err.Error(),
-				))
-		}
-
-		if os.IsNotExist(err) {
-			return nil, errors.New("The path doesn't exist!")
-		}
+func NewBackendMottainai(s *specs.LuetRDConfig, instance, namespace string) (*BackendMottainai, error) {
+	if namespace == "" {
+		return nil, errors.New("Mottainai namespace is mandatory")
 	}
 
-	if _, ok := opts["mottainai-namespace"]; !ok {
-		return nil, errors.New("Mottainai namespace is mandatory")
+	opts, err := s.GetBackends().GetMottainai(instance)
+	if err != nil {
+		return nil, err
 	}
 
 	config, err := setupMottainaiCliConfig(opts)
@@ -145,15 +141,14 @@ func NewBackendMottainai(specs *specs.LuetRDConfig, path string, opts map[string
 	}
 
 	ans := &BackendMottainai{
-		Specs:        specs,
-		ArtefactPath: path,
-		Config:       config,
+		Specs:  s,
+		Config: config,
 		MottainaiClient: client.NewTokenClient(
 			config.Viper.GetString("master"),
 			config.Viper.GetString("apikey"),
 			config,
 		),
-		Namespace: opts["mottainai-namespace"],
+		Namespace: namespace,
 	}
 
 	return ans, nil
@@ -0,0 +1,189 @@
+/*
+Copyright (C) 2020-2021  Daniele Rondina <geaaru@sabayonlinux.org>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	backends "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/backends"
+	specs "github.com/Luet-lab/extensions/extensions/repo-devkit/pkg/specs"
+
+	cobra "github.com/spf13/cobra"
+)
+
+func addBackendFlags(cmd *cobra.Command) {
+	var flags = cmd.Flags()
+
+	flags.String("backend-url", "",
+		fmt.Sprintf("URL of the repository backend (%s). Example: s3://bucket/path.",
+			strings.Join(backends.GetSchemes(), "|")))
+
+	flags.StringP("backend", "b", "local", "Select backend repository: local|mottainai|minio.")
+	flags.StringP("path", "p", "", "Path of the repository artefacts.")
+	flags.MarkDeprecated("backend", "use --backend-url instead.")
+	flags.MarkDeprecated("path", "use --backend-url instead.")
+
+	// Mottainai options
+	flags.String("mottainai-profile", "", "Set mottainai profile to use.")
+	flags.String("mottainai-master", "", "Set mottainai Server to use.")
+	flags.String("mottainai-apikey", "", "Set mottainai API Key to use.")
+	flags.String("mottainai-namespace", "", "Set mottainai namespace to use.")
+
+	// Minio options
+	flags.String("minio-bucket", "",
+		"Set minio bucket to use or set env MINIO_BUCKET.")
+	flags.String("minio-endpoint", "",
+		"Set minio endpoint to use or set env MINIO_URL.")
+	flags.String("minio-keyid", "",
+		"Set minio Access Key to use or set env MINIO_ID.")
+	flags.String("minio-secret", "",
+		"Set minio Access Key to use or set env MINIO_SECRET.")
+	flags.String("minio-region", "", "Optinally define the minio region.")
+}
+
+// getBackendUrl returns the URL of the backend to use and overrides the
+// options of the backend instance selected by the URL with the options
+// from the command line.
+func getBackendUrl(cmd *cobra.Command, s *specs.LuetRDConfig) (string, error) {
+	backendUrl, err := selectBackendUrl(cmd, s)
+	if err != nil {
+		return "", err
+	}
+
+	instance := specs.DefaultBackendInstance
+	if strings.Contains(backendUrl, "://") {
+		u, err := url.Parse(backendUrl)
+		if err != nil {
+			return "", errors.New(
+				fmt.Sprintf("Error on parse backend url %s: %s", backendUrl, err.Error()))
+		}
+		instance = backends.GetInstance(u)
+	}
+
+	if err := setMottainaiOptions(cmd, s, instance); err != nil {
+		return "", err
+	}
+	if err := setMinioOptions(cmd, s, instance); err != nil {
+		return "", err
+	}
+
+	return backendUrl, nil
+}
+
+// selectBackendUrl returns the URL from the command line, from the specs
+// or translated from the deprecated backend options.
+func selectBackendUrl(cmd *cobra.Command, s *specs.LuetRDConfig) (string, error) {
+	var flags = cmd.Flags()
+
+	backendUrl, _ := flags.GetString("backend-url")
+	backend, _ := flags.GetString("backend")
+	path, _ := flags.GetString("path")
+	mottainaiNamespace, _ := flags.GetString("mottainai-namespace")
+	minioBucket, _ := flags.GetString("minio-bucket")
+
+	if backendUrl != "" {
+		return backendUrl, nil
+	}
+
+	if s.GetBackends().Url != "" &&
+		!flags.Changed("backend") && !flags.Changed("path") {
+		return s.GetBackends().Url, nil
+	}
+
+	// Translate the old backend options to the URL.
+	switch backend {
+	case "local":
+		return path, nil
+	case "mottainai":
+		return "mottainai://" + mottainaiNamespace, nil
+	case "minio":
+		return "s3://" + minioBucket, nil
+	default:
+		return "", errors.New("Invalid backend " + backend)
+	}
+}
+
+// setMottainaiOptions overrides the options of the mottainai backend
+// instance with the options from the command line.
+func setMottainaiOptions(cmd *cobra.Command, s *specs.LuetRDConfig, instance string) error {
+	var flags = cmd.Flags()
+
+	mottainaiProfile, _ := flags.GetString("mottainai-profile")
+	mottainaiMaster, _ := flags.GetString("mottainai-master")
+	mottainaiApiKey, _ := flags.GetString("mottainai-apikey")
+
+	if mottainaiProfile == "" && mottainaiMaster == "" && mottainaiApiKey == "" {
+		return nil
+	}
+
+	m, err := s.GetBackends().GetMottainai(instance)
+	if err != nil {
+		return err
+	}
+	if mottainaiProfile != "" {
+		m.Profile = mottainaiProfile
+	}
+	if mottainaiMaster != "" {
+		m.Master = mottainaiMaster
+	}
+	if mottainaiApiKey != "" {
+		m.ApiKey = mottainaiApiKey
+	}
+	s.GetBackends().SetMottainai(instance, m)
+
+	return nil
+}
+
+// setMinioOptions overrides the options of the minio backend instance
+// with the options from the command line.
+func setMinioOptions(cmd *cobra.Command, s *specs.LuetRDConfig, instance string) error {
+	var flags = cmd.Flags()
+
+	minioAccessId, _ := flags.GetString("minio-keyid")
+	minioSecret, _ := flags.GetString("minio-secret")
+	minioEndpoint, _ := flags.GetString("minio-endpoint")
+	minioRegion, _ := flags.GetString("minio-region")
+
+	if minioAccessId == "" && minioSecret == "" && minioEndpoint == "" && minioRegion == "" {
+		return nil
+	}
+
+	minio, err := s.GetBackends().GetMinio(instance)
+	if err != nil {
+		return err
+	}
+	if minioEndpoint != "" {
+		minio.Endpoint = minioEndpoint
+	}
+	if minioAccessId != "" {
+		minio.KeyId = minioAccessId
+	}
+	if minioSecret != "" {
+		minio.Secret = minioSecret
+	}
+	if minioRegion != "" {
+		minio.Region = minioRegion
+	}
+	s.GetBackends().SetMinio(instance, minio)
+
+	return nil
+}
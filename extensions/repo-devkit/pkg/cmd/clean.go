@@ -45,21 +45,9 @@ func NewCleanCommand() *cobra.Command {
 			var err error
 
 			specsFile, _ := cmd.Flags().GetString("specs-file")
-			backend, _ := cmd.Flags().GetString("backend")
-			path, _ := cmd.Flags().GetString("path")
 			treePath, _ := cmd.Flags().GetStringArray("tree")
 			dryRun, _ := cmd.Flags().GetBool("dry-run")
 			quiet, _ := cmd.Flags().GetBool("quiet")
-			mottainaiProfile, _ := cmd.Flags().GetString("mottainai-profile")
-			mottainaiMaster, _ := cmd.Flags().GetString("mottainai-master")
-			mottainaiApiKey, _ := cmd.Flags().GetString("mottainai-apikey")
-			mottainaiNamespace, _ := cmd.Flags().GetString("mottainai-namespace")
-
-			minioBucket, _ := cmd.Flags().GetString("minio-bucket")
-			minioAccessId, _ := cmd.Flags().GetString("minio-keyid")
-			minioSecret, _ := cmd.Flags().GetString("minio-secret")
-			minioEndpoint, _ := cmd.Flags().GetString("minio-endpoint")
-			minioRegion, _ := cmd.Flags().GetString("minio-region")
 
 			if specsFile == "" {
 				s = specs.NewLuetRDConfig()
@@ -71,51 +59,13 @@ func NewCleanCommand() *cobra.Command {
 				}
 			}
 
-			opts := make(map[string]string, 0)
-			if backend == "mottainai" {
-				if mottainaiProfile != "" {
-					opts["mottainai-profile"] = mottainaiProfile
-				}
-				if mottainaiMaster != "" {
-					opts["mottainai-master"] = mottainaiMaster
-				}
-				if mottainaiApiKey != "" {
-					opts["mottainai-apikey"] = mottainaiApiKey
-				}
-				if mottainaiNamespace != "" {
-					opts["mottainai-namespace"] = mottainaiNamespace
-				}
-			} else if backend == "minio" {
-
-				if minioEndpoint != "" {
-					opts["minio-endpoint"] = minioEndpoint
-				} else {
-					opts["minio-endpoint"] = os.Getenv("MINIO_URL")
-				}
-
-				if minioBucket != "" {
-					opts["minio-bucket"] = minioBucket
-				} else {
-					opts["minio-bucket"] = os.Getenv("MINIO_BUCKET")
-				}
-
-				if minioAccessId != "" {
-					opts["minio-keyid"] = minioAccessId
-				} else {
-					opts["minio-keyid"] = os.Getenv("MINIO_ID")
-				}
-
-				if minioSecret != "" {
-					opts["minio-secret"] = minioSecret
-				} else {
-					opts["minio-secret"] = os.Getenv("MINIO_SECRET")
-				}
-
-				opts["minio-region"] = minioRegion
-
+			backendUrl, err := getBackendUrl(cmd, s)
+			if err != nil {
+				fmt.Println("Error on setup backend: " + err.Error())
+				os.Exit(1)
 			}
 
-			repoCleaner, err := devkit.NewRepoCleaner(s, backend, path, opts, dryRun)
+			repoCleaner, err := devkit.NewRepoCleaner(s, backendUrl, dryRun)
 			if err != nil {
 				fmt.Println("Error on initialize repo cleaner: " + err.Error())
 				os.Exit(1)
@@ -155,25 +105,9 @@ func NewCleanCommand() *cobra.Command {
 	}
 
 	var flags = cmd.Flags()
-	flags.StringP("backend", "b", "local", "Select backend repository: local|mottainai.")
-	flags.StringP("path", "p", "", "Path of the repository artefacts.")
+	addBackendFlags(cmd)
 	flags.Bool("dry-run", false, "Only check files to remove.")
 	flags.Bool("quiet", false, "Quiet output.")
-	flags.String("mottainai-profile", "", "Set mottainai profile to use.")
-	flags.String("mottainai-master", "", "Set mottainai Server to use.")
-	flags.String("mottainai-apikey", "", "Set mottainai API Key to use.")
-	flags.String("mottainai-namespace", "", "Set mottainai namespace to use.")
-
-	// Minio options
-	flags.String("minio-bucket", "",
-		"Set minio bucket to use or set env MINIO_BUCKET.")
-	flags.String("minio-endpoint", "",
-		"Set minio endpoint to use or set env MINIO_URL.")
-	flags.String("minio-keyid", "",
-		"Set minio Access Key to use or set env MINIO_ID.")
-	flags.String("minio-secret", "",
-		"Set minio Access Key to use or set env MINIO_SECRET.")
-	flags.String("minio-region", "", "Optinally define the minio region.")
 
 	return cmd
 }
@@ -32,10 +32,9 @@ type RepoCleaner struct {
 }
 
 func NewRepoCleaner(s *specs.LuetRDConfig,
-	backend, path string, opts map[string]string,
-	dryRun bool) (*RepoCleaner, error) {
+	backendUrl string, dryRun bool) (*RepoCleaner, error) {
 
-	knife, err := NewRepoKnife(s, backend, path, opts)
+	knife, err := NewRepoKnife(s, backendUrl)
 	if err != nil {
 		return nil, err
 	}
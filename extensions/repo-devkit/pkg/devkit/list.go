@@ -35,10 +35,9 @@ type RepoList struct {
 	*RepoKnife
 }
 
-func NewRepoList(s *specs.LuetRDConfig,
-	backend, path string, opts map[string]string) (*RepoList, error) {
+func NewRepoList(s *specs.LuetRDConfig, backendUrl string) (*RepoList, error) {
 
-	knife, err := NewRepoKnife(s, backend, path, opts)
+	knife, err := NewRepoKnife(s, backendUrl)
 	if err != nil {
 		return nil, err
 	}
@@ -46,33 +46,20 @@ type RepoKnife struct {
 	ProcessedFiles int
 }
 
-func NewRepoKnife(s *specs.LuetRDConfig,
-	backend, path string, opts map[string]string) (*RepoKnife, error) {
-	var err error
-	var handler specs.RepoBackendHandler
+func NewRepoKnife(s *specs.LuetRDConfig, backendUrl string) (*RepoKnife, error) {
+	handler, err := backends.NewBackend(s, backendUrl)
+	if err != nil {
+		return nil, err
+	}
 
 	ans := &RepoKnife{
 		Specs:          s,
+		BackendHandler: handler,
 		ReciperRuntime: luet_tree.NewInstallerRecipe(luet_pkg.NewInMemoryDatabase(false)),
 		PkgsMap:        make(map[string]string, 0),
 		MetaMap:        make(map[string]*artifact.PackageArtifact, 0),
 	}
 
-	switch backend {
-	case "local":
-		handler, err = backends.NewBackendLocal(s, path)
-	case "mottainai":
-		handler, err = backends.NewBackendMottainai(s, path, opts)
-	case "minio":
-		handler, err = backends.NewBackendMinio(s, path, opts)
-	default:
-		return nil, errors.New("Invalid backend")
-	}
-
-	if err != nil {
-		return nil, err
-	}
-	ans.BackendHandler = handler
 	return ans, nil
 }
 
@@ -33,11 +33,18 @@ func NewLuetRDConfig() *LuetRDConfig {
 		Cleaner: LuetRDCCleaner{
 			Excludes: []string{},
 		},
+		Backends: LuetRDCBackends{
+			Minio:     make(map[string]LuetRDCMinio, 0),
+			Mottainai: make(map[string]LuetRDCMottainai, 0),
+		},
 	}
 }
 
 func (c *LuetRDConfig) GetCleaner() *LuetRDCCleaner { return &c.Cleaner }
 func (c *LuetRDConfig) GetList() *LuetRDCList       { return &c.List }
+func (c *LuetRDConfig) GetBackends() *LuetRDCBackends {
+	return &c.Backends
+}
 
 func (c *LuetRDCCleaner) HasExcludes() bool {
 	return len(c.Excludes) > 0
@@ -47,6 +54,48 @@ func (c *LuetRDCList) HasFilters() bool {
 	return len(c.ExcludePkgs) > 0
 }
 
+func (c *LuetRDCBackends) GetMinio(instance string) (*LuetRDCMinio, error) {
+	if c.Minio == nil {
+		c.Minio = make(map[string]LuetRDCMinio, 0)
+	}
+
+	m, ok := c.Minio[instance]
+	if !ok && instance != DefaultBackendInstance {
+		return nil, errors.New(
+			fmt.Sprintf("No minio backend instance with name %s", instance))
+	}
+
+	return &m, nil
+}
+
+func (c *LuetRDCBackends) SetMinio(instance string, m *LuetRDCMinio) {
+	if c.Minio == nil {
+		c.Minio = make(map[string]LuetRDCMinio, 0)
+	}
+	c.Minio[instance] = *m
+}
+
+func (c *LuetRDCBackends) GetMottainai(instance string) (*LuetRDCMottainai, error) {
+	if c.Mottainai == nil {
+		c.Mottainai = make(map[string]LuetRDCMottainai, 0)
+	}
+
+	m, ok := c.Mottainai[instance]
+	if !ok && instance != DefaultBackendInstance {
+		return nil, errors.New(
+			fmt.Sprintf("No mottainai backend instance with name %s", instance))
+	}
+
+	return &m, nil
+}
+
+func (c *LuetRDCBackends) SetMottainai(instance string, m *LuetRDCMottainai) {
+	if c.Mottainai == nil {
+		c.Mottainai = make(map[string]LuetRDCMottainai, 0)
+	}
+	c.Mottainai[instance] = *m
+}
+
 func (c *LuetPackage) GetName() string     { return c.Name }
 func (c *LuetPackage) GetCategory() string { return c.Category }
 func (c *LuetPackage) GetVersion() string  { return c.Version }
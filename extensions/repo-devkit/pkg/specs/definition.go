@@ -21,9 +21,15 @@ import (
 	artifact "github.com/mudler/luet/pkg/compiler/types/artifact"
 )
 
+const (
+	// Name of the backend instance used when the URL doesn't select one.
+	DefaultBackendInstance = "default"
+)
+
 type LuetRDConfig struct {
-	Cleaner LuetRDCCleaner `json:"cleaner,omitempty" yaml:"cleaner,omitempty"`
-	List    LuetRDCList    `json:"list,omitempty" yaml:"list,omitempty"`
+	Cleaner  LuetRDCCleaner  `json:"cleaner,omitempty" yaml:"cleaner,omitempty"`
+	List     LuetRDCList     `json:"list,omitempty" yaml:"list,omitempty"`
+	Backends LuetRDCBackends `json:"backends,omitempty" yaml:"backends,omitempty"`
 }
 
 type LuetRDCCleaner struct {
@@ -34,6 +40,26 @@ type LuetRDCList struct {
 	ExcludePkgs []LuetPackage `json:"exclude_pkgs,omitempty" yaml:"exclude_pkgs,omitempty"`
 }
 
+type LuetRDCBackends struct {
+	Url       string                      `json:"url,omitempty" yaml:"url,omitempty"`
+	Minio     map[string]LuetRDCMinio     `json:"minio,omitempty" yaml:"minio,omitempty"`
+	Mottainai map[string]LuetRDCMottainai `json:"mottainai,omitempty" yaml:"mottainai,omitempty"`
+}
+
+type LuetRDCMinio struct {
+	Endpoint   string `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	KeyId      string `json:"key_id,omitempty" yaml:"key_id,omitempty"`
+	Secret     string `json:"secret,omitempty" yaml:"secret,omitempty"`
+	Region     string `json:"region,omitempty" yaml:"region,omitempty"`
+	DisableSsl bool   `json:"disable_ssl,omitempty" yaml:"disable_ssl,omitempty"`
+}
+
+type LuetRDCMottainai struct {
+	Profile string `json:"profile,omitempty" yaml:"profile,omitempty"`
+	Master  string `json:"master,omitempty" yaml:"master,omitempty"`
+	ApiKey  string `json:"apikey,omitempty" yaml:"apikey,omitempty"`
+}
+
 type LuetPackage struct {
 	Name     string `json:"name" yaml:"name"`
 	Category string `json:"category" yaml:"category"`